@@ -0,0 +1,104 @@
+// Copyright 2017 Danny van Kooten. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package extemplate
+
+import (
+	"sort"
+	"strings"
+)
+
+// Alias registers alias as another name of the template with the given name, like "home" for "pages/index.tmpl",
+// so that application code can use stable names while templates are moved around.
+// Aliases can be used wherever a template name is accepted, as well as in extends directives and template actions.
+// Aliases used in directives and actions must be registered before parsing the templates using them.
+// The return value is the Extemplate instance, so calls can be chained.
+func (x *Extemplate) Alias(alias string, name string) *Extemplate {
+	name = x.aliased(name)
+
+	x.aliasMu.Lock()
+	defer x.aliasMu.Unlock()
+	if x.aliases == nil {
+		x.aliases = make(map[string]string)
+	}
+	x.aliases[x.normalize(alias)] = name
+	return x
+}
+
+// target returns the name of the template the given normalized alias refers to, or "" if it is not an alias
+func (x *Extemplate) target(alias string) string {
+	x.aliasMu.RLock()
+	defer x.aliasMu.RUnlock()
+	return x.aliases[alias]
+}
+
+// aliased returns the normalized name of the template with the given name or alias
+func (x *Extemplate) aliased(name string) string {
+	name = x.normalize(name)
+	if t := x.target(name); t != "" {
+		return t
+	}
+	return name
+}
+
+// resolve returns the name under which the template with the given name, alias or directory is registered, see WithDirectoryIndex.
+// The caller must not hold x.mu.
+func (x *Extemplate) resolve(name string) string {
+	name = x.aliased(name)
+	if len(x.indexes) == 0 {
+		return name
+	}
+
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	if _, ok := x.files[name]; ok && !strings.HasSuffix(name, "/") {
+		return name
+	}
+	dir := strings.TrimSuffix(name, "/")
+	for _, index := range x.indexes {
+		n := x.normalize(index)
+		if dir != "" {
+			n = dir + "/" + n
+		}
+		if _, ok := x.files[n]; ok {
+			return n
+		}
+	}
+	return name
+}
+
+// addAliasTrees adds the trees of aliased shared templates to the shared sets under their alias,
+// so that template actions can invoke them by alias. The caller must hold x.mu for writing.
+func (x *Extemplate) addAliasTrees() error {
+	x.aliasMu.RLock()
+	aliases := make([]string, 0, len(x.aliases))
+	for alias := range x.aliases {
+		aliases = append(aliases, alias)
+	}
+	sort.Strings(aliases)
+	x.aliasMu.RUnlock()
+
+	for _, alias := range aliases {
+		name := x.target(alias)
+		tf, ok := x.files[name]
+		if _, exists := x.files[alias]; exists || !ok || tf.layout != "" {
+			continue
+		}
+
+		if x.isText(tf) {
+			if t := x.text.Lookup(name); t != nil && t.Tree != nil {
+				if _, err := x.text.AddParseTree(alias, t.Tree); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if t := x.shared.Lookup(name); t != nil && t.Tree != nil {
+			if _, err := x.shared.AddParseTree(alias, t.Tree); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
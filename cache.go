@@ -0,0 +1,203 @@
+// Copyright 2017 Danny van Kooten. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package extemplate
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"fmt"
+	"html/template"
+	"io"
+	"sync"
+	"time"
+)
+
+// Cache stores rendered template output
+type Cache interface {
+	// Get returns the value stored for key, if it exists and has not expired
+	Get(key string) ([]byte, bool)
+	// Set stores value for key, expiring it after ttl. A ttl of 0 means the value does not expire.
+	Set(key string, value []byte, ttl time.Duration)
+}
+
+// DefaultCacheSize is the maximum number of entries in the cache used when no cache is set
+const DefaultCacheSize = 1000
+
+type lruEntry struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+// lruCache is an in-memory Cache evicting the least recently used entry when full
+type lruCache struct {
+	mu    sync.Mutex
+	max   int
+	ll    *list.List
+	items map[string]*list.Element
+}
+
+// NewLRUCache returns an in-memory Cache holding at most max entries.
+// When full, the least recently used entry is evicted.
+func NewLRUCache(max int) Cache {
+	return &lruCache{
+		max:   max,
+		ll:    list.New(),
+		items: make(map[string]*list.Element),
+	}
+}
+
+func (c *lruCache) Get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+
+	e := el.Value.(*lruEntry)
+	if !e.expires.IsZero() && time.Now().After(e.expires) {
+		c.ll.Remove(el)
+		delete(c.items, key)
+		return nil, false
+	}
+
+	c.ll.MoveToFront(el)
+	return e.value, true
+}
+
+func (c *lruCache) Set(key string, value []byte, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e := &lruEntry{key: key, value: value}
+	if ttl > 0 {
+		e.expires = time.Now().Add(ttl)
+	}
+
+	if el, ok := c.items[key]; ok {
+		el.Value = e
+		c.ll.MoveToFront(el)
+		return
+	}
+
+	c.items[key] = c.ll.PushFront(e)
+	for c.max > 0 && c.ll.Len() > c.max {
+		el := c.ll.Back()
+		c.ll.Remove(el)
+		delete(c.items, el.Value.(*lruEntry).key)
+	}
+}
+
+// Purge removes all entries from the cache
+func (c *lruCache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ll.Init()
+	c.items = make(map[string]*list.Element)
+}
+
+// purge removes all entries from c, if it supports it
+func purge(c Cache) {
+	if c == nil {
+		return
+	}
+	if p, ok := c.(interface{ Purge() }); ok {
+		p.Purge()
+	}
+}
+
+// SetFragmentCache sets the cache used by the cache template func.
+// By default, fragments are cached in an LRU cache of DefaultCacheSize entries.
+// Whenever templates are recompiled, the cache is purged if it has a Purge method.
+// The cache is not used in the Dev environment, see WithEnvironment.
+// The return value is the Extemplate instance, so calls can be chained.
+func (x *Extemplate) SetFragmentCache(c Cache) *Extemplate {
+	x.fragments = c
+	return x
+}
+
+// WithResponseCache enables caching the output of ExecuteTemplateCached in an LRU cache of at most maxEntries entries,
+// expiring after ttl. A ttl of 0 means entries only expire when templates are recompiled.
+func WithResponseCache(maxEntries int, ttl time.Duration) Option {
+	return func(x *Extemplate) {
+		x.responses = NewLRUCache(maxEntries)
+		x.responseTTL = ttl
+	}
+}
+
+// ExecuteTemplateCached is like ExecuteTemplate, but serves the output from the response cache
+// if the named template was executed with the same key before. The key should identify the data.
+// If no response cache is configured using WithResponseCache, or caches are disabled by the Dev environment,
+// it is equivalent to ExecuteTemplate.
+func (x *Extemplate) ExecuteTemplateCached(wr io.Writer, name string, key string, data interface{}) error {
+	if x.responses == nil || x.noCache {
+		return x.ExecuteTemplate(wr, name, data)
+	}
+
+	cacheKey := x.resolve(name) + "\x00" + key
+	if b, ok := x.responses.Get(cacheKey); ok {
+		x.log(levelDebug, "extemplate: response cache hit", "template", name, "key", key)
+		_, err := wr.Write(b)
+		return err
+	}
+	x.log(levelDebug, "extemplate: response cache miss", "template", name, "key", key)
+
+	var buf bytes.Buffer
+	if err := x.ExecuteTemplate(&buf, name, data); err != nil {
+		return err
+	}
+	x.responses.Set(cacheKey, buf.Bytes(), x.responseTTL)
+	_, err := buf.WriteTo(wr)
+	return err
+}
+
+// cacheFunc returns the cache template func, which renders the named template with data
+// and caches its output under key for the given duration, e.g.
+//
+//	{{ cache "sidebar" "5m" "partials/sidebar.tmpl" . }}
+//
+// The key should identify everything the output depends on, e.g. (printf "sidebar-%d" .User.ID).
+func (x *Extemplate) cacheFunc(ctx context.Context) func(key string, ttl interface{}, name string, data interface{}) (template.HTML, error) {
+	return func(key string, ttl interface{}, name string, data interface{}) (template.HTML, error) {
+		d, err := toDuration(ttl)
+		if err != nil {
+			return "", err
+		}
+
+		// the cache is disabled in the Dev environment
+		cacheKey := x.resolve(name) + "\x00" + key
+		if !x.noCache {
+			if b, ok := x.fragments.Get(cacheKey); ok {
+				x.log(levelDebug, "extemplate: fragment cache hit", "template", name, "key", key)
+				return template.HTML(b), nil
+			}
+			x.log(levelDebug, "extemplate: fragment cache miss", "template", name, "key", key)
+		}
+
+		var buf bytes.Buffer
+		if err := x.executeNested(ctx, &buf, name, data); err != nil {
+			return "", err
+		}
+		if !x.noCache {
+			x.fragments.Set(cacheKey, buf.Bytes(), d)
+		}
+		return template.HTML(buf.String()), nil
+	}
+}
+
+// toDuration converts a duration given as a string like "5m" or a time.Duration to a time.Duration
+func toDuration(v interface{}) (time.Duration, error) {
+	switch d := v.(type) {
+	case time.Duration:
+		return d, nil
+	case string:
+		return time.ParseDuration(d)
+	default:
+		return 0, fmt.Errorf("extemplate: invalid duration %v", v)
+	}
+}
@@ -0,0 +1,321 @@
+// Copyright 2017 Danny van Kooten. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	iofs "io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template/parse"
+	"time"
+
+	"github.com/dannyvankooten/extemplate"
+)
+
+func build(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("build", flag.ContinueOnError)
+	dataDir := fs.String("data", "", "directory of data files (default \"data\" in dir)")
+	out := fs.String("out", "public", "output directory")
+	watch := fs.Bool("watch", false, "keep running, re-rendering the pages affected by changed files")
+	interval := fs.Duration("interval", time.Second, "polling interval of -watch")
+	dir, extensions, err := parseFlags(fs, "build", args)
+	if err != nil {
+		return err
+	}
+	if *dataDir == "" {
+		*dataDir = filepath.Join(dir, "data")
+	}
+
+	s := &site{dir: dir, dataDir: *dataDir, out: *out, extensions: extensions}
+	n, err := s.build()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "rendered %d pages into %s\n", n, s.out)
+
+	for *watch {
+		time.Sleep(*interval)
+		n, err := s.rebuild()
+		if err != nil {
+			fmt.Fprintln(stdout, "error:", err)
+			continue
+		}
+		if n > 0 {
+			fmt.Fprintf(stdout, "rendered %d pages into %s\n", n, s.out)
+		}
+	}
+	return nil
+}
+
+// site renders the templates in dir as a static site, keeping track of what it rendered the pages with
+// so that it can re-render only the pages affected by changed files
+type site struct {
+	dir, dataDir, out string
+	extensions        []string
+
+	x    *extemplate.Extemplate
+	data map[string]interface{}
+	// hashes of the template files by template name, and of the data by key, as of the last build
+	files      map[string][sha256.Size]byte
+	dataHashes map[string][sha256.Size]byte
+}
+
+// build renders all pages and returns the number of pages rendered
+func (s *site) build() (int, error) {
+	x := extemplate.New()
+	if err := x.ParseDir(s.dir, s.extensions); err != nil {
+		return 0, err
+	}
+	data, err := loadData(x, s.dataDir)
+	if err != nil {
+		return 0, err
+	}
+	files, err := s.scan()
+	if err != nil {
+		return 0, err
+	}
+
+	s.x, s.data, s.files, s.dataHashes = x, data, files, hashData(data)
+	return s.render(pages(files))
+}
+
+// rebuild re-renders the pages affected by files changed since the last build, returning the number of pages rendered.
+// Removing a template file causes a full build, removing the pages rendered from it.
+func (s *site) rebuild() (int, error) {
+	files, err := s.scan()
+	if err != nil {
+		return 0, err
+	}
+	var changed []string
+	for name, h := range files {
+		if old, ok := s.files[name]; !ok || old != h {
+			changed = append(changed, name)
+		}
+	}
+	for name := range s.files {
+		if _, ok := files[name]; !ok {
+			if !isPartial(name) {
+				if err := os.Remove(s.x.ExportPath(s.out, name)); err != nil && !os.IsNotExist(err) {
+					return 0, err
+				}
+			}
+			return s.build()
+		}
+	}
+
+	// templates that did not change are not recompiled
+	if len(changed) > 0 {
+		if err := s.x.ParseDir(s.dir, s.extensions); err != nil {
+			return 0, err
+		}
+	}
+	data, err := loadData(s.x, s.dataDir)
+	if err != nil {
+		return 0, err
+	}
+	dataHashes := hashData(data)
+	changedKeys := make(map[string]bool)
+	for k, h := range dataHashes {
+		if old, ok := s.dataHashes[k]; !ok || old != h {
+			changedKeys[k] = true
+		}
+	}
+	for k := range s.dataHashes {
+		if _, ok := dataHashes[k]; !ok {
+			changedKeys[k] = true
+		}
+	}
+	s.files, s.data, s.dataHashes = files, data, dataHashes
+
+	affected := make(map[string]bool)
+	for _, name := range s.x.Affected(changed...) {
+		affected[name] = true
+	}
+	var names []string
+	for _, name := range pages(files) {
+		if affected[name] {
+			names = append(names, name)
+			continue
+		}
+		if len(changedKeys) == 0 {
+			continue
+		}
+		keys, all, err := dataKeys(s.x, name)
+		if err != nil {
+			return 0, err
+		}
+		for k := range changedKeys {
+			if all || keys[k] {
+				names = append(names, name)
+				break
+			}
+		}
+	}
+	return s.render(names)
+}
+
+// render renders the named pages
+func (s *site) render(names []string) (int, error) {
+	err := s.x.Export(s.out, names, func(name string) (interface{}, error) {
+		return map[string]interface{}{"Name": name, "Data": s.data}, nil
+	})
+	return len(names), err
+}
+
+// scan returns the hashes of all template files by template name
+func (s *site) scan() (map[string][sha256.Size]byte, error) {
+	files := make(map[string][sha256.Size]byte)
+	err := filepath.WalkDir(s.dir, func(path string, d iofs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !hasExtension(path, s.extensions) {
+			return err
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(s.dir, path)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)] = sha256.Sum256(b)
+		return nil
+	})
+	return files, err
+}
+
+// pages returns the sorted names of the templates in files that are rendered as pages
+func pages(files map[string][sha256.Size]byte) []string {
+	var names []string
+	for name := range files {
+		if !isPartial(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// loadData loads the data files in dir, which may not exist
+func loadData(x *extemplate.Extemplate, dir string) (map[string]interface{}, error) {
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return map[string]interface{}{}, nil
+	}
+	return x.LoadData(os.DirFS(dir))
+}
+
+// hashData returns the hashes of the JSON encoding of the values in data, by key
+func hashData(data map[string]interface{}) map[string][sha256.Size]byte {
+	hashes := make(map[string][sha256.Size]byte, len(data))
+	for k, v := range data {
+		// maps are encoded with sorted keys, so equal values have equal hashes
+		b, _ := json.Marshal(v)
+		hashes[k] = sha256.Sum256(b)
+	}
+	return hashes
+}
+
+// dataKeys returns the keys of .Data used by the named page and the templates it invokes,
+// or all if it uses .Data as a whole, for example by passing it to another template
+func dataKeys(x *extemplate.Extemplate, name string) (keys map[string]bool, all bool, err error) {
+	trees, err := x.ParseTrees(name)
+	if err != nil {
+		return nil, false, err
+	}
+
+	keys = make(map[string]bool)
+	seen := map[string]bool{name: true}
+	queue := []string{name}
+	var walk func(n parse.Node)
+	walk = func(n parse.Node) {
+		switch n := n.(type) {
+		case *parse.ListNode:
+			if n == nil {
+				return
+			}
+			for _, c := range n.Nodes {
+				walk(c)
+			}
+		case *parse.ActionNode:
+			walk(n.Pipe)
+		case *parse.IfNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.RangeNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.WithNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.TemplateNode:
+			if n.Pipe != nil {
+				walk(n.Pipe)
+			}
+			if !seen[n.Name] {
+				seen[n.Name] = true
+				queue = append(queue, n.Name)
+			}
+		case *parse.PipeNode:
+			if n == nil {
+				return
+			}
+			for _, c := range n.Cmds {
+				walk(c)
+			}
+		case *parse.CommandNode:
+			for _, arg := range n.Args {
+				walk(arg)
+			}
+		case *parse.ChainNode:
+			walk(n.Node)
+		case *parse.FieldNode:
+			useField(n.Ident, keys, &all)
+		case *parse.VariableNode:
+			if len(n.Ident) > 0 && n.Ident[0] == "$" {
+				useField(n.Ident[1:], keys, &all)
+			}
+		}
+	}
+	for len(queue) > 0 {
+		t := trees[queue[0]]
+		queue = queue[1:]
+		if t != nil {
+			walk(t.Root)
+		}
+	}
+	return keys, all, nil
+}
+
+// useField records the key of .Data used by the field chain ident, like Data.products
+func useField(ident []string, keys map[string]bool, all *bool) {
+	if len(ident) == 0 || ident[0] != "Data" {
+		return
+	}
+	if len(ident) == 1 {
+		*all = true
+		return
+	}
+	keys[ident[1]] = true
+}
+
+// isPartial reports whether the template with the given name is only used by other templates,
+// as its file or one of its directories starts with an underscore
+func isPartial(name string) bool {
+	for _, part := range strings.Split(name, "/") {
+		if strings.HasPrefix(part, "_") {
+			return true
+		}
+	}
+	return false
+}
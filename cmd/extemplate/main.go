@@ -0,0 +1,160 @@
+// Copyright 2017 Danny van Kooten. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// Command extemplate provides tooling for extemplate template directories.
+//
+// Usage:
+//
+//	extemplate lint [-ext .tmpl,.html] dir
+//	extemplate fmt [-ext .tmpl,.html] [-w] dir
+//	extemplate build [-ext .tmpl,.html] [-data dir] [-out dir] [-watch] dir
+//
+// The lint command reports blocks defined in a child template that do not exist in its layout chain,
+// and templates defined in more than one shared file. It exits with status 1 if any issues are found.
+//
+// The fmt command formats all template files in dir, see extemplate.Format.
+// It lists the files whose formatting differs and exits with status 1 if there are any,
+// or rewrites them when the -w flag is given.
+//
+// The build command renders all templates in dir as a static site into the -out directory, "public" by default,
+// see Extemplate.ExportAll. Templates in files or directories starting with an underscore, like "_layouts/base.tmpl",
+// are not rendered themselves. Templates are executed with the name of the template as .Name and the JSON files
+// in the -data directory, "data" in dir by default, as .Data: "data/products.json" is available as .Data.products.
+// With the -watch flag, it keeps running and re-renders only the pages affected by changed templates or data files.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	iofs "io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/dannyvankooten/extemplate"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+var commands = map[string]func(args []string, stdout io.Writer) error{
+	"lint":  lint,
+	"fmt":   format,
+	"build": build,
+}
+
+func run(args []string, stdout io.Writer, stderr io.Writer) int {
+	if len(args) == 0 || commands[args[0]] == nil {
+		fmt.Fprintln(stderr, "usage: extemplate lint [-ext .tmpl] dir")
+		fmt.Fprintln(stderr, "       extemplate fmt [-ext .tmpl] [-w] dir")
+		fmt.Fprintln(stderr, "       extemplate build [-ext .tmpl] [-data dir] [-out dir] [-watch] dir")
+		return 2
+	}
+
+	if err := commands[args[0]](args[1:], stdout); err != nil {
+		fmt.Fprintln(stderr, "extemplate:", err)
+		return 1
+	}
+	return 0
+}
+
+// errIssues is returned by commands that ran fine but found problems
+type errIssues int
+
+func (e errIssues) Error() string {
+	return fmt.Sprintf("%d issues found", int(e))
+}
+
+// parseFlags parses the flags shared by all commands and returns the directory to operate on.
+// Commands can define additional flags on fs before calling it.
+func parseFlags(fs *flag.FlagSet, name string, args []string) (dir string, extensions []string, err error) {
+	ext := fs.String("ext", ".tmpl", "comma-separated list of template file extensions")
+	if err := fs.Parse(args); err != nil {
+		return "", nil, err
+	}
+	if fs.NArg() != 1 {
+		return "", nil, fmt.Errorf("%s expects exactly 1 directory", name)
+	}
+	return fs.Arg(0), strings.Split(*ext, ","), nil
+}
+
+func lint(args []string, stdout io.Writer) error {
+	dir, extensions, err := parseFlags(flag.NewFlagSet("lint", flag.ContinueOnError), "lint", args)
+	if err != nil {
+		return err
+	}
+
+	issues, err := extemplate.New().LintFS(os.DirFS(dir), extensions)
+	if err != nil {
+		return err
+	}
+
+	for _, issue := range issues {
+		fmt.Fprintln(stdout, issue)
+	}
+	if len(issues) > 0 {
+		return errIssues(len(issues))
+	}
+	return nil
+}
+
+func format(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("fmt", flag.ContinueOnError)
+	write := fs.Bool("w", false, "write formatted files instead of listing them")
+	dir, extensions, err := parseFlags(fs, "fmt", args)
+	if err != nil {
+		return err
+	}
+
+	changed := 0
+	err = filepath.WalkDir(dir, func(path string, d iofs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !hasExtension(path, extensions) {
+			return err
+		}
+
+		src, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		out, err := extemplate.Format(src)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if bytes.Equal(src, out) {
+			return nil
+		}
+
+		changed++
+		if *write {
+			return os.WriteFile(path, out, 0644)
+		}
+		fmt.Fprintln(stdout, path)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if changed > 0 && !*write {
+		return fmt.Errorf("%d files are not formatted", changed)
+	}
+	return nil
+}
+
+// hasExtension reports whether path ends in one of the extensions, matching them like extemplate does:
+// case-insensitively and with an optional leading dot
+func hasExtension(path string, extensions []string) bool {
+	for _, ext := range extensions {
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		if strings.HasSuffix(strings.ToLower(path), strings.ToLower(ext)) {
+			return true
+		}
+	}
+	return false
+}
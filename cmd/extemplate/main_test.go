@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, c := range files {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(c), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestLint(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"base.tmpl": `{{ block "content" . }}{{ end }}`,
+		"page.tmpl": "{{ extends \"base.tmpl\" }}\n{{ define \"contnet\" }}{{ undefinedFunc }}{{ end }}",
+	})
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"lint", dir}, &stdout, &stderr); code != 1 {
+		t.Errorf("Expected exit code 1, got %d", code)
+	}
+	if e, a := "page.tmpl: template \"contnet\" is not a block in layout \"base.tmpl\" and never invoked\n", stdout.String(); a != e {
+		t.Errorf("Expected %q, got %q", e, a)
+	}
+}
+
+func TestFmt(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"ok.tmpl":    "{{ if . }}ok{{ end }}",
+		"messy.tmpl": "{{if .}}messy{{end}}",
+	})
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"fmt", dir}, &stdout, &stderr); code != 1 {
+		t.Errorf("Expected exit code 1, got %d", code)
+	}
+	if e, a := filepath.Join(dir, "messy.tmpl")+"\n", stdout.String(); a != e {
+		t.Errorf("Expected %q, got %q", e, a)
+	}
+
+	if code := run([]string{"fmt", "-w", dir}, &stdout, &stderr); code != 0 {
+		t.Errorf("Expected exit code 0, got %d: %s", code, stderr.String())
+	}
+	b, err := os.ReadFile(filepath.Join(dir, "messy.tmpl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, a := "{{ if . }}messy{{ end }}", string(b); a != e {
+		t.Errorf("Expected %q, got %q", e, a)
+	}
+}
+
+func TestBuild(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"_layouts/base.tmpl":   `<title>{{ .Name }}</title>{{ block "content" . }}{{ end }}`,
+		"index.tmpl":           "{{ extends \"_layouts/base.tmpl\" }}\n{{ define \"content\" }}{{ range .Data.products }}{{ .name }};{{ end }}{{ end }}",
+		"about/index.tmpl":     "{{ extends \"_layouts/base.tmpl\" }}\n{{ define \"content\" }}{{ .Data.site.title }}{{ end }}",
+		"data/products.json":   `[{"name": "a"}, {"name": "b"}]`,
+		"data/site/title.json": `"Shop"`,
+	})
+	out := filepath.Join(t.TempDir(), "public")
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"build", "-out", out, dir}, &stdout, &stderr); code != 0 {
+		t.Fatalf("Expected exit code 0, got %d: %s", code, stderr.String())
+	}
+
+	for name, e := range map[string]string{
+		"index.html":       "<title>index.tmpl</title>a;b;",
+		"about/index.html": "<title>about/index.tmpl</title>Shop",
+	} {
+		b, err := os.ReadFile(filepath.Join(out, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != e {
+			t.Errorf("%s: expected %q, got %q", name, e, b)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(out, "_layouts")); !os.IsNotExist(err) {
+		t.Errorf("Expected layouts not to be rendered, got %v", err)
+	}
+}
+
+func TestRebuild(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"_layouts/base.tmpl":   `<title>{{ .Name }}</title>{{ block "content" . }}{{ end }}`,
+		"index.tmpl":           "{{ extends \"_layouts/base.tmpl\" }}\n{{ define \"content\" }}{{ range .Data.products }}{{ .name }};{{ end }}{{ end }}",
+		"about/index.tmpl":     "{{ extends \"_layouts/base.tmpl\" }}\n{{ define \"content\" }}{{ .Data.site.title }}{{ end }}",
+		"data/products.json":   `[{"name": "a"}, {"name": "b"}]`,
+		"data/site/title.json": `"Shop"`,
+	})
+	out := filepath.Join(t.TempDir(), "public")
+	s := &site{dir: dir, dataDir: filepath.Join(dir, "data"), out: out, extensions: []string{".tmpl"}}
+	if n, err := s.build(); err != nil || n != 2 {
+		t.Fatalf("Expected 2 pages, got %d: %v", n, err)
+	}
+
+	for _, c := range []struct {
+		file     string
+		contents string
+		pages    int
+		page     string
+		expect   string
+	}{
+		{"", "", 0, "index.html", "<title>index.tmpl</title>a;b;"},
+		{"data/site/title.json", `"Store"`, 1, "about/index.html", "<title>about/index.tmpl</title>Store"},
+		{"data/products.json", `[{"name": "c"}]`, 1, "index.html", "<title>index.tmpl</title>c;"},
+		{"about/index.tmpl", "{{ extends \"_layouts/base.tmpl\" }}\n{{ define \"content\" }}About{{ end }}", 1, "about/index.html", "<title>about/index.tmpl</title>About"},
+		{"_layouts/base.tmpl", `<h1>{{ .Name }}</h1>{{ block "content" . }}{{ end }}`, 2, "index.html", "<h1>index.tmpl</h1>c;"},
+	} {
+		if c.file != "" {
+			if err := os.WriteFile(filepath.Join(dir, c.file), []byte(c.contents), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		n, err := s.rebuild()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != c.pages {
+			t.Errorf("%s: expected %d pages to be rendered, got %d", c.file, c.pages, n)
+		}
+		b, err := os.ReadFile(filepath.Join(out, c.page))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != c.expect {
+			t.Errorf("%s: expected %q, got %q", c.page, c.expect, b)
+		}
+	}
+
+	// removing a page removes its output
+	if err := os.Remove(filepath.Join(dir, "about/index.tmpl")); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := s.rebuild(); err != nil || n != 1 {
+		t.Fatalf("Expected 1 page, got %d: %v", n, err)
+	}
+	if _, err := os.Stat(filepath.Join(out, "about/index.html")); !os.IsNotExist(err) {
+		t.Errorf("Expected removed page to be removed from output, got %v", err)
+	}
+}
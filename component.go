@@ -0,0 +1,268 @@
+// Copyright 2017 Danny van Kooten. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package extemplate
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Names of the funcs that component, fill and slot actions are rewritten to. They are only ever inserted by rewriteComponents.
+const (
+	componentStartFunc = "extemplateComponentStart"
+	componentEndFunc   = "extemplateComponentEnd"
+	fillStartFunc      = "extemplateFillStart"
+	fillEndFunc        = "extemplateFillEnd"
+	hasSlotFunc        = "extemplateHasSlot"
+	slotFunc           = "extemplateSlot"
+)
+
+type componentWriterKey struct{}
+
+type slotsKey struct{}
+
+// WithComponents enables components: templates in dir, like "components/", that are rendered with props and slots.
+// A component is rendered by name, without its directory and extension, using a block with props as key and value pairs:
+//
+//	{{ component "card" "title" .Title }}
+//		{{ fill "footer" }}<a href="/more">More</a>{{ end }}
+//		<p>Everything outside of fill actions is passed as the default slot.</p>
+//	{{ end }}
+//
+// The component template receives its props as data and renders slots using slot actions,
+// which render their own contents if the slot was not passed:
+//
+//	{{ props "title" "variant=primary" }}
+//	<div class="card {{ .variant }}"><h2>{{ .title }}</h2>{{ slot }}{{ end }}{{ slot "footer" }}No footer{{ end }}</div>
+//
+// The optional props directive declares the props of a component: passing undeclared props or omitting a prop
+// without a default value is an error. Declared props are available using Meta under the "props" key.
+// Slots are rendered in the context of the calling template, so they can use its variables.
+func WithComponents(dir string) Option {
+	return func(x *Extemplate) {
+		x.componentDir = dir
+		x.directives["props"] = propsDirective
+		x.ContextFuncs(func(ctx context.Context) template.FuncMap {
+			// copies of x made using Clone or Subset share this func, so render components using the executing set
+			x := x.executing(ctx)
+			cw, _ := ctx.Value(componentWriterKey{}).(*componentWriter)
+			slots, _ := ctx.Value(slotsKey{}).(map[string]template.HTML)
+			return template.FuncMap{
+				componentStartFunc: func(name string, props ...interface{}) (string, error) {
+					if cw == nil {
+						return "", errors.New("extemplate: component used outside of template execution")
+					}
+					p, err := dict(props...)
+					if err != nil {
+						return "", err
+					}
+					cw.frames = append(cw.frames, &componentFrame{name: name, props: p, slots: make(map[string]*bytes.Buffer)})
+					return "", nil
+				},
+				componentEndFunc: func() (string, error) {
+					f := cw.frames[len(cw.frames)-1]
+					cw.frames = cw.frames[:len(cw.frames)-1]
+					return "", x.renderComponent(ctx, cw, f)
+				},
+				fillStartFunc: func(name string) (string, error) {
+					if cw == nil || len(cw.frames) == 0 {
+						return "", errors.New("extemplate: fill used outside of component")
+					}
+					cw.frames[len(cw.frames)-1].fill = name
+					return "", nil
+				},
+				fillEndFunc: func() string {
+					cw.frames[len(cw.frames)-1].fill = ""
+					return ""
+				},
+				hasSlotFunc: func(name string) bool {
+					return strings.TrimSpace(string(slots[name])) != ""
+				},
+				slotFunc: func(name string) template.HTML {
+					return slots[name]
+				},
+			}
+		})
+	}
+}
+
+// propsDirective handles {{ props "title" "variant=primary" }}
+func propsDirective(f *File, args []string) error {
+	f.Meta["props"] = args
+	return nil
+}
+
+// componentFrame holds the props of a component being called and the contents of its slots captured so far
+type componentFrame struct {
+	name  string
+	props map[string]interface{}
+	slots map[string]*bytes.Buffer
+	// fill is the name of the slot being filled, or "" for the default slot
+	fill string
+}
+
+// componentWriter captures the output written inside component actions into the slots of the component
+type componentWriter struct {
+	w      io.Writer
+	frames []*componentFrame
+}
+
+func (cw *componentWriter) Write(p []byte) (int, error) {
+	if len(cw.frames) == 0 {
+		return cw.w.Write(p)
+	}
+
+	f := cw.frames[len(cw.frames)-1]
+	buf, ok := f.slots[f.fill]
+	if !ok {
+		buf = &bytes.Buffer{}
+		f.slots[f.fill] = buf
+	}
+	return buf.Write(p)
+}
+
+// renderComponent renders the component of frame f into cw, validating its props against the declared ones
+func (x *Extemplate) renderComponent(ctx context.Context, cw *componentWriter, f *componentFrame) error {
+	name, err := x.componentName(f.name)
+	if err != nil {
+		return err
+	}
+
+	if declared, ok := x.Meta(name)["props"].([]string); ok {
+		known := make(map[string]bool, len(declared))
+		for _, d := range declared {
+			kv := strings.SplitN(d, "=", 2)
+			known[kv[0]] = true
+			if _, ok := f.props[kv[0]]; ok {
+				continue
+			}
+			if len(kv) == 1 {
+				return fmt.Errorf("extemplate: component %q: missing prop %q", f.name, kv[0])
+			}
+			f.props[kv[0]] = kv[1]
+		}
+		for p := range f.props {
+			if !known[p] {
+				return fmt.Errorf("extemplate: component %q: unknown prop %q", f.name, p)
+			}
+		}
+	}
+
+	slots := make(map[string]template.HTML, len(f.slots))
+	for s, buf := range f.slots {
+		slots[s] = template.HTML(buf.String())
+	}
+	return x.executeNested(context.WithValue(ctx, slotsKey{}, slots), cw, name, f.props)
+}
+
+// componentName returns the name of the template of the named component:
+// the template named name in the component directory, with or without extension
+func (x *Extemplate) componentName(name string) (string, error) {
+	base := x.normalize(x.componentDir + name)
+
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	if _, ok := x.files[base]; ok {
+		return base, nil
+	}
+
+	var names []string
+	for n := range x.files {
+		if strings.HasPrefix(n, base+".") && !strings.Contains(n[len(base):], "/") {
+			names = append(names, n)
+		}
+	}
+	if len(names) == 0 {
+		return "", fmt.Errorf("extemplate: no component %q", name)
+	}
+	sort.Strings(names)
+	return names[0], nil
+}
+
+// rewriteComponents rewrites component, fill and slot actions in c into calls of the component funcs, see WithComponents.
+// blocks are the keywords of other actions that are closed by an end action, like section.
+func rewriteComponents(c []byte, left, right string, blocks ...string) []byte {
+	var out bytes.Buffer
+	var stack []string
+
+	for {
+		start := bytes.Index(c, []byte(left))
+		if start < 0 {
+			break
+		}
+		end := bytes.Index(c[start+len(left):], []byte(right))
+		if end < 0 {
+			break
+		}
+		end += start + len(left)
+
+		action := string(c[start+len(left) : end])
+		ltrim, rtrim := "", ""
+		inner := strings.TrimSpace(action)
+		if strings.HasPrefix(inner, "- ") {
+			ltrim, inner = "- ", strings.TrimSpace(inner[1:])
+		}
+		if strings.HasSuffix(inner, " -") {
+			rtrim, inner = " -", strings.TrimSpace(inner[:len(inner)-1])
+		}
+		keyword := inner
+		args := ""
+		if i := strings.IndexAny(inner, " \t\r\n"); i >= 0 {
+			keyword, args = inner[:i], strings.TrimSpace(inner[i:])
+		}
+
+		out.Write(c[:start])
+		rewritten := ""
+		switch keyword {
+		case "if", "range", "with", "define", "block":
+			stack = append(stack, keyword)
+		case "component":
+			stack = append(stack, keyword)
+			rewritten = left + ltrim + " " + componentStartFunc + " " + args + rtrim + right
+		case "fill":
+			stack = append(stack, keyword)
+			rewritten = left + ltrim + " " + fillStartFunc + " " + args + rtrim + right
+		case "slot":
+			stack = append(stack, keyword)
+			if args == "" {
+				args = `""`
+			}
+			rewritten = left + ltrim + " if " + hasSlotFunc + " " + args + " " + right + left + " " + slotFunc + " " + args + " " + right + left + " else" + rtrim + right
+		case "end":
+			if len(stack) == 0 {
+				break
+			}
+			switch stack[len(stack)-1] {
+			case "component":
+				rewritten = left + ltrim + " " + componentEndFunc + rtrim + right
+			case "fill":
+				rewritten = left + ltrim + " " + fillEndFunc + rtrim + right
+			}
+			stack = stack[:len(stack)-1]
+		default:
+			for _, b := range blocks {
+				if keyword == b {
+					stack = append(stack, keyword)
+				}
+			}
+		}
+
+		if rewritten != "" {
+			out.WriteString(rewritten)
+		} else {
+			out.Write(c[start : end+len(right)])
+		}
+		c = c[end+len(right):]
+	}
+
+	out.Write(c)
+	return out.Bytes()
+}
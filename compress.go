@@ -0,0 +1,114 @@
+// Copyright 2017 Danny van Kooten. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package extemplate
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	gzipWriters  = sync.Pool{New: func() interface{} { return gzip.NewWriter(nil) }}
+	flateWriters = sync.Pool{New: func() interface{} {
+		w, _ := flate.NewWriter(nil, flate.DefaultCompression)
+		return w
+	}}
+)
+
+// compressor is implemented by the writers of gzip and flate
+type compressor interface {
+	io.WriteCloser
+	Reset(w io.Writer)
+}
+
+// RenderCompressed is like Render, but compresses the output using gzip or deflate if the Accept-Encoding header of r allows it.
+// The output is compressed before anything is written, so that nothing is written to w if executing the template fails,
+// unless the error template is rendered in its place, which is written with status 500 like Render does.
+func (x *Extemplate) RenderCompressed(w http.ResponseWriter, r *http.Request, status int, name string, data interface{}) error {
+	var buf bytes.Buffer
+	contentType := x.contentType(name)
+	execErr := x.ExecuteTemplateContext(r.Context(), &buf, name, data)
+	if execErr != nil {
+		if !isFallback(execErr) {
+			return execErr
+		}
+		status, contentType = http.StatusInternalServerError, x.contentType(x.errorTemplate)
+	}
+
+	h := w.Header()
+	h.Add("Vary", "Accept-Encoding")
+	encoding := acceptedEncoding(r.Header.Get("Accept-Encoding"))
+	if encoding == "" {
+		if err := writeBuffered(w, status, contentType, &buf); err != nil {
+			return err
+		}
+		return execErr
+	}
+
+	pool := &gzipWriters
+	if encoding == "deflate" {
+		pool = &flateWriters
+	}
+	cw := pool.Get().(compressor)
+	defer pool.Put(cw)
+
+	var out bytes.Buffer
+	cw.Reset(&out)
+	if _, err := buf.WriteTo(cw); err != nil {
+		return err
+	}
+	if err := cw.Close(); err != nil {
+		return err
+	}
+
+	h.Set("Content-Encoding", encoding)
+	if err := writeBuffered(w, status, contentType, &out); err != nil {
+		return err
+	}
+	return execErr
+}
+
+// acceptedEncoding returns the preferred encoding of gzip and deflate in the given Accept-Encoding header,
+// or "" if neither is accepted
+func acceptedEncoding(header string) string {
+	q := qualities(header)
+	best, bestQ := "", 0.0
+	for _, coding := range []string{"gzip", "deflate"} {
+		weight, ok := q[coding]
+		if !ok {
+			weight = q["*"]
+		}
+		if weight > bestQ {
+			best, bestQ = coding, weight
+		}
+	}
+	return best
+}
+
+// qualities returns the quality values of the values in the given Accept or Accept-Encoding header, by lowercase value
+func qualities(header string) map[string]float64 {
+	q := map[string]float64{}
+	for _, part := range strings.Split(header, ",") {
+		params := strings.Split(part, ";")
+		value := strings.ToLower(strings.TrimSpace(params[0]))
+		weight := 1.0
+		for _, p := range params[1:] {
+			p = strings.TrimSpace(p)
+			if strings.HasPrefix(p, "q=") {
+				if v, err := strconv.ParseFloat(p[2:], 64); err == nil {
+					weight = v
+				}
+			}
+		}
+		q[value] = weight
+	}
+	return q
+}
@@ -0,0 +1,119 @@
+// Copyright 2017 Danny van Kooten. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package extemplate
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// ConflictPolicy controls what happens when two template files map to the same template name, like "Index.tmpl"
+// and "index.tmpl" with WithCaseInsensitiveLookup, or a file and a file at another path or in another file system
+// parsed by an earlier call to ParseFS.
+type ConflictPolicy int
+
+const (
+	// ConflictWarn uses the file found last, recording a warning, see Warnings. This is the default.
+	ConflictWarn ConflictPolicy = iota
+	// ConflictKeepFirst uses the file found first, recording a warning.
+	ConflictKeepFirst
+	// ConflictError aborts parsing with an error wrapping ErrDuplicateName.
+	ConflictError
+)
+
+// WithConflictPolicy sets what happens when two template files map to the same template name.
+// Files within a single call to ParseFS, ParseDir or ParseLoader are found in lexical order of their paths.
+func WithConflictPolicy(p ConflictPolicy) Option {
+	return func(x *Extemplate) {
+		x.conflicts = p
+	}
+}
+
+// addFile adds tf to files under the given name, applying the conflict policy if files holds another file of that name
+func (x *Extemplate) addFile(files map[string]*templatefile, name string, tf *templatefile) error {
+	prev, ok := files[name]
+	if !ok || prev.path == tf.path {
+		files[name] = tf
+		return nil
+	}
+
+	switch x.conflicts {
+	case ConflictError:
+		return fmt.Errorf("%w: %s and %s are both named %q", ErrDuplicateName, prev.path, tf.path, name)
+	case ConflictKeepFirst:
+		prev.ignored = append(prev.ignored, tf.path)
+	default:
+		tf.ignored = append(prev.ignored, prev.path)
+		files[name] = tf
+	}
+	return nil
+}
+
+// resolveConflicts applies the conflict policy to files named like a file at another path in the set,
+// and records warnings for the files that are ignored. The caller must hold x.mu for writing.
+func (x *Extemplate) resolveConflicts(files map[string]*templatefile) error {
+	for _, name := range sortedFileNames(files) {
+		tf := files[name]
+		if old, ok := x.files[name]; ok && (old.path != tf.path || old.source != tf.source) {
+			switch x.conflicts {
+			case ConflictError:
+				return fmt.Errorf("%w: %s and %s are both named %q", ErrDuplicateName, describeFile(old, tf), tf.path, name)
+			case ConflictKeepFirst:
+				x.warn(name, "file %s is ignored, as %s has the same name", describeFile(tf, old), old.path)
+				old.ignored = appendPath(old.ignored, tf.path)
+				delete(files, name)
+				continue
+			default:
+				x.warn(name, "file %s is ignored, as %s has the same name", describeFile(old, tf), tf.path)
+				tf.ignored = appendPath(tf.ignored, old.path)
+			}
+		}
+
+		// ignored paths are kept, so that reloading does not pick them up as new files, see reloadChanged
+		for _, path := range tf.ignored {
+			if path != tf.path {
+				x.warn(name, "file %s is ignored, as %s has the same name", path, tf.path)
+			}
+		}
+	}
+	return nil
+}
+
+// describeFile returns the path of tf, noting that it is in another file system than other if their paths are equal
+func describeFile(tf *templatefile, other *templatefile) string {
+	if tf.path == other.path {
+		return tf.path + " (in another file system)"
+	}
+	return tf.path
+}
+
+// appendPath appends path to paths, unless it is in there already
+func appendPath(paths []string, path string) []string {
+	for _, p := range paths {
+		if p == path {
+			return paths
+		}
+	}
+	return append(paths, path)
+}
+
+// sourceOf returns an identifier of the file system or loader v, which is the same for every call with the same v
+func sourceOf(v interface{}) string {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Map, reflect.Ptr, reflect.Chan, reflect.Func, reflect.Slice, reflect.UnsafePointer:
+		return fmt.Sprintf("%T@%x", v, rv.Pointer())
+	}
+	return fmt.Sprintf("%T:%v", v, v)
+}
+
+// sortedFileNames returns the names of files in lexical order
+func sortedFileNames(files map[string]*templatefile) []string {
+	names := make(map[string]bool, len(files))
+	for name := range files {
+		names[name] = true
+	}
+	return sortedNames(names)
+}
@@ -0,0 +1,195 @@
+// Copyright 2017 Danny van Kooten. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package extemplate
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	texttemplate "text/template"
+	"text/template/parse"
+)
+
+// coverFunc is the name of the func called by coverage probes. It is only ever inserted into parse trees, never into sources.
+const coverFunc = "extemplateCover"
+
+// CoverageEntry holds the number of times a template, or a branch in it, was executed
+type CoverageEntry struct {
+	File   string
+	Line   int
+	Column int
+	// Kind is "template" for the body of a template, define or block, or "if", "else", "range" or "with" for branches
+	Kind  string
+	Count int64
+}
+
+type coverage struct {
+	mu      sync.Mutex
+	entries map[string]*CoverageEntry
+}
+
+// WithCoverage instruments templates to record which templates and branches are executed, see Coverage.
+// Instrumentation slows down execution, so it is meant for test runs.
+func WithCoverage() Option {
+	return func(x *Extemplate) {
+		x.coverage = &coverage{entries: make(map[string]*CoverageEntry)}
+		x.Funcs(template.FuncMap{
+			coverFunc: func(id string) bool {
+				x.coverage.mu.Lock()
+				e := x.coverage.entries[id]
+				x.coverage.mu.Unlock()
+				if e != nil {
+					atomic.AddInt64(&e.Count, 1)
+				}
+				return false
+			},
+		})
+	}
+}
+
+// Coverage returns the execution counts of all templates and branches, ordered by file and position.
+// It returns nil if templates are not instrumented, see WithCoverage.
+func (x *Extemplate) Coverage() []CoverageEntry {
+	if x.coverage == nil {
+		return nil
+	}
+
+	x.coverage.mu.Lock()
+	entries := make([]CoverageEntry, 0, len(x.coverage.entries))
+	for _, e := range x.coverage.entries {
+		entries = append(entries, CoverageEntry{e.File, e.Line, e.Column, e.Kind, atomic.LoadInt64(&e.Count)})
+	}
+	x.coverage.mu.Unlock()
+
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+	return entries
+}
+
+// WriteCoverageReport writes a line with the position, kind and count of every entry returned by Coverage to w,
+// followed by the percentage of entries that were executed.
+func (x *Extemplate) WriteCoverageReport(w io.Writer) error {
+	entries := x.Coverage()
+	covered := 0
+	for _, e := range entries {
+		if e.Count > 0 {
+			covered++
+		}
+		if _, err := fmt.Fprintf(w, "%s:%d:%d\t%s\t%d\n", e.File, e.Line, e.Column, e.Kind, e.Count); err != nil {
+			return err
+		}
+	}
+
+	pct := 0.0
+	if len(entries) > 0 {
+		pct = 100 * float64(covered) / float64(len(entries))
+	}
+	_, err := fmt.Fprintf(w, "coverage: %.1f%% of %d blocks executed\n", pct, len(entries))
+	return err
+}
+
+func htmlTrees(t *template.Template) []*parse.Tree {
+	var trees []*parse.Tree
+	for _, t := range t.Templates() {
+		trees = append(trees, t.Tree)
+	}
+	return trees
+}
+
+func textTrees(t *texttemplate.Template) []*parse.Tree {
+	var trees []*parse.Tree
+	for _, t := range t.Templates() {
+		trees = append(trees, t.Tree)
+	}
+	return trees
+}
+
+// instrument adds coverage probes to all trees parsed under parseName that were not instrumented yet,
+// attributing them to the given file
+func (c *coverage) instrument(tf *templatefile, parseName string, trees []*parse.Tree) {
+	for _, tree := range trees {
+		if tree == nil || tree.Root == nil || tree.ParseName != parseName || isProbe(tree.Root) {
+			continue
+		}
+		c.instrumentList(tf, tree, tree.Root, "template")
+	}
+}
+
+func (c *coverage) instrumentList(tf *templatefile, tree *parse.Tree, list *parse.ListNode, kind string) {
+	if list == nil {
+		return
+	}
+
+	// the location is reported relative to the parse name and stripped contents, so map it back to the file
+	loc, _ := tree.ErrorContext(list)
+	parts := strings.Split(loc, ":")
+	line, _ := strconv.Atoi(parts[len(parts)-2])
+	col, _ := strconv.Atoi(parts[len(parts)-1])
+	file := filepath.ToSlash(tf.path)
+	line += tf.offset
+	col++
+	id := fmt.Sprintf("%s:%d:%d", file, line, col)
+
+	for _, n := range list.Nodes {
+		switch n := n.(type) {
+		case *parse.IfNode:
+			c.instrumentList(tf, tree, n.List, "if")
+			c.instrumentList(tf, tree, n.ElseList, "else")
+		case *parse.RangeNode:
+			c.instrumentList(tf, tree, n.List, "range")
+			c.instrumentList(tf, tree, n.ElseList, "else")
+		case *parse.WithNode:
+			c.instrumentList(tf, tree, n.List, "with")
+			c.instrumentList(tf, tree, n.ElseList, "else")
+		}
+	}
+
+	c.mu.Lock()
+	if c.entries[id] == nil {
+		c.entries[id] = &CoverageEntry{File: file, Line: line, Column: col, Kind: kind}
+	}
+	c.mu.Unlock()
+
+	list.Nodes = append([]parse.Node{newProbe(id)}, list.Nodes...)
+}
+
+// newProbe returns {{ if extemplateCover "id" }}{{ end }}, which counts executions without producing output.
+// An if action is used because html/template does not need to escape it.
+// The probe is parsed rather than constructed, so that it can be printed like any other node.
+func newProbe(id string) parse.Node {
+	t := parse.New("coverage")
+	t.Mode = parse.SkipFuncCheck
+	if _, err := t.Parse("{{ if "+coverFunc+" "+strconv.Quote(id)+" }}{{ end }}", "{{", "}}", make(map[string]*parse.Tree)); err != nil {
+		panic(err)
+	}
+	return t.Root.Nodes[0]
+}
+
+// isProbe reports whether list starts with a coverage probe
+func isProbe(list *parse.ListNode) bool {
+	if len(list.Nodes) == 0 {
+		return false
+	}
+	n, ok := list.Nodes[0].(*parse.IfNode)
+	if !ok || len(n.Pipe.Cmds) != 1 || len(n.Pipe.Cmds[0].Args) != 2 {
+		return false
+	}
+	id, ok := n.Pipe.Cmds[0].Args[0].(*parse.IdentifierNode)
+	return ok && id.Ident == coverFunc
+}
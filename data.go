@@ -0,0 +1,87 @@
+// Copyright 2017 Danny van Kooten. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package extemplate
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+// WithDataFormat decodes data files with the given extension using unmarshal, see LoadData. For example:
+//
+//	extemplate.New(extemplate.WithDataFormat(".yaml", yaml.Unmarshal), extemplate.WithDataFormat(".toml", toml.Unmarshal))
+//
+// JSON files are decoded using encoding/json by default.
+func WithDataFormat(ext string, unmarshal func(data []byte, v interface{}) error) Option {
+	return func(x *Extemplate) {
+		if x.dataFormats == nil {
+			x.dataFormats = make(map[string]func(data []byte, v interface{}) error)
+		}
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		x.dataFormats[strings.ToLower(ext)] = unmarshal
+	}
+}
+
+// LoadData decodes all data files in fsys into a map by name, without extension, for passing to templates.
+// Files in directories are decoded into nested maps, so that "products.json" and "shop/categories.yaml"
+// are available as {{ .products }} and {{ .shop.categories }}. Files with an extension that is not
+// registered using WithDataFormat, or ".json", are ignored.
+func (x *Extemplate) LoadData(fsys fs.FS) (map[string]interface{}, error) {
+	data := make(map[string]interface{})
+	err := fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		ext := path.Ext(p)
+		unmarshal, ok := x.dataFormats[strings.ToLower(ext)]
+		if !ok && strings.EqualFold(ext, ".json") {
+			unmarshal, ok = json.Unmarshal, true
+		}
+		if !ok {
+			return nil
+		}
+
+		b, err := fs.ReadFile(fsys, p)
+		if err != nil {
+			return err
+		}
+		var v interface{}
+		if err := unmarshal(b, &v); err != nil {
+			return fmt.Errorf("extemplate: %s: %s", p, err)
+		}
+
+		// walk down to the map of the directory of the file, creating maps along the way
+		m := data
+		parts := strings.Split(strings.TrimSuffix(p, ext), "/")
+		for _, dir := range parts[:len(parts)-1] {
+			sub, ok := m[dir].(map[string]interface{})
+			if !ok {
+				if _, exists := m[dir]; exists {
+					return fmt.Errorf("extemplate: %s: %q is both a data file and a directory", p, dir)
+				}
+				sub = make(map[string]interface{})
+				m[dir] = sub
+			}
+			m = sub
+		}
+
+		name := parts[len(parts)-1]
+		if _, exists := m[name]; exists {
+			return fmt.Errorf("extemplate: %s: data named %q exists already", p, name)
+		}
+		m[name] = v
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
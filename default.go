@@ -0,0 +1,40 @@
+// Copyright 2017 Danny van Kooten. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package extemplate
+
+import (
+	"html/template"
+	"io"
+	"io/fs"
+)
+
+// Default is the Extemplate instance used by the package-level functions, like net/http's DefaultServeMux,
+// for small programs that do not want to pass an instance around.
+var Default = New()
+
+// Funcs adds the elements of the argument map to the function map of Default, see Extemplate.Funcs.
+func Funcs(funcMap template.FuncMap) *Extemplate {
+	return Default.Funcs(funcMap)
+}
+
+// ParseDir parses the template files with the given extensions in root into Default, see Extemplate.ParseDir.
+func ParseDir(root string, extensions []string) error {
+	return Default.ParseDir(root, extensions)
+}
+
+// ParseFS parses the template files with the given extensions in fsys into Default, see Extemplate.ParseFS.
+func ParseFS(fsys fs.FS, extensions []string) error {
+	return Default.ParseFS(fsys, extensions)
+}
+
+// Lookup returns the template of Default with the given name, see Extemplate.Lookup.
+func Lookup(name string) *template.Template {
+	return Default.Lookup(name)
+}
+
+// ExecuteTemplate applies the template of Default named name to data and writes the output to wr, see Extemplate.ExecuteTemplate.
+func ExecuteTemplate(wr io.Writer, name string, data interface{}) error {
+	return Default.ExecuteTemplate(wr, name, data)
+}
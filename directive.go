@@ -0,0 +1,125 @@
+// Copyright 2017 Danny van Kooten. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package extemplate
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// File describes a template file while its directives are being processed
+type File struct {
+	// Name is the name of the template, relative to the parsed root directory
+	Name string
+
+	// Layout is the name of the template this file extends, if any
+	Layout string
+
+	// Meta holds arbitrary metadata that directives may set
+	Meta map[string]interface{}
+}
+
+// Directive handles a directive action at the top of a template file, like {{ extends "base.tmpl" }}.
+// Directives are stripped from the template before it is parsed.
+// args holds the arguments of the directive, with quoted strings unquoted.
+type Directive func(f *File, args []string) error
+
+// Directive registers a handler for directives with the given name, e.g. "use" for {{ use "..." }}.
+// It must be called before templates are parsed.
+// The return value is the Extemplate instance, so calls can be chained.
+func (x *Extemplate) Directive(name string, d Directive) *Extemplate {
+	x.directives[name] = d
+	return x
+}
+
+// WithExtendsPattern sets an additional pattern for the extends directive, matched against each leading line of a template file
+// (without its line ending). The first subexpression of the pattern holds the name of the extended template,
+// which may be quoted using double quotes, single quotes or backticks.
+// For example, `^<!-- layout: (.+) -->$` allows writing <!-- layout: base.tmpl --> on the first line.
+func WithExtendsPattern(re *regexp.Regexp) Option {
+	return func(x *Extemplate) {
+		x.extendsPattern = re
+	}
+}
+
+// matchDirective returns the handler and unparsed arguments of the directive on the given line,
+// or nil if the line does not hold a registered directive.
+// directiveRegex matches directives written using the delimiters of the file.
+func (x *Extemplate) matchDirective(line []byte, directiveRegex *regexp.Regexp) (Directive, string) {
+	if x.extendsPattern != nil {
+		if m := x.extendsPattern.FindSubmatch(bytes.TrimRight(line, "\r\n")); len(m) > 1 {
+			return x.directives["extends"], string(m[1])
+		}
+	}
+
+	m := directiveRegex.FindSubmatch(line)
+	if m == nil {
+		return nil, ""
+	}
+	// directives written as a comment are captured by the first two groups, actions by the last two
+	if m[1] == nil {
+		m = m[2:]
+	}
+	return x.directives[string(m[1])], string(m[2])
+}
+
+// extendsDirective handles {{ extends "layout.tmpl" }}
+func extendsDirective(f *File, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("extemplate: %s: extends expects exactly 1 argument, got %d", f.Name, len(args))
+	}
+
+	f.Layout = filepath.ToSlash(args[0])
+	return nil
+}
+
+// splitArgs splits the arguments of a directive on whitespace, unquoting quoted parts.
+// For example, `key="a b" 'c'` results in ["key=a b", "c"].
+func splitArgs(s string) ([]string, error) {
+	var args []string
+	var arg strings.Builder
+	inArg := false
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"' || c == '\'' || c == '`':
+			end := strings.IndexByte(s[i+1:], c)
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated quoted string in %q", s)
+			}
+
+			quoted := s[i : i+end+2]
+			if c == '\'' {
+				quoted = `"` + strings.Replace(quoted[1:len(quoted)-1], `"`, `\"`, -1) + `"`
+			}
+			v, err := strconv.Unquote(quoted)
+			if err != nil {
+				return nil, err
+			}
+			arg.WriteString(v)
+			inArg = true
+			i += end + 1
+		case c == ' ' || c == '\t':
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteByte(c)
+			inArg = true
+		}
+	}
+
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
@@ -0,0 +1,97 @@
+// Copyright 2017 Danny van Kooten. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// Package email renders transactional emails from a conventional trio of templates.
+//
+// An email named "welcome" consists of welcome.subject.tmpl, welcome.html.tmpl and an optional welcome.txt.tmpl.
+// The HTML body is rendered through html/template, the subject and plain-text body are rendered through text/template.
+// All of them can extend layouts, as long as text templates extend text layouts like layout.txt.tmpl.
+package email
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io"
+	"strings"
+
+	"github.com/dannyvankooten/extemplate"
+)
+
+// Message holds a rendered email
+type Message struct {
+	Subject string
+	HTML    string
+	Text    string
+}
+
+// Renderer holds the parsed email templates
+type Renderer struct {
+	x *extemplate.Extemplate
+}
+
+// New allocates a new, empty, email renderer
+func New() *Renderer {
+	return &Renderer{
+		x: extemplate.New(extemplate.WithTextExtensions(".subject.tmpl", ".txt.tmpl")),
+	}
+}
+
+// Funcs adds the elements of the argument map to the function map of both the HTML and text templates.
+// It must be called before templates are parsed.
+// The return value is the Renderer, so calls can be chained.
+func (r *Renderer) Funcs(funcMap map[string]interface{}) *Renderer {
+	r.x.Funcs(template.FuncMap(funcMap))
+	return r
+}
+
+// InlineStyles enables inlining of <style> rules into the style attributes of the rendered HTML body.
+// See InlineCSS for the supported selectors.
+// The return value is the Renderer, so calls can be chained.
+func (r *Renderer) InlineStyles() *Renderer {
+	r.x.AddOutputFilter(func(name string, w io.Writer) io.Writer {
+		if !strings.HasSuffix(name, ".html.tmpl") {
+			return w
+		}
+		return &inlineWriter{w: w}
+	})
+	return r
+}
+
+// ParseDir parses all email templates in the given root directory.
+// Files ending in .subject.tmpl or .txt.tmpl are parsed as text templates, all other .tmpl files as HTML templates.
+func (r *Renderer) ParseDir(root string) error {
+	return r.x.ParseDir(root, []string{".tmpl"})
+}
+
+// Render renders the email with the given name, e.g. "welcome" or "users/welcome", applying data to all templates.
+// The subject and HTML templates are required, the plain-text template is optional.
+func (r *Renderer) Render(name string, data interface{}) (*Message, error) {
+	var buf bytes.Buffer
+	m := &Message{}
+
+	if r.x.LookupText(name+".subject.tmpl") == nil {
+		return nil, fmt.Errorf("email: no subject template for %q", name)
+	}
+	if err := r.x.ExecuteTemplate(&buf, name+".subject.tmpl", data); err != nil {
+		return nil, err
+	}
+	m.Subject = strings.TrimSpace(buf.String())
+
+	buf.Reset()
+	if err := r.x.ExecuteTemplate(&buf, name+".html.tmpl", data); err != nil {
+		return nil, err
+	}
+	m.HTML = buf.String()
+
+	if r.x.LookupText(name+".txt.tmpl") != nil {
+		buf.Reset()
+		if err := r.x.ExecuteTemplate(&buf, name+".txt.tmpl", data); err != nil {
+			return nil, err
+		}
+		m.Text = buf.String()
+	}
+
+	return m, nil
+}
@@ -0,0 +1,46 @@
+package email
+
+import (
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	r := New()
+	if err := r.ParseDir("testdata"); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := r.Render("welcome", "Danny & co")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if e := "Welcome, Danny & co!"; m.Subject != e {
+		t.Errorf("Expected subject %q, got %q", e, m.Subject)
+	}
+	if e := "<html><p>Hello Danny &amp; co</p></html>\n"; m.HTML != e {
+		t.Errorf("Expected HTML %q, got %q", e, m.HTML)
+	}
+	if e := "Hello Danny & co & welcome\n--\nThe team\n"; m.Text != e {
+		t.Errorf("Expected text %q, got %q", e, m.Text)
+	}
+
+	if _, err := r.Render("foobar", nil); err == nil {
+		t.Error("Expected error for unexisting email, got none")
+	}
+}
+
+func TestRenderInlineStyles(t *testing.T) {
+	r := New().InlineStyles()
+	if err := r.ParseDir("testdata"); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := r.Render("styled", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := "<html><head></head><body><p style=\"color: red\">Hi</p>\n</body></html>"; m.HTML != e {
+		t.Errorf("Expected HTML %q, got %q", e, m.HTML)
+	}
+}
@@ -0,0 +1,10 @@
+module github.com/dannyvankooten/extemplate/email
+
+go 1.20
+
+replace github.com/dannyvankooten/extemplate => ../
+
+require (
+	github.com/dannyvankooten/extemplate v0.0.0-20261016185856-7eaeb8900b09
+	golang.org/x/net v0.17.0
+)
@@ -0,0 +1,235 @@
+// Copyright 2017 Danny van Kooten. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package email
+
+import (
+	"bytes"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+var cssCommentRegex = regexp.MustCompile(`(?s)/\*.*?\*/`)
+
+// simpleSelectorRegex matches selectors we know how to inline: tag, .class, #id or combinations like p.intro
+var simpleSelectorRegex = regexp.MustCompile(`^([a-zA-Z][a-zA-Z0-9]*)?((?:[.#][a-zA-Z_-][a-zA-Z0-9_-]*)*)$`)
+
+type cssRule struct {
+	selector    string
+	decls       string
+	specificity int
+	order       int
+}
+
+// InlineCSS moves the rules from <style> elements into the style attribute of matching elements,
+// since most email clients ignore style elements.
+// Only tag, class and id selectors (and combinations thereof) are inlined;
+// other rules, like media queries or descendant selectors, are left in place.
+// Existing style attributes take precedence over inlined rules.
+func InlineCSS(b []byte) ([]byte, error) {
+	doc, err := html.Parse(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []cssRule
+	var styles []*html.Node
+	walk(doc, func(n *html.Node) {
+		if n.Type == html.ElementNode && n.Data == "style" {
+			styles = append(styles, n)
+		}
+	})
+
+	// collect inlinable rules, keep the rest in the style element
+	for _, s := range styles {
+		if s.FirstChild == nil {
+			continue
+		}
+
+		var keep []string
+		for _, r := range splitRules(s.FirstChild.Data) {
+			inlinable, parsed := parseRule(r, len(rules))
+			if !inlinable {
+				keep = append(keep, r)
+				continue
+			}
+			rules = append(rules, parsed...)
+		}
+
+		if len(keep) == 0 {
+			s.Parent.RemoveChild(s)
+			continue
+		}
+		s.FirstChild.Data = strings.Join(keep, "\n")
+	}
+
+	// lower specificity first, then source order
+	sort.SliceStable(rules, func(i, j int) bool {
+		if rules[i].specificity != rules[j].specificity {
+			return rules[i].specificity < rules[j].specificity
+		}
+		return rules[i].order < rules[j].order
+	})
+
+	walk(doc, func(n *html.Node) {
+		if n.Type != html.ElementNode {
+			return
+		}
+
+		var decls []string
+		for _, r := range rules {
+			if matches(n, r.selector) {
+				decls = append(decls, r.decls)
+			}
+		}
+		if len(decls) == 0 {
+			return
+		}
+
+		for i, a := range n.Attr {
+			if a.Key == "style" {
+				n.Attr[i].Val = strings.Join(append(decls, strings.TrimSuffix(strings.TrimSpace(a.Val), ";")), "; ")
+				return
+			}
+		}
+		n.Attr = append(n.Attr, html.Attribute{Key: "style", Val: strings.Join(decls, "; ")})
+	})
+
+	var buf bytes.Buffer
+	if err := html.Render(&buf, doc); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// inlineWriter buffers the HTML written to it and writes it with inlined CSS once closed
+type inlineWriter struct {
+	w   io.Writer
+	buf bytes.Buffer
+}
+
+func (iw *inlineWriter) Write(p []byte) (int, error) {
+	return iw.buf.Write(p)
+}
+
+func (iw *inlineWriter) Close() error {
+	b, err := InlineCSS(iw.buf.Bytes())
+	if err != nil {
+		return err
+	}
+	_, err = iw.w.Write(b)
+	return err
+}
+
+func walk(n *html.Node, fn func(n *html.Node)) {
+	for c := n.FirstChild; c != nil; {
+		// grab next sibling first, as fn may remove c from the tree
+		next := c.NextSibling
+		fn(c)
+		walk(c, fn)
+		c = next
+	}
+}
+
+// splitRules splits a stylesheet into its top-level rules, keeping at-rule blocks intact
+func splitRules(css string) []string {
+	var rules []string
+	css = cssCommentRegex.ReplaceAllString(css, "")
+	depth, start := 0, 0
+	for i, ch := range css {
+		switch ch {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				rules = append(rules, strings.TrimSpace(css[start:i+1]))
+				start = i + 1
+			}
+		}
+	}
+	return rules
+}
+
+// parseRule parses a rule into one inlinable rule per selector, or returns false if the rule can not be inlined
+func parseRule(r string, order int) (bool, []cssRule) {
+	i := strings.Index(r, "{")
+	if i < 0 || strings.HasPrefix(r, "@") {
+		return false, nil
+	}
+
+	decls := strings.TrimSuffix(strings.TrimSpace(strings.TrimSuffix(r[i+1:], "}")), ";")
+	var rules []cssRule
+	for _, sel := range strings.Split(r[:i], ",") {
+		sel = strings.TrimSpace(sel)
+		if sel == "" {
+			continue
+		}
+		if !simpleSelectorRegex.MatchString(sel) {
+			return false, nil
+		}
+
+		specificity := 0
+		if sel[0] != '.' && sel[0] != '#' {
+			specificity++
+		}
+		specificity += 10 * strings.Count(sel, ".")
+		specificity += 100 * strings.Count(sel, "#")
+		rules = append(rules, cssRule{selector: sel, decls: decls, specificity: specificity, order: order})
+	}
+	return true, rules
+}
+
+// matches reports whether element n matches the simple selector sel
+func matches(n *html.Node, sel string) bool {
+	m := simpleSelectorRegex.FindStringSubmatch(sel)
+	if m[1] != "" && !strings.EqualFold(m[1], n.Data) {
+		return false
+	}
+
+	var id string
+	var classes []string
+	for _, a := range n.Attr {
+		switch a.Key {
+		case "id":
+			id = a.Val
+		case "class":
+			classes = strings.Fields(a.Val)
+		}
+	}
+
+	rest := m[2]
+	for rest != "" {
+		kind := rest[0]
+		rest = rest[1:]
+		end := strings.IndexAny(rest, ".#")
+		if end < 0 {
+			end = len(rest)
+		}
+		v := rest[:end]
+		rest = rest[end:]
+
+		if kind == '#' && v != id {
+			return false
+		}
+		if kind == '.' && !contains(classes, v) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func contains(s []string, v string) bool {
+	for _, e := range s {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,27 @@
+package email
+
+import (
+	"testing"
+)
+
+func TestInlineCSS(t *testing.T) {
+	tests := map[string]string{
+		`<style>p { color: red; }</style><p>Hi</p>`:                                     `<html><head></head><body><p style="color: red">Hi</p></body></html>`,
+		`<style>.intro { color: red } p { color: blue }</style><p class="intro">Hi</p>`: `<html><head></head><body><p class="intro" style="color: blue; color: red">Hi</p></body></html>`,
+		`<style>#a, b { margin: 0 }</style><p id="a" style="margin: 1px;">Hi</p>`:       `<html><head></head><body><p id="a" style="margin: 0; margin: 1px">Hi</p></body></html>`,
+		`<style>@media (max-width: 600px) { p { color: red } }</style><p>Hi</p>`:        `<html><head><style>@media (max-width: 600px) { p { color: red } }</style></head><body><p>Hi</p></body></html>`,
+		`<style>div p { color: red } p { color: blue }</style><p>Hi</p>`:                `<html><head><style>div p { color: red }</style></head><body><p style="color: blue">Hi</p></body></html>`,
+		`<style>{ color: red }</style><p>Hi</p>`:                                        `<html><head></head><body><p>Hi</p></body></html>`,
+		`<style>p, { color: red }</style><p>Hi</p>`:                                     `<html><head></head><body><p style="color: red">Hi</p></body></html>`,
+	}
+
+	for in, e := range tests {
+		b, err := InlineCSS([]byte(in))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if a := string(b); a != e {
+			t.Errorf("Expected %s, got %s", e, a)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+// Copyright 2017 Danny van Kooten. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package extemplate
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"time"
+)
+
+// Environment is a profile of behaviors suited for development or production, see WithEnvironment
+type Environment int
+
+const (
+	// Prod makes templates fail on missing map keys and keeps caches enabled
+	Prod Environment = iota
+	// Dev is like Prod, but reloads changed templates, shows error details, marks template boundaries and disables caches
+	Dev
+)
+
+// reloadInterval is the minimum interval between checks for changed template files in the Dev environment
+const reloadInterval = time.Second
+
+// WithEnvironment configures x for development or production at once.
+//
+// In both environments, executing a template fails when it indexes a map with a missing key, instead of printing "<no value>".
+//
+// In Dev, changed template files are reloaded and new ones are parsed before executing templates (checking at most once per second),
+// the error template has access to error details, the output of every HTML template is surrounded by comments
+// naming the template, and the fragment and response caches are disabled, regardless of the order of options
+// and of caches set later using SetFragmentCache.
+//
+// In Prod, caches are left enabled and templates are never reloaded automatically.
+func WithEnvironment(env Environment) Option {
+	return func(x *Extemplate) {
+		x.strictKeys = true
+		if env == Dev {
+			x.autoReload = true
+			x.errorDetails = true
+			x.noCache = true
+			x.AddOutputFilter(x.boundaryFilter)
+		}
+	}
+}
+
+// reloadChanged reloads the template files whose modification time changed since they were parsed and parses new files,
+// unless it was called less than reloadInterval ago. Files that fail to reload keep their previous version, see OnReloadError.
+func (x *Extemplate) reloadChanged() {
+	x.reloadMu.Lock()
+	defer x.reloadMu.Unlock()
+	if time.Since(x.lastReload) < reloadInterval {
+		return
+	}
+	x.lastReload = time.Now()
+
+	x.mu.RLock()
+	fsys, extensions := x.fsys, x.extensions
+	var changed []string
+	modTimes := make(map[string]time.Time)
+	known := make(map[string]bool, len(x.files))
+	for _, tf := range x.files {
+		known[tf.path] = true
+		for _, path := range tf.ignored {
+			known[path] = true
+		}
+		if fsys == nil || tf.path == "" || tf.modTime.IsZero() {
+			continue
+		}
+		info, err := fs.Stat(fsys, tf.path)
+		if err == nil && info.ModTime().Equal(tf.modTime) {
+			continue
+		}
+		// a file that failed to reload is only retried once it changes again
+		if err == nil {
+			if failed, ok := x.failedReloads[tf.path]; ok && info.ModTime().Equal(failed) {
+				continue
+			}
+			modTimes[tf.path] = info.ModTime()
+		}
+		changed = append(changed, tf.path)
+	}
+	x.mu.RUnlock()
+
+	// rescan the file system for new files
+	if fsys != nil {
+		var added []string
+		err := x.walk(context.Background(), fsys, ".", nil, func(path string) {
+			if known[path] {
+				return
+			}
+			for _, ext := range extensions {
+				if hasExtension(path, ext, x.strictExts) {
+					added = append(added, path)
+					return
+				}
+			}
+		})
+		if err != nil {
+			x.reloaded(nil, err)
+		}
+		for _, path := range added {
+			info, err := fs.Stat(fsys, path)
+			if err != nil {
+				continue
+			}
+			if failed, ok := x.failedReloads[path]; ok && info.ModTime().Equal(failed) {
+				continue
+			}
+			modTimes[path] = info.ModTime()
+			changed = append(changed, path)
+		}
+	}
+
+	var reloaded []string
+	for _, path := range changed {
+		if err := x.ReloadFile(path); err != nil {
+			if x.failedReloads == nil {
+				x.failedReloads = make(map[string]time.Time)
+			}
+			x.failedReloads[path] = modTimes[path]
+			// files skipped by a parse hook are not parsed, but not reported either
+			if err != SkipFile {
+				x.reloaded(nil, err)
+			}
+			continue
+		}
+		delete(x.failedReloads, path)
+		reloaded = append(reloaded, path)
+	}
+	x.reloaded(reloaded, nil)
+}
+
+// boundaryFilter surrounds the output of HTML templates with comments naming the template
+func (x *Extemplate) boundaryFilter(name string, w io.Writer) io.Writer {
+	if x.isTextTemplate(name) {
+		return w
+	}
+	return &boundaryWriter{w: w, name: name}
+}
+
+// boundaryWriter writes a comment before the first write, and one when closed
+type boundaryWriter struct {
+	w       io.Writer
+	name    string
+	started bool
+}
+
+func (bw *boundaryWriter) start() error {
+	if bw.started {
+		return nil
+	}
+	bw.started = true
+	_, err := io.WriteString(bw.w, "<!-- begin "+bw.name+" -->")
+	return err
+}
+
+func (bw *boundaryWriter) Write(p []byte) (int, error) {
+	if err := bw.start(); err != nil {
+		return 0, err
+	}
+	return bw.w.Write(p)
+}
+
+func (bw *boundaryWriter) Close() error {
+	if err := bw.start(); err != nil {
+		return err
+	}
+	_, err := io.WriteString(bw.w, "<!-- end "+bw.name+" -->")
+	return err
+}
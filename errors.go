@@ -0,0 +1,79 @@
+// Copyright 2017 Danny van Kooten. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package extemplate
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrTemplateNotFound is returned, wrapped, when executing or looking up a template that was not parsed
+	ErrTemplateNotFound = errors.New("extemplate: template not found")
+	// ErrLayoutNotFound is returned, wrapped in a *ParseError, when executing a template that extends a template that was not parsed
+	ErrLayoutNotFound = errors.New("extemplate: layout not found")
+	// ErrDuplicateName is returned, wrapped, when two files map to the same template name, see WithConflictPolicy
+	ErrDuplicateName = errors.New("extemplate: duplicate template name")
+	// ErrCycle is returned, wrapped in a *ParseError, when the layout chain of a template extends itself
+	ErrCycle = errors.New("extemplate: layout cycle")
+)
+
+// ParseError is returned when a template can not be parsed or compiled together with its layouts.
+// Its message is that of Err, which names the template and, for syntax errors, the line.
+type ParseError struct {
+	// Name is the name of the template that failed to parse
+	Name string
+	// Err is the underlying error
+	Err error
+}
+
+func (e *ParseError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// ExecError is returned when executing a template fails. Like ParseError, its message is that of Err.
+type ExecError struct {
+	// Name is the name of the executed template
+	Name string
+	// Err is the underlying error
+	Err error
+	// Fallback reports whether the error template was written in place of the output, see SetErrorTemplate
+	Fallback bool
+}
+
+func (e *ExecError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ExecError) Unwrap() error {
+	return e.Err
+}
+
+// notFound returns an error wrapping ErrTemplateNotFound for the named template
+func notFound(name string) error {
+	return fmt.Errorf("%w: %q", ErrTemplateNotFound, name)
+}
+
+// isFallback reports whether err was returned after writing the error template in place of the output
+func isFallback(err error) bool {
+	var ee *ExecError
+	return errors.As(err, &ee) && ee.Fallback
+}
+
+// execError wraps err, returned by executing the named template, in an *ExecError.
+// Errors of nested executions, like included templates, are returned as is, so that they carry the name of the failing template.
+func (x *Extemplate) execError(name string, err error) error {
+	var pe *ParseError
+	var ee *ExecError
+	var panicErr *PanicError
+	if errors.As(err, &pe) || errors.As(err, &ee) || errors.As(err, &panicErr) {
+		return err
+	}
+	return &ExecError{Name: name, Err: x.suggestTemplate(err)}
+}
@@ -0,0 +1,62 @@
+// Copyright 2017 Danny van Kooten. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package extemplate
+
+import (
+	"bytes"
+	"context"
+	"io"
+)
+
+// ErrorData is the data the error template is executed with
+type ErrorData struct {
+	// Name is the name of the template that failed
+	Name string
+	// Data is the data the failing template was executed with
+	Data interface{}
+	// Err is the error that occurred. It is only set if error details are shown, see ShowErrorDetails
+	Err error
+}
+
+// SetErrorTemplate sets the template that is rendered instead of a template that fails to execute.
+// Templates are then executed into a buffer first, so that no partial output is written.
+// The error template is executed with an *ErrorData. If it succeeds, its output is written
+// and the original error is returned wrapped in an *ExecError with Fallback set.
+// The return value is the Extemplate instance, so calls can be chained.
+func (x *Extemplate) SetErrorTemplate(name string) *Extemplate {
+	x.errorTemplate = x.resolve(name)
+	return x
+}
+
+// ShowErrorDetails controls whether the error template has access to the error that occurred.
+// Error details are hidden by default, as they may leak information that should not be shown in production.
+// The return value is the Extemplate instance, so calls can be chained.
+func (x *Extemplate) ShowErrorDetails(show bool) *Extemplate {
+	x.errorDetails = show
+	return x
+}
+
+// executeOrError executes the named template into a buffer, rendering the error template instead if that fails
+func (x *Extemplate) executeOrError(ctx context.Context, wr io.Writer, name string, data interface{}) error {
+	var buf bytes.Buffer
+	err := x.execute(ctx, &buf, name, data)
+	if err == nil {
+		_, err = buf.WriteTo(wr)
+		return err
+	}
+
+	ed := &ErrorData{Name: name, Data: data}
+	if x.errorDetails {
+		ed.Err = err
+	}
+	buf.Reset()
+	if x.execute(ctx, &buf, x.errorTemplate, ed) != nil {
+		return err
+	}
+	if _, werr := buf.WriteTo(wr); werr != nil {
+		return werr
+	}
+	return &ExecError{Name: name, Err: err, Fallback: true}
+}
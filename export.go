@@ -0,0 +1,102 @@
+// Copyright 2017 Danny van Kooten. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package extemplate
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ExportDataFunc returns the data to render the named template with when exporting it.
+// Returning SkipFile as the error skips the template, any other error aborts the export.
+type ExportDataFunc func(name string) (interface{}, error)
+
+// RenderToFile applies the named template to data and writes the output to the file at path, creating its directory if needed.
+// The file is replaced atomically, so that it is never observed partially written, and is left untouched if executing the template fails.
+func (x *Extemplate) RenderToFile(path string, name string, data interface{}) error {
+	var buf bytes.Buffer
+	if err := x.ExecuteTemplate(&buf, name, data); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, buf.Bytes())
+}
+
+// ExportAll renders every template into outDir, at the path of its name with the extension of HTML templates replaced by ".html",
+// so that "blog/index.tmpl" is written to "blog/index.html". Text templates keep their name, e.g. "robots.txt".
+// Templates are rendered in lexical order with the data returned by dataFn, which should skip layouts and partials.
+func (x *Extemplate) ExportAll(outDir string, dataFn ExportDataFunc) error {
+	x.mu.RLock()
+	var names []string
+	for name := range x.files {
+		if strings.HasPrefix(name, x.subset) {
+			names = append(names, name)
+		}
+	}
+	x.mu.RUnlock()
+	sort.Strings(names)
+
+	return x.Export(outDir, names, dataFn)
+}
+
+// Export is like ExportAll, but only renders the templates with the given names, in the given order.
+// Combined with Affected, it can be used to only re-render the pages affected by a change.
+func (x *Extemplate) Export(outDir string, names []string, dataFn ExportDataFunc) error {
+	for _, name := range names {
+		data, err := dataFn(name)
+		if errors.Is(err, SkipFile) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := x.RenderToFile(x.ExportPath(outDir, name), name, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ExportPath returns the path in outDir that ExportAll writes the named template to
+func (x *Extemplate) ExportPath(outDir string, name string) string {
+	out := name
+	if !x.isTextTemplate(name) {
+		out = strings.TrimSuffix(name, path.Ext(name)) + ".html"
+	}
+	return filepath.Join(outDir, filepath.FromSlash(out))
+}
+
+// writeFileAtomic writes data to a temporary file in the directory of filename, then renames it to filename
+func writeFileAtomic(filename string, data []byte) error {
+	dir := filepath.Dir(filename)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(dir, "."+filepath.Base(filename)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(0644); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), filename)
+}
@@ -0,0 +1,32 @@
+// Copyright 2017 Danny van Kooten. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// Package extecho adapts extemplate for use as the renderer of an echo server.
+//
+// It is a separate module, so that extemplate itself does not depend on echo.
+package extecho
+
+import (
+	"io"
+
+	"github.com/dannyvankooten/extemplate"
+	"github.com/labstack/echo/v4"
+)
+
+var _ echo.Renderer = (*Renderer)(nil)
+
+// Renderer implements echo.Renderer
+type Renderer struct {
+	x *extemplate.Extemplate
+}
+
+// New returns a Renderer executing templates from x
+func New(x *extemplate.Extemplate) *Renderer {
+	return &Renderer{x: x}
+}
+
+// Render executes the template with the given name, using the context of the request.
+func (r *Renderer) Render(w io.Writer, name string, data interface{}, c echo.Context) error {
+	return r.x.ExecuteTemplateContext(c.Request().Context(), w, name, data)
+}
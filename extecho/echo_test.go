@@ -0,0 +1,32 @@
+package extecho
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+
+	"github.com/dannyvankooten/extemplate"
+	"github.com/labstack/echo/v4"
+)
+
+func TestRender(t *testing.T) {
+	x := extemplate.New()
+	if err := x.ParseFS(fstest.MapFS{
+		"hello.tmpl": {Data: []byte(`Hello {{ . }}`)},
+	}, []string{".tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+
+	e := echo.New()
+	e.Renderer = New(x)
+	e.GET("/", func(c echo.Context) error {
+		return c.Render(http.StatusOK, "hello.tmpl", "world")
+	})
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if e, a := "Hello world", rec.Body.String(); a != e {
+		t.Errorf("Expected %q, got %q", e, a)
+	}
+}
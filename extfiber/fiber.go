@@ -0,0 +1,44 @@
+// Copyright 2017 Danny van Kooten. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// Package extfiber adapts extemplate for use as the views engine of a fiber app.
+//
+// It is a separate module, so that extemplate itself does not depend on fiber.
+package extfiber
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/dannyvankooten/extemplate"
+	"github.com/gofiber/fiber/v2"
+)
+
+var _ fiber.Views = (*Engine)(nil)
+
+// Engine implements fiber.Views
+type Engine struct {
+	x          *extemplate.Extemplate
+	root       string
+	extensions []string
+}
+
+// New returns an Engine parsing the templates in root into x when the fiber app is started
+func New(x *extemplate.Extemplate, root string, extensions []string) *Engine {
+	return &Engine{x: x, root: root, extensions: extensions}
+}
+
+// Load parses the templates in the root directory
+func (e *Engine) Load() error {
+	return e.x.ParseDir(e.root, e.extensions)
+}
+
+// Render executes the template with the given name.
+// Templates declare their layout using extends, so passing a layout is an error.
+func (e *Engine) Render(w io.Writer, name string, data interface{}, layouts ...string) error {
+	if len(layouts) > 0 && layouts[0] != "" {
+		return fmt.Errorf("extfiber: layouts are not supported, use extends in %q instead", name)
+	}
+	return e.x.ExecuteTemplate(w, name, data)
+}
@@ -0,0 +1,34 @@
+package extfiber
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dannyvankooten/extemplate"
+)
+
+func TestRender(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "hello.tmpl"), []byte(`Hello {{ . }}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	e := New(extemplate.New(), dir, []string{".tmpl"})
+	if err := e.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := e.Render(&buf, "hello.tmpl", "world"); err != nil {
+		t.Fatal(err)
+	}
+	if e, a := "Hello world", buf.String(); a != e {
+		t.Errorf("Expected %q, got %q", e, a)
+	}
+
+	if err := e.Render(&buf, "hello.tmpl", "world", "layout.tmpl"); err == nil {
+		t.Error("Expected error when passing a layout, got none")
+	}
+}
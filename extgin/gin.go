@@ -0,0 +1,49 @@
+// Copyright 2017 Danny van Kooten. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// Package extgin adapts extemplate for use as the HTML renderer of a gin engine.
+//
+// It is a separate module, so that extemplate itself does not depend on gin.
+package extgin
+
+import (
+	"net/http"
+
+	"github.com/dannyvankooten/extemplate"
+	"github.com/gin-gonic/gin/render"
+)
+
+var _ render.HTMLRender = (*Renderer)(nil)
+
+// Renderer implements render.HTMLRender
+type Renderer struct {
+	x *extemplate.Extemplate
+}
+
+// New returns a Renderer executing templates from x
+func New(x *extemplate.Extemplate) *Renderer {
+	return &Renderer{x: x}
+}
+
+// Instance returns a render.Render executing the template with the given name
+func (r *Renderer) Instance(name string, data interface{}) render.Render {
+	return &instance{x: r.x, name: name, data: data}
+}
+
+type instance struct {
+	x    *extemplate.Extemplate
+	name string
+	data interface{}
+}
+
+func (i *instance) Render(w http.ResponseWriter) error {
+	i.WriteContentType(w)
+	return i.x.ExecuteTemplate(w, i.name, i.data)
+}
+
+func (i *instance) WriteContentType(w http.ResponseWriter) {
+	if h := w.Header(); len(h["Content-Type"]) == 0 {
+		h["Content-Type"] = []string{"text/html; charset=utf-8"}
+	}
+}
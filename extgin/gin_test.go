@@ -0,0 +1,36 @@
+package extgin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+
+	"github.com/dannyvankooten/extemplate"
+	"github.com/gin-gonic/gin"
+)
+
+func TestRender(t *testing.T) {
+	x := extemplate.New()
+	if err := x.ParseFS(fstest.MapFS{
+		"hello.tmpl": {Data: []byte(`Hello {{ . }}`)},
+	}, []string{".tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.HTMLRender = New(x)
+	r.GET("/", func(c *gin.Context) {
+		c.HTML(http.StatusOK, "hello.tmpl", "world")
+	})
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if e, a := "Hello world", rec.Body.String(); a != e {
+		t.Errorf("Expected %q, got %q", e, a)
+	}
+	if e, a := "text/html; charset=utf-8", rec.Header().Get("Content-Type"); a != e {
+		t.Errorf("Expected Content-Type %q, got %q", e, a)
+	}
+}
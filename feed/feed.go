@@ -0,0 +1,224 @@
+// Copyright 2017 Danny van Kooten. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// Package feed registers funcs and templates for rendering RSS and Atom feeds and XML sitemaps
+// using text/template, which does not escape output by itself:
+//
+//	x := extemplate.New(extemplate.WithTextExtensions(".xml"))
+//	if err := feed.AddTemplates(x); err != nil {
+//		log.Fatal(err)
+//	}
+//	err := x.ExecuteTemplate(w, "feed/rss.xml", feed.Feed{Title: "Blog", Link: "https://example.com/", Items: items})
+//
+// Own templates can use the registered funcs: xml escapes text for use in XML elements and attributes,
+// rfc822 and rfc3339 format a time.Time for RSS and for Atom and sitemaps, and absURL resolves a URL
+// relative to a base URL, like {{ absURL $.Link .Link }}.
+package feed
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"html/template"
+	"net/url"
+	"time"
+
+	"github.com/dannyvankooten/extemplate"
+)
+
+// Feed is the data the feed/rss.xml and feed/atom.xml templates are executed with
+type Feed struct {
+	// Title is the title of the feed
+	Title string
+	// Link is the URL of the website the feed belongs to, which relative links of items are resolved against
+	Link string
+	// URL is the URL of the feed itself, if known
+	URL string
+	// Description describes the feed
+	Description string
+	// Author is the name of the author of the feed
+	Author string
+	// Updated is the time the feed last changed
+	Updated time.Time
+	// Items are the entries of the feed, usually newest first
+	Items []Item
+}
+
+// Item is an entry of a Feed
+type Item struct {
+	// Title is the title of the item
+	Title string
+	// Link is the URL of the item, absolute or relative to the Link of the feed
+	Link string
+	// ID uniquely identifies the item. It defaults to the absolute URL of the item.
+	ID string
+	// Description is the summary or contents of the item, as HTML
+	Description string
+	// Author is the name of the author of the item
+	Author string
+	// Published is the time the item was first published
+	Published time.Time
+	// Updated is the time the item last changed, if after it was published
+	Updated time.Time
+}
+
+// Sitemap is the data the feed/sitemap.xml template is executed with
+type Sitemap struct {
+	// Base is the URL that relative locations of URLs are resolved against, like "https://example.com/"
+	Base string
+	// URLs are the pages in the sitemap
+	URLs []URL
+}
+
+// URL is a page in a Sitemap
+type URL struct {
+	// Loc is the URL of the page, absolute or relative to the Base of the sitemap
+	Loc string
+	// LastMod is the time the page last changed, if known
+	LastMod time.Time
+	// ChangeFreq is how often the page is likely to change, like "daily" or "monthly", if known
+	ChangeFreq string
+	// Priority is the priority of the page relative to other pages, between 0.0 and 1.0, if known
+	Priority float64
+}
+
+// RSS is the source of the feed/rss.xml template
+const RSS = `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0" xmlns:atom="http://www.w3.org/2005/Atom">
+<channel>
+<title>{{ xml .Title }}</title>
+<link>{{ xml .Link }}</link>
+<description>{{ xml .Description }}</description>
+{{- with .URL }}
+<atom:link href="{{ xml . }}" rel="self" type="application/rss+xml"/>
+{{- end }}
+{{- if not .Updated.IsZero }}
+<lastBuildDate>{{ rfc822 .Updated }}</lastBuildDate>
+{{- end }}
+{{- range .Items }}
+<item>
+<title>{{ xml .Title }}</title>
+<link>{{ xml (absURL $.Link .Link) }}</link>
+<guid isPermaLink="{{ if .ID }}false{{ else }}true{{ end }}">{{ with .ID }}{{ xml . }}{{ else }}{{ xml (absURL $.Link .Link) }}{{ end }}</guid>
+{{- with .Description }}
+<description>{{ xml . }}</description>
+{{- end }}
+{{- with .Author }}
+<author>{{ xml . }}</author>
+{{- end }}
+{{- if not .Published.IsZero }}
+<pubDate>{{ rfc822 .Published }}</pubDate>
+{{- end }}
+</item>
+{{- end }}
+</channel>
+</rss>
+`
+
+// Atom is the source of the feed/atom.xml template
+const Atom = `<?xml version="1.0" encoding="UTF-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom">
+<title>{{ xml .Title }}</title>
+<id>{{ with .URL }}{{ xml . }}{{ else }}{{ xml .Link }}{{ end }}</id>
+<link href="{{ xml .Link }}"/>
+{{- with .URL }}
+<link rel="self" href="{{ xml . }}"/>
+{{- end }}
+{{- with .Description }}
+<subtitle>{{ xml . }}</subtitle>
+{{- end }}
+<updated>{{ rfc3339 .Updated }}</updated>
+{{- with .Author }}
+<author><name>{{ xml . }}</name></author>
+{{- end }}
+{{- range .Items }}
+<entry>
+<title>{{ xml .Title }}</title>
+<link href="{{ xml (absURL $.Link .Link) }}"/>
+<id>{{ with .ID }}{{ xml . }}{{ else }}{{ xml (absURL $.Link .Link) }}{{ end }}</id>
+<updated>{{ if .Updated.IsZero }}{{ rfc3339 .Published }}{{ else }}{{ rfc3339 .Updated }}{{ end }}</updated>
+{{- if not .Published.IsZero }}
+<published>{{ rfc3339 .Published }}</published>
+{{- end }}
+{{- with .Author }}
+<author><name>{{ xml . }}</name></author>
+{{- end }}
+{{- with .Description }}
+<summary type="html">{{ xml . }}</summary>
+{{- end }}
+</entry>
+{{- end }}
+</feed>
+`
+
+// SitemapXML is the source of the feed/sitemap.xml template
+const SitemapXML = `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+{{- range .URLs }}
+<url>
+<loc>{{ xml (absURL $.Base .Loc) }}</loc>
+{{- if not .LastMod.IsZero }}
+<lastmod>{{ rfc3339 .LastMod }}</lastmod>
+{{- end }}
+{{- with .ChangeFreq }}
+<changefreq>{{ xml . }}</changefreq>
+{{- end }}
+{{- if .Priority }}
+<priority>{{ printf "%.1f" .Priority }}</priority>
+{{- end }}
+</url>
+{{- end }}
+</urlset>
+`
+
+// Register adds the xml, rfc822, rfc3339 and absURL funcs to x. It must be called before templates are parsed.
+// The return value is x, so calls can be chained.
+func Register(x *extemplate.Extemplate) *extemplate.Extemplate {
+	return x.Funcs(template.FuncMap{
+		"xml":     escape,
+		"rfc822":  func(t time.Time) string { return t.Format(time.RFC1123Z) },
+		"rfc3339": func(t time.Time) string { return t.Format(time.RFC3339) },
+		"absURL":  absURL,
+	})
+}
+
+// AddTemplates registers the funcs of this package with x and adds the templates "feed/rss.xml", "feed/atom.xml"
+// and "feed/sitemap.xml". The templates must be parsed using text/template: x must be created using
+// WithTextExtensions with the ".xml" extension.
+func AddTemplates(x *extemplate.Extemplate) error {
+	Register(x)
+	for _, t := range []struct{ name, contents string }{
+		{"feed/rss.xml", RSS},
+		{"feed/atom.xml", Atom},
+		{"feed/sitemap.xml", SitemapXML},
+	} {
+		if err := x.SetTemplate(t.name, t.contents); err != nil {
+			return err
+		}
+		if x.LookupText(t.name) == nil {
+			return errors.New(`feed: templates must be parsed using text/template, see extemplate.WithTextExtensions(".xml")`)
+		}
+	}
+	return nil
+}
+
+// escape escapes s for use in XML elements and attributes
+func escape(s string) string {
+	var buf bytes.Buffer
+	_ = xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
+
+// absURL resolves ref relative to base, returning base if ref is empty
+func absURL(base string, ref string) (string, error) {
+	b, err := url.Parse(base)
+	if err != nil {
+		return "", err
+	}
+	r, err := url.Parse(ref)
+	if err != nil {
+		return "", err
+	}
+	return b.ResolveReference(r).String(), nil
+}
@@ -0,0 +1,78 @@
+package feed
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dannyvankooten/extemplate"
+)
+
+func TestAddTemplates(t *testing.T) {
+	x := extemplate.New(extemplate.WithTextExtensions(".xml"))
+	if err := AddTemplates(x); err != nil {
+		t.Fatal(err)
+	}
+
+	published := time.Date(2020, 3, 1, 12, 0, 0, 0, time.UTC)
+	f := Feed{
+		Title:   "Tom & Jerry's <blog>",
+		Link:    "https://example.com/blog/",
+		URL:     "https://example.com/blog/feed.xml",
+		Updated: published,
+		Items: []Item{
+			{Title: "First", Link: "first/", Description: "<p>Hello & welcome</p>", Published: published},
+			{Title: "Second", Link: "/second", ID: "urn:second"},
+		},
+	}
+
+	tests := map[string][]string{
+		"feed/rss.xml": {
+			"<title>Tom &amp; Jerry&#39;s &lt;blog&gt;</title>",
+			"<link>https://example.com/blog/first/</link>",
+			`<guid isPermaLink="true">https://example.com/blog/first/</guid>`,
+			`<guid isPermaLink="false">urn:second</guid>`,
+			"<description>&lt;p&gt;Hello &amp; welcome&lt;/p&gt;</description>",
+			"<pubDate>Sun, 01 Mar 2020 12:00:00 +0000</pubDate>",
+		},
+		"feed/atom.xml": {
+			"<id>https://example.com/blog/feed.xml</id>",
+			"<updated>2020-03-01T12:00:00Z</updated>",
+			`<link href="https://example.com/second"/>`,
+		},
+	}
+	for name, contains := range tests {
+		var buf bytes.Buffer
+		if err := x.ExecuteTemplate(&buf, name, f); err != nil {
+			t.Fatal(err)
+		}
+		for _, c := range contains {
+			if !strings.Contains(buf.String(), c) {
+				t.Errorf("%s: expected output to contain %q, got %s", name, c, buf.String())
+			}
+		}
+		if err := xml.Unmarshal(buf.Bytes(), new(struct{})); err != nil {
+			t.Errorf("%s: invalid XML: %s", name, err)
+		}
+	}
+
+	var buf bytes.Buffer
+	s := Sitemap{Base: "https://example.com", URLs: []URL{{Loc: "/", Priority: 1}, {Loc: "/about?a=1&b=2", LastMod: published}}}
+	if err := x.ExecuteTemplate(&buf, "feed/sitemap.xml", s); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []string{
+		"<loc>https://example.com/</loc>\n<priority>1.0</priority>",
+		"<loc>https://example.com/about?a=1&amp;b=2</loc>\n<lastmod>2020-03-01T12:00:00Z</lastmod>",
+	} {
+		if !strings.Contains(buf.String(), c) {
+			t.Errorf("Expected sitemap to contain %q, got %s", c, buf.String())
+		}
+	}
+
+	if err := AddTemplates(extemplate.New()); err == nil {
+		t.Error("Expected error adding templates without text extension, got none")
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright 2017 Danny van Kooten. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package extemplate
+
+import (
+	"context"
+	"html/template"
+	"io/fs"
+	texttemplate "text/template"
+)
+
+// AddFile parses the file at path in fsys and adds it to the set, recompiling templates that depend on it.
+// The template is named by its path, like templates parsed using ParseFS.
+func (x *Extemplate) AddFile(fsys fs.FS, path string) error {
+	tf, err := x.loadFile(fsys, path)
+	if err != nil {
+		return err
+	}
+
+	return x.parseFiles(context.Background(), map[string]*templatefile{x.nameOf(path): tf})
+}
+
+// Remove removes the template with the given name from the set.
+// Templates extending the removed template are recompiled without it.
+func (x *Extemplate) Remove(name string) error {
+	name = x.normalize(name)
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	return x.removeLocked(name)
+}
+
+// removeLocked is like Remove, but the caller must hold x.mu for writing
+func (x *Extemplate) removeLocked(name string) error {
+	tf, ok := x.files[name]
+	if !ok {
+		return notFound(name)
+	}
+	delete(x.files, name)
+	delete(x.templates, name)
+	delete(x.texts, name)
+	delete(x.pools, name)
+
+	if tf.layout != "" {
+		// only templates extending this template need to be recompiled.
+		// Their files did not change, so remove them first for parseFilesLocked to consider them new.
+		dependents := x.dependents(name)
+		for n := range dependents {
+			delete(x.files, n)
+			delete(x.templates, n)
+			delete(x.texts, n)
+			delete(x.pools, n)
+		}
+		return x.parseFilesLocked(context.Background(), dependents)
+	}
+
+	// templates can not be removed from a template namespace, so rebuild the shared namespaces from scratch
+	x.shared = template.New("").Delims(x.leftDelim, x.rightDelim).Funcs(x.funcs)
+	x.text = texttemplate.New("").Delims(x.leftDelim, x.rightDelim).Funcs(texttemplate.FuncMap(x.funcs))
+	files := x.files
+	x.files = make(map[string]*templatefile, len(files))
+	return x.parseFilesLocked(context.Background(), files)
+}
+
+// SetTemplate compiles contents as the template with the given name and swaps it into the set,
+// along with all templates depending on it. Directives like extends are processed as for parsed files.
+// If the template or any of its dependents fails to compile, the set is left as it was and the error is returned.
+func (x *Extemplate) SetTemplate(name string, contents string) error {
+	tf, err := x.prepareFile(name, []byte(contents))
+	if err != nil {
+		return err
+	}
+	return x.setFile(x.normalize(name), tf)
+}
+
+// setFile is like SetTemplate, but for a prepared file
+func (x *Extemplate) setFile(name string, tf *templatefile) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	old := x.files[name]
+	err := x.parseFilesLocked(context.Background(), map[string]*templatefile{name: tf})
+	if err == nil && x.lazy && tf.layout != "" {
+		// lazy mode does not compile child templates up front, but we want to report errors now
+		var register func()
+		if register, err = x.compile(name); err == nil {
+			register()
+		}
+	}
+	if err == nil {
+		return nil
+	}
+
+	// restore the previous version, recompiling the templates that were compiled against the new one
+	if rerr := x.restoreLocked(map[string]*templatefile{name: old}); rerr != nil {
+		return rerr
+	}
+	return err
+}
+
+// dependents returns the files of all templates that have the named template in their layout chain.
+// The caller must hold x.mu.
+func (x *Extemplate) dependents(name string) map[string]*templatefile {
+	files := make(map[string]*templatefile)
+	for n, tf := range x.files {
+		if n != name && x.dependsOn(n, map[string]bool{name: true}) {
+			files[n] = tf
+		}
+	}
+	return files
+}
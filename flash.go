@@ -0,0 +1,54 @@
+// Copyright 2017 Danny van Kooten. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package extemplate
+
+import (
+	"context"
+)
+
+// Flash is a one-time notification, like "Your changes were saved"
+type Flash struct {
+	// Kind is the kind of notification, like "success" or "error"
+	Kind    string
+	Message string
+}
+
+// FlashProvider returns the pending flash messages for the request of ctx, typically stored in its session.
+// Messages should be removed once returned, as the flashes template func renders them.
+type FlashProvider interface {
+	Flashes(ctx context.Context) []Flash
+}
+
+// FlashProviderFunc is a FlashProvider implemented by a func
+type FlashProviderFunc func(ctx context.Context) []Flash
+
+// Flashes calls fn(ctx)
+func (fn FlashProviderFunc) Flashes(ctx context.Context) []Flash {
+	return fn(ctx)
+}
+
+// SetFlashProvider sets the provider of the flashes template func, which returns the pending flash messages, e.g.
+//
+//	{{ range flashes }}<div class="alert alert-{{ .Kind }}">{{ .Message }}</div>{{ end }}
+//
+// The provider is called with the context passed to ExecuteTemplateContext, at most once per execution.
+// The return value is the Extemplate instance, so calls can be chained.
+func (x *Extemplate) SetFlashProvider(p FlashProvider) *Extemplate {
+	x.flashes = p
+	return x
+}
+
+// flashesFunc returns the flashes template func, see SetFlashProvider
+func (x *Extemplate) flashesFunc(ctx context.Context) func() []Flash {
+	var flashes []Flash
+	done := false
+	return func() []Flash {
+		if !done && x.flashes != nil {
+			flashes = x.flashes.Flashes(ctx)
+			done = true
+		}
+		return flashes
+	}
+}
@@ -0,0 +1,33 @@
+// Copyright 2017 Danny van Kooten. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package extemplate
+
+import (
+	"context"
+	"io"
+	"net/http"
+)
+
+// writersKey is the context key for the writers a template is executed into, outermost filter first
+type writersKey struct{}
+
+// flushFunc returns the flush template func, which flushes all output written so far to the client
+// if the writer the template is executed into implements http.Flusher, e.g.
+//
+//	<head>...</head>{{ flush }}<body>{{ block "slow" . }}{{ end }}</body>
+//
+// Output filters implementing http.Flusher are flushed first.
+// Flushing has no effect when output is buffered, e.g. when an error template is set or when using Render.
+func flushFunc(ctx context.Context) func() string {
+	return func() string {
+		writers, _ := ctx.Value(writersKey{}).([]io.Writer)
+		for _, w := range writers {
+			if f, ok := w.(http.Flusher); ok {
+				f.Flush()
+			}
+		}
+		return ""
+	}
+}
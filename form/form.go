@@ -0,0 +1,194 @@
+// Copyright 2017 Danny van Kooten. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// Package form registers funcs for rendering form fields bound to the fields of a struct,
+// repopulated with submitted values and annotated with validation errors:
+//
+//	<form method="post">
+//		{{ inputText .Form "Name" }}
+//		{{ inputEmail .Form "Email" "placeholder" "you@example.com" }}
+//		{{ textarea .Form "Message" }}
+//		{{ checkbox .Form "Subscribe" }}
+//	</form>
+//
+// Fields are named after the form tag of the struct field, or the struct field name if it has none.
+package form
+
+import (
+	"fmt"
+	"html/template"
+	"net/url"
+	"reflect"
+	"strings"
+
+	"github.com/dannyvankooten/extemplate"
+)
+
+// Form binds form fields to Data, a struct, map or a pointer to either.
+type Form struct {
+	// Data holds the initial values of the fields
+	Data interface{}
+	// Values holds the submitted values, which take precedence over Data
+	Values url.Values
+	// Errors holds the validation error of every invalid field, by field name
+	Errors map[string]string
+}
+
+// New returns a form bound to data
+func New(data interface{}) *Form {
+	return &Form{Data: data, Errors: make(map[string]string)}
+}
+
+// Submit sets the submitted values of f, e.g. r.PostForm, so that fields are repopulated with them
+func (f *Form) Submit(values url.Values) *Form {
+	f.Values = values
+	return f
+}
+
+// AddError sets the validation error of the named field
+func (f *Form) AddError(field string, msg string) *Form {
+	if f.Errors == nil {
+		f.Errors = make(map[string]string)
+	}
+	f.Errors[field] = msg
+	return f
+}
+
+// Valid reports whether f has no validation errors
+func (f *Form) Valid() bool {
+	return len(f.Errors) == 0
+}
+
+// Register adds the form funcs to x. Every func takes the form, the name of the field and optional attributes as key and value pairs.
+// It must be called before templates are parsed.
+func Register(x *extemplate.Extemplate) *extemplate.Extemplate {
+	return x.Funcs(template.FuncMap{
+		"inputText":     input("text"),
+		"inputEmail":    input("email"),
+		"inputPassword": input("password"),
+		"inputNumber":   input("number"),
+		"inputHidden":   input("hidden"),
+		"textarea":      textarea,
+		"checkbox":      checkbox,
+		"fieldValue":    func(f *Form, field string) string { return f.value(field) },
+		"fieldError":    fieldError,
+	})
+}
+
+// input returns the func rendering an input element of the given type
+func input(typ string) func(f *Form, field string, attrs ...string) (template.HTML, error) {
+	return func(f *Form, field string, attrs ...string) (template.HTML, error) {
+		a, err := attributes(attrs)
+		if err != nil {
+			return "", err
+		}
+		value := ""
+		if typ != "password" {
+			value = ` value="` + template.HTMLEscapeString(f.value(field)) + `"`
+		}
+		return template.HTML(`<input type="` + typ + `"` + f.nameAttrs(field) + value + a + `>` + f.errorMessage(field)), nil
+	}
+}
+
+func textarea(f *Form, field string, attrs ...string) (template.HTML, error) {
+	a, err := attributes(attrs)
+	if err != nil {
+		return "", err
+	}
+	return template.HTML(`<textarea` + f.nameAttrs(field) + a + `>` + template.HTMLEscapeString(f.value(field)) + `</textarea>` + f.errorMessage(field)), nil
+}
+
+func checkbox(f *Form, field string, attrs ...string) (template.HTML, error) {
+	a, err := attributes(attrs)
+	if err != nil {
+		return "", err
+	}
+	checked := ""
+	switch f.value(field) {
+	case "", "false", "0", "off":
+	default:
+		checked = " checked"
+	}
+	return template.HTML(`<input type="checkbox"` + f.nameAttrs(field) + ` value="1"` + checked + a + `>` + f.errorMessage(field)), nil
+}
+
+// fieldError renders the validation error of the named field, if any
+func fieldError(f *Form, field string) template.HTML {
+	return template.HTML(f.errorMessage(field))
+}
+
+// nameAttrs returns the id and name attributes of the named field, marking it invalid if it has a validation error
+func (f *Form) nameAttrs(field string) string {
+	name := template.HTMLEscapeString(f.name(field))
+	s := ` id="` + name + `" name="` + name + `"`
+	if _, ok := f.Errors[field]; ok {
+		s += ` aria-invalid="true" aria-describedby="` + name + `-error"`
+	}
+	return s
+}
+
+// errorMessage returns the element showing the validation error of the named field, or "" if it has none
+func (f *Form) errorMessage(field string) string {
+	msg, ok := f.Errors[field]
+	if !ok {
+		return ""
+	}
+	return `<span class="field-error" id="` + template.HTMLEscapeString(f.name(field)) + `-error">` + template.HTMLEscapeString(msg) + `</span>`
+}
+
+// name returns the form field name of the named struct field
+func (f *Form) name(field string) string {
+	t := reflect.TypeOf(f.Data)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return field
+	}
+	if sf, ok := t.FieldByName(field); ok {
+		if tag := strings.Split(sf.Tag.Get("form"), ",")[0]; tag != "" && tag != "-" {
+			return tag
+		}
+	}
+	return field
+}
+
+// value returns the submitted value of the named field, or its value in Data if it was not submitted
+func (f *Form) value(field string) string {
+	if vs, ok := f.Values[f.name(field)]; ok && len(vs) > 0 {
+		return vs[0]
+	}
+
+	v := reflect.ValueOf(f.Data)
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return ""
+		}
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Struct:
+		v = v.FieldByName(field)
+	case reflect.Map:
+		v = v.MapIndex(reflect.ValueOf(field))
+	default:
+		return ""
+	}
+	if !v.IsValid() || !v.CanInterface() {
+		return ""
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+// attributes renders attrs, given as key and value pairs
+func attributes(attrs []string) (string, error) {
+	if len(attrs)%2 != 0 {
+		return "", fmt.Errorf("form: odd number of attribute arguments")
+	}
+	var b strings.Builder
+	for i := 0; i < len(attrs); i += 2 {
+		b.WriteString(" " + template.HTMLEscapeString(attrs[i]) + `="` + template.HTMLEscapeString(attrs[i+1]) + `"`)
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,46 @@
+package form
+
+import (
+	"bytes"
+	"net/url"
+	"testing"
+	"testing/fstest"
+
+	"github.com/dannyvankooten/extemplate"
+)
+
+type signup struct {
+	Name      string
+	Email     string `form:"email"`
+	Bio       string
+	Subscribe bool
+}
+
+func TestRegister(t *testing.T) {
+	x := Register(extemplate.New())
+	if err := x.ParseFS(fstest.MapFS{
+		"form.tmpl": {Data: []byte(`{{ inputText . "Name" "placeholder" "Your name" }}{{ inputEmail . "Email" }}{{ textarea . "Bio" }}{{ checkbox . "Subscribe" }}{{ inputPassword . "Password" }}`)},
+	}, []string{".tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+
+	f := New(&signup{Name: "Danny", Bio: "<b>hi</b>", Subscribe: true}).
+		Submit(url.Values{"email": {"not an email"}}).
+		AddError("Email", "Invalid email address")
+	var buf bytes.Buffer
+	if err := x.ExecuteTemplate(&buf, "form.tmpl", f); err != nil {
+		t.Fatal(err)
+	}
+	e := `<input type="text" id="Name" name="Name" value="Danny" placeholder="Your name">` +
+		`<input type="email" id="email" name="email" aria-invalid="true" aria-describedby="email-error" value="not an email">` +
+		`<span class="field-error" id="email-error">Invalid email address</span>` +
+		`<textarea id="Bio" name="Bio">&lt;b&gt;hi&lt;/b&gt;</textarea>` +
+		`<input type="checkbox" id="Subscribe" name="Subscribe" value="1" checked>` +
+		`<input type="password" id="Password" name="Password">`
+	if a := buf.String(); a != e {
+		t.Errorf("Expected %q, got %q", e, a)
+	}
+	if f.Valid() {
+		t.Error("Expected form with errors to be invalid")
+	}
+}
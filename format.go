@@ -0,0 +1,272 @@
+// Copyright 2017 Danny van Kooten. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package extemplate
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// fmtToken is a piece of template source: either text or a complete action including its delimiters
+type fmtToken struct {
+	action bool
+	s      string
+}
+
+// Format formats template source, using the default {{ and }} delimiters:
+// actions get exactly one space inside their delimiters, the argument of extends is double-quoted,
+// and lines inside define and block bodies are indented with one tab per level of nesting,
+// relative to the line opening the outermost define or block.
+// Comments and the contents of <pre> and <textarea> elements are left untouched. Formatting is idempotent.
+func Format(src []byte) ([]byte, error) {
+	tokens, err := tokenize(string(src), "{{", "}}")
+	if err != nil {
+		return nil, err
+	}
+	for i, t := range tokens {
+		if t.action {
+			tokens[i].s = formatAction(t.s, "{{", "}}")
+		}
+	}
+
+	var out bytes.Buffer
+	var stack []string
+	bodyStart, base := -1, ""
+	inPre := false
+	for _, line := range splitLines(tokens) {
+		lead, rest := "", line
+		if len(rest) > 0 && !rest[0].action {
+			trimmed := strings.TrimLeft(rest[0].s, " \t")
+			lead = rest[0].s[:len(rest[0].s)-len(trimmed)]
+			rest = append([]fmtToken{{s: trimmed}}, rest[1:]...)
+		}
+
+		blank := true
+		for _, t := range rest {
+			if strings.TrimSpace(t.s) != "" {
+				blank = false
+			}
+		}
+
+		// re-indent lines inside define and block bodies
+		if bodyStart >= 0 && !inPre {
+			level := len(stack) - bodyStart
+			if k := firstKind(rest); k == "end" || k == "else" {
+				level--
+			}
+			switch {
+			case blank:
+				lead = ""
+			case level >= 0:
+				lead = base + strings.Repeat("\t", level)
+			}
+		}
+
+		out.WriteString(lead)
+		for _, t := range rest {
+			out.WriteString(t.s)
+			if t.action {
+				switch actionKind(t.s, "{{", "}}") {
+				case "define", "block":
+					if bodyStart < 0 {
+						bodyStart, base = len(stack), lead
+					}
+					stack = append(stack, "define")
+				case "if", "range", "with":
+					stack = append(stack, "control")
+				case "end":
+					if len(stack) > 0 {
+						stack = stack[:len(stack)-1]
+					}
+					if len(stack) <= bodyStart {
+						bodyStart = -1
+					}
+				}
+				continue
+			}
+
+			lower := strings.ToLower(t.s)
+			for _, tag := range []string{"pre", "textarea"} {
+				if o, c := strings.LastIndex(lower, "<"+tag), strings.LastIndex(lower, "</"+tag); o > c {
+					inPre = true
+				} else if c >= 0 {
+					inPre = false
+				}
+			}
+		}
+	}
+
+	return out.Bytes(), nil
+}
+
+// tokenize splits src into text and actions
+func tokenize(src string, left, right string) ([]fmtToken, error) {
+	var tokens []fmtToken
+	for {
+		start := strings.Index(src, left)
+		if start < 0 {
+			break
+		}
+		if start > 0 {
+			tokens = append(tokens, fmtToken{s: src[:start]})
+		}
+
+		end := actionEnd(src[start:], left, right)
+		if end < 0 {
+			line := 1 + strings.Count(src[:start], "\n")
+			return nil, fmt.Errorf("extemplate: unterminated action starting at %q on line %d", firstLine(src[start:]), line)
+		}
+		tokens = append(tokens, fmtToken{action: true, s: src[start : start+end]})
+		src = src[start+end:]
+	}
+	if src != "" {
+		tokens = append(tokens, fmtToken{s: src})
+	}
+	return tokens, nil
+}
+
+// actionEnd returns the length of the action at the start of s, skipping right delimiters in comments and quoted strings
+func actionEnd(s string, left, right string) int {
+	i := len(left)
+	body := strings.TrimLeft(strings.TrimPrefix(s[i:], "-"), " \t\r\n")
+	if strings.HasPrefix(body, "/*") {
+		c := strings.Index(s, "*/")
+		if c < 0 {
+			return -1
+		}
+		e := strings.Index(s[c:], right)
+		if e < 0 {
+			return -1
+		}
+		return c + e + len(right)
+	}
+
+	for i < len(s) {
+		switch c := s[i]; {
+		case strings.HasPrefix(s[i:], right):
+			return i + len(right)
+		case c == '"' || c == '\'' || c == '`':
+			i++
+			for i < len(s) && s[i] != c {
+				if s[i] == '\\' && c != '`' {
+					i++
+				}
+				i++
+			}
+			i++
+		default:
+			i++
+		}
+	}
+	return -1
+}
+
+// formatAction normalizes the whitespace inside an action and quotes the argument of extends
+func formatAction(a string, left, right string) string {
+	inner := a[len(left) : len(a)-len(right)]
+	ltrim := len(inner) > 1 && inner[0] == '-' && isSpace(inner[1])
+	if ltrim {
+		inner = inner[1:]
+	}
+	rtrim := len(inner) > 1 && inner[len(inner)-1] == '-' && isSpace(inner[len(inner)-2])
+	if rtrim {
+		inner = inner[:len(inner)-1]
+	}
+
+	// comments may only be separated from the delimiters by trim markers
+	if strings.HasPrefix(strings.TrimSpace(inner), "/*") && !ltrim && !rtrim {
+		return a
+	}
+
+	body := strings.TrimSpace(inner)
+	if fields := strings.Fields(body); len(fields) == 2 && fields[0] == "extends" {
+		if args, err := splitArgs(fields[1]); err == nil && len(args) == 1 {
+			body = "extends " + strconv.Quote(args[0])
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString(left)
+	if ltrim {
+		b.WriteString("- ")
+	} else {
+		b.WriteByte(' ')
+	}
+	b.WriteString(body)
+	if rtrim {
+		b.WriteString(" -")
+	} else {
+		b.WriteByte(' ')
+	}
+	b.WriteString(right)
+	return b.String()
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n'
+}
+
+// actionKind returns the keyword an action starts with, e.g. "define" or "end"
+func actionKind(a string, left, right string) string {
+	inner := strings.TrimPrefix(a[len(left):len(a)-len(right)], "-")
+	fields := strings.Fields(inner)
+	if len(fields) == 0 {
+		return ""
+	}
+	return fields[0]
+}
+
+// firstKind returns the kind of the first action on a line, if the line starts with one
+func firstKind(line []fmtToken) string {
+	for _, t := range line {
+		if t.action {
+			return actionKind(t.s, "{{", "}}")
+		}
+		if strings.TrimSpace(t.s) != "" {
+			return ""
+		}
+	}
+	return ""
+}
+
+// splitLines groups tokens by line. Text tokens are split after every newline, actions spanning lines are kept whole.
+func splitLines(tokens []fmtToken) [][]fmtToken {
+	var lines [][]fmtToken
+	var line []fmtToken
+	for _, t := range tokens {
+		if t.action {
+			line = append(line, t)
+			continue
+		}
+
+		s := t.s
+		for {
+			i := strings.IndexByte(s, '\n')
+			if i < 0 {
+				break
+			}
+			line = append(line, fmtToken{s: s[:i+1]})
+			lines = append(lines, line)
+			line = nil
+			s = s[i+1:]
+		}
+		if s != "" {
+			line = append(line, fmtToken{s: s})
+		}
+	}
+	if len(line) > 0 {
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func firstLine(s string) string {
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		return s[:i]
+	}
+	return s
+}
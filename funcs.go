@@ -0,0 +1,193 @@
+// Copyright 2017 Danny van Kooten. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package extemplate
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"strings"
+	"sync"
+	texttemplate "text/template"
+	"text/template/parse"
+)
+
+// AddFuncs is like Funcs, but returns an error instead of panicking.
+// Funcs can be added after templates are parsed: if funcMap overwrites a func that is already registered,
+// all parsed templates are recompiled so that they call the new func.
+// If recompiling fails, the funcs and templates are left as they were and the error is returned.
+func (x *Extemplate) AddFuncs(funcMap template.FuncMap) error {
+	if err := validFuncs(funcMap); err != nil {
+		return err
+	}
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	overwrites := false
+	for k := range funcMap {
+		if _, ok := x.funcs[k]; ok {
+			overwrites = true
+		}
+	}
+
+	// funcs that are not registered yet can not be called by any parsed template
+	if !overwrites || len(x.files) == 0 {
+		x.addFuncsLocked(funcMap)
+		return nil
+	}
+
+	shared, text, templates, texts, pools, files := x.shared, x.text, x.templates, x.texts, x.pools, x.files
+	funcs := make(template.FuncMap, len(x.funcs))
+	for k, v := range x.funcs {
+		funcs[k] = v
+	}
+
+	for k, v := range funcMap {
+		x.funcs[k] = v
+	}
+	x.shared = template.New("").Delims(x.leftDelim, x.rightDelim).Funcs(x.funcs)
+	x.text = texttemplate.New("").Delims(x.leftDelim, x.rightDelim).Funcs(texttemplate.FuncMap(x.funcs))
+	x.templates = make(map[string]*template.Template)
+	x.texts = make(map[string]*texttemplate.Template)
+	x.pools = make(map[string]*sync.Pool)
+	x.files = make(map[string]*templatefile, len(files))
+	if err := x.parseFilesLocked(context.Background(), files); err != nil {
+		x.shared, x.text, x.templates, x.texts, x.pools, x.files, x.funcs = shared, text, templates, texts, pools, files, funcs
+		return err
+	}
+	return nil
+}
+
+// addFuncsLocked adds the elements of funcMap to the function maps of x. The caller must hold x.mu for writing.
+func (x *Extemplate) addFuncsLocked(funcMap template.FuncMap) {
+	x.shared.Funcs(funcMap)
+	x.text.Funcs(texttemplate.FuncMap(funcMap))
+	for k, v := range funcMap {
+		x.funcs[k] = v
+	}
+}
+
+// validFuncs returns an error if a value in funcMap is not a function with appropriate return type
+// or if a name can not be used as a function in a template
+func validFuncs(funcMap template.FuncMap) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("extemplate: %v", r)
+		}
+	}()
+	texttemplate.New("").Funcs(texttemplate.FuncMap(funcMap))
+	return nil
+}
+
+// funcScope holds funcs available to the templates with names starting with prefix
+type funcScope struct {
+	prefix string
+	funcs  template.FuncMap
+}
+
+// FuncsFor adds the elements of the argument map to the function map of templates with names starting with prefix,
+// e.g. "admin/" for all templates in the admin directory.
+// Scoped funcs take precedence over funcs registered using Funcs, and are not available to templates outside of the prefix,
+// so that directories can bring their own helpers without colliding with other directories.
+// It must be called before templates are parsed.
+// The return value is the Extemplate instance, so calls can be chained.
+func (x *Extemplate) FuncsFor(prefix string, funcMap template.FuncMap) *Extemplate {
+	x.funcScopes = append(x.funcScopes, funcScope{prefix: x.normalize(prefix), funcs: funcMap})
+	return x
+}
+
+// funcRestriction holds the funcs that templates with names starting with prefix may call
+type funcRestriction struct {
+	prefix  string
+	allowed []string
+}
+
+// RestrictFuncs restricts the registered funcs that templates with names starting with prefix may call to allowed,
+// for example to keep user-uploaded themes from calling funcs that query the database.
+// Funcs predefined by text/template may always be called. Calling any other func is reported as an error when parsing.
+// Restrictions apply to what a template calls directly, not to the templates it extends or invokes.
+// It must be called before templates are parsed.
+// The return value is the Extemplate instance, so calls can be chained.
+func (x *Extemplate) RestrictFuncs(prefix string, allowed []string) *Extemplate {
+	x.funcRestrictions = append(x.funcRestrictions, funcRestriction{prefix: x.normalize(prefix), allowed: allowed})
+	return x
+}
+
+// checkFuncs returns an error if the file with the given name calls a func it is not allowed to,
+// see RestrictFuncs and Sandbox
+func (x *Extemplate) checkFuncs(name string, tf *templatefile) error {
+	if x.sandbox != nil && x.sandbox.Funcs != nil {
+		if err := checkFuncs(name, tf, x.sandbox.Funcs); err != nil {
+			return err
+		}
+	}
+	for _, r := range x.funcRestrictions {
+		if !strings.HasPrefix(name, r.prefix) {
+			continue
+		}
+		if err := checkFuncs(name, tf, r.allowed); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// funcsFor returns the scoped funcs for the template with the given name, or nil if there are none
+func (x *Extemplate) funcsFor(name string) template.FuncMap {
+	var funcs template.FuncMap
+	for _, s := range x.funcScopes {
+		if !strings.HasPrefix(name, s.prefix) {
+			continue
+		}
+		if funcs == nil {
+			funcs = make(template.FuncMap)
+		}
+		for k, v := range s.funcs {
+			funcs[k] = v
+		}
+	}
+	return funcs
+}
+
+// parseShared parses the non-child template file with the given name into the shared template namespace.
+// The caller must hold x.mu for writing.
+func (x *Extemplate) parseShared(name string, tf *templatefile) error {
+	if x.isText(tf) {
+		if !x.ownDelims(tf) || x.funcsFor(name) != nil {
+			return x.addTrees(name, tf, func(name string, tree *parse.Tree) error { _, err := x.text.AddParseTree(name, tree); return err })
+		}
+		_, err := x.text.New(name).Parse(string(tf.contents))
+		return err
+	}
+
+	if !x.ownDelims(tf) || x.funcsFor(name) != nil {
+		return x.addTrees(name, tf, func(name string, tree *parse.Tree) error { _, err := x.shared.AddParseTree(name, tree); return err })
+	}
+	_, err := x.shared.New(name).Parse(string(tf.contents))
+	return err
+}
+
+// ownDelims reports whether tf is written with the delimiters of x, see DelimsFor
+func (x *Extemplate) ownDelims(tf *templatefile) bool {
+	return tf.leftDelim == x.leftDelim && tf.rightDelim == x.rightDelim
+}
+
+// addTrees parses tf separately from any set, using its own delimiters and the funcs of the template with the given name,
+// and passes the resulting parse trees to add.
+// This is needed for files that the sets of x can not parse, as they know only about the global funcs and delimiters.
+func (x *Extemplate) addTrees(name string, tf *templatefile, add func(name string, tree *parse.Tree) error) error {
+	t, err := texttemplate.New(name).Delims(tf.leftDelim, tf.rightDelim).
+		Funcs(texttemplate.FuncMap(x.funcs)).Funcs(texttemplate.FuncMap(x.funcsFor(name))).Parse(string(tf.contents))
+	if err != nil {
+		return err
+	}
+
+	for _, st := range t.Templates() {
+		if err := add(st.Name(), st.Tree); err != nil {
+			return err
+		}
+	}
+	return nil
+}
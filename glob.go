@@ -0,0 +1,82 @@
+// Copyright 2017 Danny van Kooten. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package extemplate
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Glob returns the sorted names of all templates matching pattern, e.g. "widgets/*.tmpl".
+// The pattern syntax is that of path.Match; Glob returns nil if the pattern is malformed.
+func (x *Extemplate) Glob(pattern string) []string {
+	pattern = x.normalize(pattern)
+
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	var names []string
+	for name := range x.files {
+		if ok, _ := path.Match(pattern, name); ok && strings.HasPrefix(name, x.subset) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ExecuteGlob applies all templates matching pattern to the specified data object, in the order returned by Glob,
+// and writes their output to wr.
+// It returns an error if the pattern is malformed or matches no templates.
+func (x *Extemplate) ExecuteGlob(wr io.Writer, pattern string, data interface{}) error {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return err
+	}
+
+	names := x.Glob(pattern)
+	if len(names) == 0 {
+		return fmt.Errorf("extemplate: pattern matches no templates: %#q", pattern)
+	}
+	for _, name := range names {
+		if err := x.ExecuteTemplate(wr, name, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WalkFunc is called by Walk for every registered template, with the name of the template it extends, if any.
+// t is a copy owned by the caller, or nil for text templates, which can be retrieved using LookupText.
+type WalkFunc func(name string, t *template.Template, layout string) error
+
+// Walk calls fn for every registered template in lexical order, compiling templates as needed.
+// If fn or compiling a template returns an error, Walk stops and returns it.
+func (x *Extemplate) Walk(fn WalkFunc) error {
+	x.mu.RLock()
+	var names []string
+	layouts := make(map[string]string)
+	for name, tf := range x.files {
+		if strings.HasPrefix(name, x.subset) {
+			names = append(names, name)
+			layouts[name] = tf.layout
+		}
+	}
+	x.mu.RUnlock()
+
+	sort.Strings(names)
+	for _, name := range names {
+		if _, err := x.pool(name); err != nil {
+			return err
+		}
+		if err := fn(name, x.LookupCopy(name), layouts[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
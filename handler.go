@@ -0,0 +1,253 @@
+// Copyright 2017 Danny van Kooten. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package extemplate
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HandlerOption configures a handler returned by Handler
+type HandlerOption func(h *handler)
+
+// DataProvider returns the data to execute the template with the given name with for request r
+type DataProvider func(r *http.Request, name string) (interface{}, error)
+
+type handler struct {
+	x        *Extemplate
+	ext      string
+	data     DataProvider
+	notFound string
+	failed   string
+}
+
+// WithHandlerExtension sets the file extension appended to request paths to find the template to render.
+// The default is ".tmpl".
+func WithHandlerExtension(ext string) HandlerOption {
+	return func(h *handler) {
+		h.ext = ext
+	}
+}
+
+// WithDataProvider sets the function providing the data that templates are executed with
+func WithDataProvider(fn DataProvider) HandlerOption {
+	return func(h *handler) {
+		h.data = fn
+	}
+}
+
+// WithNotFoundTemplate sets the template rendered when no template matches the request path.
+// The default is "404" followed by the handler extension.
+func WithNotFoundTemplate(name string) HandlerOption {
+	return func(h *handler) {
+		h.notFound = name
+	}
+}
+
+// WithErrorTemplate sets the template rendered when the data provider or executing the template fails.
+// The error template is executed with the error as its data.
+// The default is "500" followed by the handler extension.
+func WithErrorTemplate(name string) HandlerOption {
+	return func(h *handler) {
+		h.failed = name
+	}
+}
+
+// Handler returns an http.Handler rendering templates by request path,
+// so that "/about" renders "about.tmpl" and "/" or "/blog/" render "index.tmpl" and "blog/index.tmpl".
+// With WithDirectoryIndex, "/blog" renders the index template of "blog" too, unless "blog.tmpl" exists.
+// Layouts and partials, templates that other templates extend or invoke, are not rendered.
+// Requests without a matching template render the 404 template, failures render the 500 template.
+// If these templates do not exist, a plain-text error is written instead.
+func Handler(x *Extemplate, opts ...HandlerOption) http.Handler {
+	h := &handler{x: x, ext: ".tmpl"}
+	for _, opt := range opts {
+		opt(h)
+	}
+	if h.notFound == "" {
+		h.notFound = "404" + h.ext
+	}
+	if h.failed == "" {
+		h.failed = "500" + h.ext
+	}
+	return h
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := h.templateName(r.URL.Path)
+	if dir := strings.TrimSuffix(name, h.ext); !h.x.exists(name) && len(h.x.indexes) > 0 && h.x.exists(dir) {
+		name = h.x.resolve(dir)
+	}
+	if name == h.notFound || name == h.failed || !h.x.exists(name) || h.x.internal(name) {
+		h.render(w, r, http.StatusNotFound, h.notFound, nil)
+		return
+	}
+
+	var data interface{}
+	if h.data != nil {
+		var err error
+		if data, err = h.data(r, name); err != nil {
+			h.render(w, r, http.StatusInternalServerError, h.failed, err)
+			return
+		}
+	}
+
+	h.render(w, r, http.StatusOK, name, data)
+}
+
+// internal reports whether the named template is only meant to be used by other templates:
+// it is the layout of another template, or invoked by one, like layouts and partials
+func (x *Extemplate) internal(name string) bool {
+	name = x.resolve(name)
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	for other, tf := range x.files {
+		if other == name {
+			continue
+		}
+		if tf.layout == name {
+			return true
+		}
+		for _, u := range tf.uses {
+			if u == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// templateName maps a request path to the name of the template to render
+func (h *handler) templateName(p string) string {
+	dir := strings.HasSuffix(p, "/")
+	p = strings.TrimPrefix(path.Clean("/"+p), "/")
+	if dir || p == "" {
+		p = path.Join(p, "index")
+	}
+	return p + h.ext
+}
+
+// render renders the named template, replacing it with the error template if it fails
+func (h *handler) render(w http.ResponseWriter, r *http.Request, status int, name string, data interface{}) {
+	if !h.x.exists(name) {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+
+	err := h.x.render(r.Context(), w, status, name, data)
+	if err == nil || isFallback(err) {
+		return
+	}
+	if status != http.StatusInternalServerError {
+		h.render(w, r, http.StatusInternalServerError, h.failed, err)
+		return
+	}
+	http.Error(w, http.StatusText(status), status)
+}
+
+// Render applies the named template to data and writes the output as the response with the given status code.
+// The output is buffered, so that nothing is written to w if executing the template fails,
+// unless the error template is rendered in its place, which is written with status 500, see SetErrorTemplate.
+// Content-Type is set to text/html, or to text/plain for text templates, unless it was already set.
+func (x *Extemplate) Render(w http.ResponseWriter, status int, name string, data interface{}) error {
+	return x.render(context.Background(), w, status, name, data)
+}
+
+func (x *Extemplate) render(ctx context.Context, w http.ResponseWriter, status int, name string, data interface{}) error {
+	var buf bytes.Buffer
+	if err := x.ExecuteTemplateContext(ctx, &buf, name, data); err != nil {
+		return x.writeFallback(w, &buf, err)
+	}
+
+	return writeBuffered(w, status, x.contentType(name), &buf)
+}
+
+// writeFallback writes buf as the response with status 500 if err reports that it holds the error template,
+// rendered in place of the output of a template that failed to execute, see SetErrorTemplate.
+// It returns err, or the error writing the response.
+func (x *Extemplate) writeFallback(w http.ResponseWriter, buf *bytes.Buffer, err error) error {
+	if !isFallback(err) {
+		return err
+	}
+	if werr := writeBuffered(w, http.StatusInternalServerError, x.contentType(x.errorTemplate), buf); werr != nil {
+		return werr
+	}
+	return err
+}
+
+// writeBuffered writes buf as the response with the given status code, setting Content-Type unless it was already set
+func writeBuffered(w http.ResponseWriter, status int, contentType string, buf *bytes.Buffer) error {
+	h := w.Header()
+	if h.Get("Content-Type") == "" {
+		h.Set("Content-Type", contentType)
+	}
+	h.Set("Content-Length", strconv.Itoa(buf.Len()))
+	w.WriteHeader(status)
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+// ServeTemplate applies the named template to data and serves the output using http.ServeContent,
+// which responds with 304 Not Modified when the request's If-None-Match or If-Modified-Since header allows it.
+// The ETag is computed from the output. Last-Modified is the latest modification time of the template files
+// it may be composed of, so it does not reflect changes in data: only use it for otherwise static pages,
+// or set Last-Modified yourself before calling ServeTemplate.
+// Like Render, the error template is written with status 500 if it is rendered in place of the output.
+func (x *Extemplate) ServeTemplate(w http.ResponseWriter, r *http.Request, name string, data interface{}) error {
+	var buf bytes.Buffer
+	if err := x.ExecuteTemplateContext(r.Context(), &buf, name, data); err != nil {
+		return x.writeFallback(w, &buf, err)
+	}
+
+	h := w.Header()
+	if h.Get("Content-Type") == "" {
+		h.Set("Content-Type", x.contentType(name))
+	}
+	sum := sha256.Sum256(buf.Bytes())
+	h.Set("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+
+	modTime := x.modTime(name)
+	if t, err := http.ParseTime(h.Get("Last-Modified")); err == nil {
+		modTime = t
+	}
+	http.ServeContent(w, r, name, modTime, bytes.NewReader(buf.Bytes()))
+	return nil
+}
+
+// modTime returns the latest modification time of the files in the layout chain of the named template
+// and the shared templates it may invoke
+func (x *Extemplate) modTime(name string) time.Time {
+	name = x.resolve(name)
+
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	var t time.Time
+	for n, tf := range x.files {
+		if (tf.layout == "" || x.dependsOn(name, map[string]bool{n: true})) && tf.modTime.After(t) {
+			t = tf.modTime
+		}
+	}
+	return t
+}
+
+// contentType returns the content type of the output of the named template
+func (x *Extemplate) contentType(name string) string {
+	name = x.resolve(name)
+
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	if tf, ok := x.files[name]; ok && x.isText(tf) {
+		return "text/plain; charset=utf-8"
+	}
+	return "text/html; charset=utf-8"
+}
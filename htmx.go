@@ -0,0 +1,33 @@
+// Copyright 2017 Danny van Kooten. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package extemplate
+
+import (
+	"bytes"
+	"net/http"
+)
+
+// RenderHTMX renders the named template as response to r. When r is an htmx request whose HX-Target header
+// names a block of the template, only that block is rendered, so that the same handler serves full pages and partial updates.
+// Boosted requests, which swap the whole body, and requests without a matching block render the whole template.
+func (x *Extemplate) RenderHTMX(w http.ResponseWriter, r *http.Request, name string, data interface{}) error {
+	w.Header().Add("Vary", "HX-Request, HX-Target")
+	if block := htmxBlock(r); block != "" && x.hasBlock(name, block) {
+		var buf bytes.Buffer
+		if err := x.ExecuteBlock(r.Context(), &buf, name, block, data); err != nil {
+			return err
+		}
+		return writeBuffered(w, http.StatusOK, x.contentType(name), &buf)
+	}
+	return x.render(r.Context(), w, http.StatusOK, name, data)
+}
+
+// htmxBlock returns the id of the element targeted by htmx request r, or "" if r is no partial htmx request
+func htmxBlock(r *http.Request) string {
+	if r.Header.Get("HX-Request") != "true" || r.Header.Get("HX-Boosted") == "true" {
+		return ""
+	}
+	return r.Header.Get("HX-Target")
+}
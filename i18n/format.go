@@ -0,0 +1,162 @@
+// Copyright 2017 Danny van Kooten. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package i18n
+
+import (
+	"context"
+	"html/template"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dannyvankooten/extemplate"
+	"golang.org/x/text/currency"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+	"golang.org/x/text/number"
+)
+
+// RegisterFormatters adds formatting funcs to x, for numbers, currencies and dates in the locale of the execution:
+//
+//	{{ formatNumber 1234567.891 2 }}     -> 1,234,567.89 or 1.234.567,89
+//	{{ formatCurrency 12.5 "EUR" }}      -> € 12.50 or € 12,50
+//	{{ formatDate .Time "Monday 2 January 2006" "Europe/Amsterdam" }} -> Wednesday 1 January 2020 or woensdag 1 januari 2020
+//
+// Values are formatted for the locale from the execution context, or fallback if there is none.
+// Dates are formatted using the given Go layout, with month and day names in Dutch, German, French or Spanish
+// for those locales and in English for all others.
+// It must be called before templates are parsed.
+func RegisterFormatters(x *extemplate.Extemplate, fallback language.Tag) *extemplate.Extemplate {
+	return x.ContextFuncs(func(ctx context.Context) template.FuncMap {
+		tag := Locale(ctx, fallback)
+		p := message.NewPrinter(tag)
+		return template.FuncMap{
+			"formatNumber":   func(v interface{}, decimals ...int) string { return formatNumber(p, v, decimals...) },
+			"formatCurrency": func(amount float64, code string) (string, error) { return formatCurrency(p, amount, code) },
+			"formatDate": func(t time.Time, layout string, tz ...string) (string, error) {
+				return formatDate(tag, t, layout, tz...)
+			},
+		}
+	})
+}
+
+// locations caches loaded time zones by name, as time.LoadLocation reads them from disk on every call
+var locations sync.Map
+
+// loadLocation is time.LoadLocation, caching its results
+func loadLocation(name string) (*time.Location, error) {
+	if loc, ok := locations.Load(name); ok {
+		return loc.(*time.Location), nil
+	}
+
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, err
+	}
+	locations.Store(name, loc)
+	return loc, nil
+}
+
+// formatDate formats t using layout, optionally converting it to the named time zone first.
+// Month and day names are translated into the language of tag, if known.
+func formatDate(tag language.Tag, t time.Time, layout string, tz ...string) (string, error) {
+	if len(tz) > 0 {
+		loc, err := loadLocation(tz[0])
+		if err != nil {
+			return "", err
+		}
+		t = t.In(loc)
+	}
+
+	base, _ := tag.Base()
+	names, ok := dateNames[base.String()]
+	if !ok {
+		return t.Format(layout), nil
+	}
+
+	// format the layout in chunks, replacing the month and day names
+	var b strings.Builder
+	start := 0
+	for i := 0; i < len(layout); i++ {
+		var name string
+		var n int
+		switch {
+		case strings.HasPrefix(layout[i:], "January"):
+			name, n = names.months[t.Month()-1], len("January")
+		case strings.HasPrefix(layout[i:], "Jan") && !startsWithLower(layout[i+3:]):
+			name, n = names.shortMonths[t.Month()-1], len("Jan")
+		case strings.HasPrefix(layout[i:], "Monday"):
+			name, n = names.days[t.Weekday()], len("Monday")
+		case strings.HasPrefix(layout[i:], "Mon") && !startsWithLower(layout[i+3:]):
+			name, n = names.shortDays[t.Weekday()], len("Mon")
+		default:
+			continue
+		}
+		b.WriteString(t.Format(layout[start:i]))
+		b.WriteString(name)
+		i += n - 1
+		start = i + 1
+	}
+	b.WriteString(t.Format(layout[start:]))
+	return b.String(), nil
+}
+
+// startsWithLower reports whether s starts with a lower case letter, in which case time.Format does not
+// treat a preceding "Jan" or "Mon" as a month or day name
+func startsWithLower(s string) bool {
+	return len(s) > 0 && 'a' <= s[0] && s[0] <= 'z'
+}
+
+type calendarNames struct {
+	months, shortMonths [12]string
+	days, shortDays     [7]string
+}
+
+// dateNames holds the month and day names by language, with days starting at Sunday like time.Weekday
+var dateNames = map[string]calendarNames{
+	"nl": {
+		months:      [12]string{"januari", "februari", "maart", "april", "mei", "juni", "juli", "augustus", "september", "oktober", "november", "december"},
+		shortMonths: [12]string{"jan", "feb", "mrt", "apr", "mei", "jun", "jul", "aug", "sep", "okt", "nov", "dec"},
+		days:        [7]string{"zondag", "maandag", "dinsdag", "woensdag", "donderdag", "vrijdag", "zaterdag"},
+		shortDays:   [7]string{"zo", "ma", "di", "wo", "do", "vr", "za"},
+	},
+	"de": {
+		months:      [12]string{"Januar", "Februar", "März", "April", "Mai", "Juni", "Juli", "August", "September", "Oktober", "November", "Dezember"},
+		shortMonths: [12]string{"Jan", "Feb", "Mär", "Apr", "Mai", "Jun", "Jul", "Aug", "Sep", "Okt", "Nov", "Dez"},
+		days:        [7]string{"Sonntag", "Montag", "Dienstag", "Mittwoch", "Donnerstag", "Freitag", "Samstag"},
+		shortDays:   [7]string{"So", "Mo", "Di", "Mi", "Do", "Fr", "Sa"},
+	},
+	"fr": {
+		months:      [12]string{"janvier", "février", "mars", "avril", "mai", "juin", "juillet", "août", "septembre", "octobre", "novembre", "décembre"},
+		shortMonths: [12]string{"janv.", "févr.", "mars", "avr.", "mai", "juin", "juil.", "août", "sept.", "oct.", "nov.", "déc."},
+		days:        [7]string{"dimanche", "lundi", "mardi", "mercredi", "jeudi", "vendredi", "samedi"},
+		shortDays:   [7]string{"dim.", "lun.", "mar.", "mer.", "jeu.", "ven.", "sam."},
+	},
+	"es": {
+		months:      [12]string{"enero", "febrero", "marzo", "abril", "mayo", "junio", "julio", "agosto", "septiembre", "octubre", "noviembre", "diciembre"},
+		shortMonths: [12]string{"ene", "feb", "mar", "abr", "may", "jun", "jul", "ago", "sept", "oct", "nov", "dic"},
+		days:        [7]string{"domingo", "lunes", "martes", "miércoles", "jueves", "viernes", "sábado"},
+		shortDays:   [7]string{"dom", "lun", "mar", "mié", "jue", "vie", "sáb"},
+	},
+}
+
+// formatNumber formats v with locale-specific grouping and decimal separators
+func formatNumber(p *message.Printer, v interface{}, decimals ...int) string {
+	if len(decimals) > 0 {
+		return p.Sprint(number.Decimal(v, number.MinFractionDigits(decimals[0]), number.MaxFractionDigits(decimals[0])))
+	}
+
+	return p.Sprint(number.Decimal(v))
+}
+
+// formatCurrency formats amount in the currency with the given ISO 4217 code
+func formatCurrency(p *message.Printer, amount float64, code string) (string, error) {
+	unit, err := currency.ParseISO(code)
+	if err != nil {
+		return "", err
+	}
+
+	return p.Sprint(currency.Symbol(unit.Amount(amount))), nil
+}
@@ -0,0 +1,53 @@
+// Copyright 2017 Danny van Kooten. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// Package i18n registers translation funcs backed by golang.org/x/text message catalogs.
+//
+// Templates can use {{ t "Hello %s" .Name }} and {{ tn "%d items" .Count }},
+// which are translated into the locale stored in the execution context using WithLocale.
+package i18n
+
+import (
+	"context"
+	"html/template"
+
+	"github.com/dannyvankooten/extemplate"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+	"golang.org/x/text/message/catalog"
+)
+
+type localeKey struct{}
+
+// WithLocale returns a copy of ctx carrying the given locale
+func WithLocale(ctx context.Context, tag language.Tag) context.Context {
+	return context.WithValue(ctx, localeKey{}, tag)
+}
+
+// Locale returns the locale stored in ctx, or fallback if there is none
+func Locale(ctx context.Context, fallback language.Tag) language.Tag {
+	if tag, ok := ctx.Value(localeKey{}).(language.Tag); ok {
+		return tag
+	}
+
+	return fallback
+}
+
+// Register adds the t and tn funcs to x, translating messages using cat.
+// Messages are translated into the locale from the context passed to ExecuteTemplateContext,
+// or into fallback if the context carries no locale.
+// It must be called before templates are parsed.
+func Register(x *extemplate.Extemplate, cat catalog.Catalog, fallback language.Tag) *extemplate.Extemplate {
+	return x.ContextFuncs(func(ctx context.Context) template.FuncMap {
+		p := message.NewPrinter(Locale(ctx, fallback), message.Catalog(cat))
+		return template.FuncMap{
+			"t": func(key string, args ...interface{}) string {
+				return p.Sprintf(key, args...)
+			},
+			"tn": func(key string, n int, args ...interface{}) string {
+				return p.Sprintf(key, append([]interface{}{n}, args...)...)
+			},
+		}
+	})
+}
@@ -0,0 +1,78 @@
+package i18n
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dannyvankooten/extemplate"
+	"golang.org/x/text/feature/plural"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message/catalog"
+)
+
+func TestRegister(t *testing.T) {
+	cat := catalog.NewBuilder()
+	cat.SetString(language.Dutch, "Hello %s", "Hallo %s")
+	cat.Set(language.Dutch, "%d items", plural.Selectf(1, "%d",
+		"one", "%d artikel",
+		"other", "%d artikelen",
+	))
+
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "page.tmpl"), []byte(`{{ t "Hello %s" . }}, {{ tn "%d items" 3 }}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	x := Register(extemplate.New(), cat, language.English)
+	if err := x.ParseDir(dir, []string{".tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[language.Tag]string{
+		language.Dutch:   "Hallo Danny, 3 artikelen",
+		language.English: "Hello Danny, 3 items",
+	}
+	for tag, e := range tests {
+		var buf bytes.Buffer
+		ctx := WithLocale(context.Background(), tag)
+		if err := x.ExecuteTemplateContext(ctx, &buf, "page.tmpl", "Danny"); err != nil {
+			t.Fatal(err)
+		}
+		if a := buf.String(); a != e {
+			t.Errorf("locale %s: expected %q, got %q", tag, e, a)
+		}
+	}
+}
+
+func TestRegisterFormatters(t *testing.T) {
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "page.tmpl"), []byte(`{{ formatDate . "Monday 2 January 2006 15:04" "Europe/Amsterdam" }} {{ formatDate . "Mon Jan 2" }} {{ formatNumber 1234567.891 2 }} {{ formatCurrency 12.5 "EUR" }}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	x := RegisterFormatters(extemplate.New(), language.English)
+	if err := x.ParseDir(dir, []string{".tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[language.Tag]string{
+		language.Dutch:   "woensdag 1 januari 2020 13:00 wo jan 1 1.234.567,89 € 12,50",
+		language.English: "Wednesday 1 January 2020 13:00 Wed Jan 1 1,234,567.89 € 12.50",
+	}
+	for tag, e := range tests {
+		var buf bytes.Buffer
+		ctx := WithLocale(context.Background(), tag)
+		if err := x.ExecuteTemplateContext(ctx, &buf, "page.tmpl", time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)); err != nil {
+			t.Fatal(err)
+		}
+		if a := buf.String(); a != e {
+			t.Errorf("locale %s: expected %q, got %q", tag, e, a)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright 2017 Danny van Kooten. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package extemplate
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html/template"
+)
+
+// maxIncludeDepth is the maximum nesting depth of include calls, to guard against templates including themselves
+const maxIncludeDepth = 100
+
+type includeDepthKey struct{}
+
+// includeFunc returns the include func bound to ctx, which executes the named template with the given data
+// and returns its output, like {{ include "partials/card.tmpl" (dict "Title" .Title) }}.
+// Unlike the template action, it can execute any template in the set, as every template is compiled separately.
+// The output of text templates is escaped when included in an HTML template.
+// Output filters apply to the output of the including template only, see AddOutputFilter.
+func (x *Extemplate) includeFunc(ctx context.Context) func(name string, data ...interface{}) (template.HTML, error) {
+	return func(name string, data ...interface{}) (template.HTML, error) {
+		if len(data) > 1 {
+			return "", fmt.Errorf("extemplate: include expects at most 1 data argument, got %d", len(data))
+		}
+		var d interface{}
+		if len(data) == 1 {
+			d = data[0]
+		}
+
+		depth, _ := ctx.Value(includeDepthKey{}).(int)
+		if depth >= maxIncludeDepth {
+			return "", fmt.Errorf("extemplate: include of %q nested more than %d deep", name, maxIncludeDepth)
+		}
+
+		var buf bytes.Buffer
+		if err := x.executeNested(context.WithValue(ctx, includeDepthKey{}, depth+1), &buf, name, d); err != nil {
+			return "", err
+		}
+
+		if x.isTextTemplate(name) && !x.isTextTemplate(templateName(ctx)) {
+			return template.HTML(template.HTMLEscapeString(buf.String())), nil
+		}
+		return template.HTML(buf.String()), nil
+	}
+}
+
+// isTextTemplate reports whether the named template is parsed using text/template
+func (x *Extemplate) isTextTemplate(name string) bool {
+	name = x.resolve(name)
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	tf, ok := x.files[name]
+	return ok && x.isText(tf)
+}
+
+// dict returns a map of the given key and value pairs, for passing multiple values to a template.
+// For example, {{ include "card.tmpl" (dict "Title" .Title "User" $user) }}.
+func dict(pairs ...interface{}) (map[string]interface{}, error) {
+	if len(pairs)%2 != 0 {
+		return nil, fmt.Errorf("extemplate: dict expects an even number of arguments, got %d", len(pairs))
+	}
+
+	m := make(map[string]interface{}, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		key, ok := pairs[i].(string)
+		if !ok {
+			return nil, fmt.Errorf("extemplate: dict keys must be strings, got %T", pairs[i])
+		}
+		m[key] = pairs[i+1]
+	}
+	return m, nil
+}
@@ -0,0 +1,85 @@
+// Copyright 2017 Danny van Kooten. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package extemplate
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"io/ioutil"
+)
+
+// defaultMaxInlineSize is the maximum size of files embedded using the inline func, unless set using WithMaxInlineSize
+const defaultMaxInlineSize = 1 << 20
+
+// WithMaxInlineSize sets the maximum size in bytes of files embedded using the inline template func, 1 MiB by default.
+func WithMaxInlineSize(n int64) Option {
+	return func(x *Extemplate) {
+		x.maxInlineSize = n
+	}
+}
+
+// inlineFunc is the inline template func, which embeds the file at the given path as-is, e.g. {{ inline "icons/check.svg" }}.
+// The path is relative to the directory or file system templates were last parsed from, just like template names.
+// Files are cached until templates are parsed from another file system or the file is reloaded using ReloadFile.
+func (x *Extemplate) inlineFunc(name string) (template.HTML, error) {
+	x.assetMu.RLock()
+	s, ok := x.inlined[name]
+	x.assetMu.RUnlock()
+	if ok {
+		return s, nil
+	}
+
+	x.mu.RLock()
+	fsys := x.fsys
+	x.mu.RUnlock()
+	if fsys == nil {
+		return "", fmt.Errorf("extemplate: inline %q: templates were not parsed from a file system", name)
+	}
+	if !fs.ValidPath(name) {
+		return "", fmt.Errorf("extemplate: inline %q: invalid path", name)
+	}
+
+	f, err := fsys.Open(name)
+	if err != nil {
+		return "", fmt.Errorf("extemplate: inline: %w", err)
+	}
+	defer f.Close()
+
+	limit := x.maxInlineSize
+	if limit <= 0 {
+		limit = defaultMaxInlineSize
+	}
+	contents, err := ioutil.ReadAll(io.LimitReader(f, limit+1))
+	if err != nil {
+		return "", fmt.Errorf("extemplate: inline: %w", err)
+	}
+	if int64(len(contents)) > limit {
+		return "", fmt.Errorf("extemplate: inline %q exceeds maximum size of %d bytes", name, limit)
+	}
+
+	s = template.HTML(contents)
+	x.assetMu.Lock()
+	if x.inlined == nil {
+		x.inlined = make(map[string]template.HTML)
+	}
+	x.inlined[name] = s
+	x.assetMu.Unlock()
+	return s, nil
+}
+
+// forgetAssets removes the named file from the caches of the inline and integrity funcs, or all files if name is empty
+func (x *Extemplate) forgetAssets(name string) {
+	x.assetMu.Lock()
+	defer x.assetMu.Unlock()
+	if name == "" {
+		x.inlined = nil
+		x.integrities = nil
+		return
+	}
+	delete(x.inlined, name)
+	delete(x.integrities, name)
+}
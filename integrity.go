@@ -0,0 +1,71 @@
+// Copyright 2017 Danny van Kooten. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package extemplate
+
+import (
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/fs"
+)
+
+// SetIntegrityManifest sets the subresource integrity hashes returned by the integrity template func, by file path,
+// e.g. as generated by an asset pipeline. Files not in the manifest are hashed when the func is first called for them.
+// The return value is the Extemplate instance, so calls can be chained.
+func (x *Extemplate) SetIntegrityManifest(manifest map[string]string) *Extemplate {
+	x.assetMu.Lock()
+	x.integrityManifest = manifest
+	x.assetMu.Unlock()
+	return x
+}
+
+// integrityFunc is the integrity template func, which returns the sha384 subresource integrity hash of the file at the given path:
+//
+//	<script src="/app.js" integrity="{{ integrity "static/app.js" }}" crossorigin="anonymous"></script>
+//
+// Like for the inline func, the path is relative to the directory or file system templates were last parsed from,
+// and hashes are cached until templates are parsed from another file system or the file is reloaded using ReloadFile.
+func (x *Extemplate) integrityFunc(name string) (string, error) {
+	x.assetMu.RLock()
+	s, ok := x.integrityManifest[name]
+	if !ok {
+		s, ok = x.integrities[name]
+	}
+	x.assetMu.RUnlock()
+	if ok {
+		return s, nil
+	}
+
+	x.mu.RLock()
+	fsys := x.fsys
+	x.mu.RUnlock()
+	if fsys == nil {
+		return "", fmt.Errorf("extemplate: integrity %q: templates were not parsed from a file system", name)
+	}
+	if !fs.ValidPath(name) {
+		return "", fmt.Errorf("extemplate: integrity %q: invalid path", name)
+	}
+
+	f, err := fsys.Open(name)
+	if err != nil {
+		return "", fmt.Errorf("extemplate: integrity: %w", err)
+	}
+	defer f.Close()
+
+	h := sha512.New384()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("extemplate: integrity: %w", err)
+	}
+	s = "sha384-" + base64.StdEncoding.EncodeToString(h.Sum(nil))
+
+	x.assetMu.Lock()
+	if x.integrities == nil {
+		x.integrities = make(map[string]string)
+	}
+	x.integrities[name] = s
+	x.assetMu.Unlock()
+	return s, nil
+}
@@ -0,0 +1,77 @@
+// Copyright 2017 Danny van Kooten. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package extemplate
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// ParseDirs is like ParseDir, but parses the templates in several root directories as if they were one.
+// When files in multiple roots have the same name, the file in the earliest root takes precedence,
+// so that an application can override templates shipped by a module by listing its own directory first.
+// To parse several file systems, use ParseFS with LayeredFS.
+func (x *Extemplate) ParseDirs(roots []string, extensions []string) error {
+	layers := make([]fs.FS, len(roots))
+	for i, root := range roots {
+		layers[i] = os.DirFS(filepath.Clean(root))
+	}
+	return x.ParseFSContext(context.Background(), LayeredFS(layers...), extensions)
+}
+
+// LayeredFS returns a file system combining the given file systems.
+// Files in earlier file systems take precedence over files with the same path in later ones,
+// while the entries of directories are merged.
+func LayeredFS(layers ...fs.FS) fs.FS {
+	return layeredFS(layers)
+}
+
+type layeredFS []fs.FS
+
+// Open opens the named file in the first layer containing it
+func (l layeredFS) Open(name string) (fs.File, error) {
+	for _, fsys := range l {
+		f, err := fsys.Open(name)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		return f, err
+	}
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
+
+// ReadDir returns the entries of the named directory in all layers, sorted by filename
+func (l layeredFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	var entries []fs.DirEntry
+	seen := make(map[string]bool)
+	found := false
+	for _, fsys := range l {
+		layer, err := fs.ReadDir(fsys, name)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		found = true
+		for _, e := range layer {
+			if !seen[e.Name()] {
+				seen[e.Name()] = true
+				entries = append(entries, e)
+			}
+		}
+	}
+
+	if !found {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
@@ -0,0 +1,128 @@
+// Copyright 2017 Danny van Kooten. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package extemplate
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
+)
+
+// LintIssue describes a likely mistake in a template file
+type LintIssue struct {
+	// Name is the name of the template the issue was found in
+	Name    string
+	Message string
+}
+
+func (i LintIssue) String() string {
+	return i.Name + ": " + i.Message
+}
+
+// Lint reports likely mistakes in the parsed templates:
+// templates defined in a child that are not a block in its layout chain and not invoked anywhere, since those never render,
+// and templates defined in more than one shared file, since only one of the definitions is used.
+func (x *Extemplate) Lint() []LintIssue {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	var issues []LintIssue
+
+	// templates invoked by shared files may be defined by children
+	sharedUses := make(map[string]bool)
+	definedIn := make(map[string][]string)
+	for name, tf := range x.files {
+		if tf.layout != "" {
+			continue
+		}
+		for _, u := range tf.uses {
+			sharedUses[u] = true
+		}
+		for _, d := range defined(tf) {
+			definedIn[d] = append(definedIn[d], name)
+		}
+	}
+
+	for d, names := range definedIn {
+		if len(names) > 1 {
+			sort.Strings(names)
+			for _, name := range names {
+				issues = append(issues, LintIssue{name, fmt.Sprintf("template %q is also defined in %s", d, strings.Join(without(names, name), ", "))})
+			}
+		}
+	}
+
+	for name, tf := range x.files {
+		if tf.layout == "" {
+			continue
+		}
+
+		// collect all names known to the layout chain, excluding the child itself
+		known := make(map[string]bool)
+		for _, u := range tf.uses {
+			known[u] = true
+		}
+		p, ok := x.files[tf.layout]
+		for i := 0; ok && i <= len(x.files); i++ {
+			for _, d := range p.defines {
+				known[d] = true
+			}
+			for _, u := range p.uses {
+				known[u] = true
+			}
+			p, ok = x.files[p.layout]
+		}
+
+		for _, d := range defined(tf) {
+			if !known[d] && !sharedUses[d] {
+				issues = append(issues, LintIssue{name, fmt.Sprintf("template %q is not a block in layout %q and never invoked", d, tf.layout)})
+			}
+		}
+	}
+
+	sort.Slice(issues, func(i, j int) bool {
+		if issues[i].Name != issues[j].Name {
+			return issues[i].Name < issues[j].Name
+		}
+		return issues[i].Message < issues[j].Message
+	})
+	return issues
+}
+
+// defined returns the names of the templates defined in tf, other than tf itself
+func defined(tf *templatefile) []string {
+	if len(tf.defines) == 0 {
+		return nil
+	}
+	return tf.defines[1:]
+}
+
+// LintFS is like Lint, but lints the template files in fsys without parsing them into the set.
+// Since templates are not compiled, funcs they use do not need to be registered, which makes it suitable for tooling.
+func (x *Extemplate) LintFS(fsys fs.FS, extensions []string) ([]LintIssue, error) {
+	files, err := x.findTemplateFiles(context.Background(), fsys, extensions)
+	if err != nil {
+		return nil, err
+	}
+
+	l := New()
+	for name, tf := range files {
+		tf.defines, tf.uses = references(name, tf.contents, tf.leftDelim, tf.rightDelim)
+		l.files[name] = tf
+	}
+	return l.Lint(), nil
+}
+
+func without(s []string, v string) []string {
+	var r []string
+	for _, e := range s {
+		if e != v {
+			r = append(r, e)
+		}
+	}
+	return r
+}
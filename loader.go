@@ -0,0 +1,232 @@
+// Copyright 2017 Danny van Kooten. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package extemplate
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Loader is a source of template files, like a database, object storage or an HTTP endpoint.
+// Paths are slash-separated and templates are named by their path, like files parsed using ParseFS.
+type Loader interface {
+	// List returns the paths of all template files
+	List(ctx context.Context) ([]string, error)
+	// Read returns the contents of the template file at path
+	Read(ctx context.Context, path string) ([]byte, error)
+	// Watch calls fn whenever template files may have changed, until ctx is done
+	Watch(ctx context.Context, fn func()) error
+}
+
+// ParseLoader parses all template files listed by l.
+// Like ParseFS, calling it again only recompiles templates affected by changed files.
+// If any template fails to parse, the set is left as it was and the error is returned.
+func (x *Extemplate) ParseLoader(ctx context.Context, l Loader) error {
+	_, err := x.parseLoader(ctx, l, nil)
+	return err
+}
+
+// parseLoader is like ParseLoader, but also returns the paths of the files that changed since they were last parsed.
+// Templates in loaded that l no longer lists are removed, after which loaded holds the templates l lists now.
+func (x *Extemplate) parseLoader(ctx context.Context, l Loader, loaded map[string]bool) ([]string, error) {
+	paths, err := l.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	files, err := x.loadFiles(ctx, paths, func(path string) (*templatefile, error) {
+		contents, err := l.Read(ctx, path)
+		if err != nil {
+			return nil, err
+		}
+		if x.maxFileSize > 0 && int64(len(contents)) > x.maxFileSize {
+			return nil, fmt.Errorf("extemplate: %s exceeds maximum file size of %d bytes", path, x.maxFileSize)
+		}
+		tf, err := x.prepareFile(path, contents)
+		if err != nil {
+			return nil, err
+		}
+		tf.source = sourceOf(l)
+		return tf, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if err := x.resolveConflicts(files); err != nil {
+		return nil, err
+	}
+	var changed []string
+	for _, name := range sortedFileNames(files) {
+		if old, ok := x.files[name]; !ok || old.hash != files[name].hash {
+			changed = append(changed, files[name].path)
+		}
+	}
+
+	// templates can not be removed from a template namespace, so rebuild the set from scratch if any were removed
+	next := make(map[string]*templatefile, len(files))
+	for name, tf := range files {
+		next[name] = tf
+	}
+	for name := range loaded {
+		if tf, ok := x.files[name]; ok && files[name] == nil {
+			next[name] = nil
+			changed = append(changed, tf.path)
+		}
+	}
+	if len(next) == len(files) {
+		err = x.parseFilesOrRestoreLocked(ctx, files)
+	} else {
+		old := make(map[string]*templatefile, len(next))
+		for name := range next {
+			old[name] = x.files[name]
+		}
+		if err = x.restoreLocked(next); err != nil {
+			if rerr := x.restoreLocked(old); rerr != nil {
+				err = rerr
+			}
+		}
+	}
+	if err != nil {
+		return changed, err
+	}
+
+	if loaded != nil {
+		for name := range loaded {
+			delete(loaded, name)
+		}
+		for name := range files {
+			loaded[name] = true
+		}
+	}
+	return changed, nil
+}
+
+// WatchLoader parses all template files listed by l again whenever l reports a change, until ctx is done.
+// Templates parsed from l that it no longer lists are removed.
+// Errors parsing the changed templates are passed to onError, if it is not nil, and do not stop watching.
+// Templates that fail to parse keep their previous version, see OnReload and OnReloadError.
+func (x *Extemplate) WatchLoader(ctx context.Context, l Loader, onError func(err error)) error {
+	// names of the templates parsed from l so far
+	loaded := make(map[string]bool)
+	if paths, err := l.List(ctx); err == nil {
+		listed := make(map[string]bool, len(paths))
+		for _, p := range paths {
+			listed[p] = true
+		}
+		x.mu.RLock()
+		for name, tf := range x.files {
+			if listed[tf.path] {
+				loaded[name] = true
+			}
+		}
+		x.mu.RUnlock()
+	}
+
+	return l.Watch(ctx, func() {
+		changed, err := x.parseLoader(ctx, l, loaded)
+		if err != nil && onError != nil {
+			onError(err)
+		}
+		x.reloaded(changed, err)
+	})
+}
+
+// FSLoader is a Loader for the files with the given extensions in a fs.FS.
+// Extensions are matched case-insensitively and may be given without their leading dot.
+// Since a fs.FS can not report changes, Watch polls the modification times and sizes of files every Interval.
+type FSLoader struct {
+	FS         fs.FS
+	Extensions []string
+	// Interval is the polling interval of Watch, 2 seconds if zero
+	Interval time.Duration
+}
+
+// List returns the paths of all files with one of the loader's extensions
+func (l *FSLoader) List(ctx context.Context) ([]string, error) {
+	var paths []string
+	err := fs.WalkDir(l.FS, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		for _, ext := range l.Extensions {
+			if hasExtension(p, ext, false) {
+				paths = append(paths, p)
+				break
+			}
+		}
+		return nil
+	})
+	return paths, err
+}
+
+// Read returns the contents of the file at path
+func (l *FSLoader) Read(ctx context.Context, path string) ([]byte, error) {
+	return fs.ReadFile(l.FS, path)
+}
+
+// Watch calls fn whenever the modification time or size of a file changes, or files are added or removed
+func (l *FSLoader) Watch(ctx context.Context, fn func()) error {
+	interval := l.Interval
+	if interval <= 0 {
+		interval = 2 * time.Second
+	}
+
+	last, err := l.signature(ctx)
+	if err != nil {
+		return err
+	}
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+
+		sig, err := l.signature(ctx)
+		if err != nil {
+			return err
+		}
+		if sig != last {
+			last = sig
+			fn()
+		}
+	}
+}
+
+// signature returns a string that changes whenever a file is added, removed or modified
+func (l *FSLoader) signature(ctx context.Context) (string, error) {
+	paths, err := l.List(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	for _, p := range paths {
+		info, err := fs.Stat(l.FS, p)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&b, "%s %d %d\n", p, info.ModTime().UnixNano(), info.Size())
+	}
+	return b.String(), nil
+}
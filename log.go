@@ -0,0 +1,47 @@
+// Copyright 2017 Danny van Kooten. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package extemplate
+
+import (
+	"time"
+)
+
+// logLevel is the level of a logged event, with the values of the levels of log/slog
+type logLevel int
+
+const (
+	levelDebug logLevel = -4
+	levelInfo  logLevel = 0
+	levelWarn  logLevel = 4
+)
+
+// defaultSlowRender is the duration above which renders are logged as slow, unless set using SetSlowRenderThreshold
+const defaultSlowRender = 100 * time.Millisecond
+
+// SetSlowRenderThreshold sets the duration above which executing a template is logged as slow, 100ms by default.
+// A negative duration disables logging slow renders. See SetLogger.
+// The return value is the Extemplate instance, so calls can be chained.
+func (x *Extemplate) SetSlowRenderThreshold(d time.Duration) *Extemplate {
+	x.slowRender = d
+	return x
+}
+
+// log logs an event with the given key and value pairs, if a logger is set
+func (x *Extemplate) log(level logLevel, msg string, args ...interface{}) {
+	if x.logFunc != nil {
+		x.logFunc(level, msg, args...)
+	}
+}
+
+// logSlowRender logs the execution of the named template if it started longer ago than the slow render threshold
+func (x *Extemplate) logSlowRender(name string, start time.Time) {
+	threshold := x.slowRender
+	if threshold == 0 {
+		threshold = defaultSlowRender
+	}
+	if d := time.Since(start); threshold > 0 && d > threshold {
+		x.log(levelWarn, "extemplate: slow render", "template", name, "duration", d)
+	}
+}
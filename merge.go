@@ -0,0 +1,178 @@
+// Copyright 2017 Danny van Kooten. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package extemplate
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Merge adds all templates and funcs of other to x, recompiling templates where needed.
+// If overwrite is false, Merge returns an error when both sets have a template with the same name
+// and leaves x unchanged; otherwise the templates and funcs of other take precedence.
+// Context funcs registered on other using ContextFuncs are not merged, and the url, inline and integrity funcs
+// of x are kept, as they depend on the configuration of x.
+// Templates keep the delimiters they were parsed with, so sources using different delimiters
+// can be parsed separately and then merged.
+func (x *Extemplate) Merge(other *Extemplate, overwrite bool) error {
+	other.mu.RLock()
+	files := make(map[string]*templatefile, len(other.files))
+	for name, tf := range other.files {
+		c := *tf
+		files[name] = &c
+	}
+	funcs := make(map[string]interface{}, len(other.funcs))
+	for k, v := range other.funcs {
+		funcs[k] = v
+	}
+	textExts := make(map[string]bool, len(other.textExts))
+	for k, v := range other.textExts {
+		textExts[k] = v
+	}
+	other.mu.RUnlock()
+
+	x.mu.RLock()
+	for name := range files {
+		if _, exists := x.files[name]; exists && !overwrite {
+			x.mu.RUnlock()
+			return fmt.Errorf("extemplate: merge: template %q exists in both sets", name)
+		}
+	}
+	bound := x.boundFuncs()
+	for k := range funcs {
+		if _, exists := x.funcs[k]; exists && (!overwrite || bound[k] != nil) {
+			delete(funcs, k)
+		}
+	}
+	x.mu.RUnlock()
+
+	x.Funcs(funcs)
+	for k, v := range textExts {
+		x.textExts[k] = v
+	}
+	return x.parseFiles(context.Background(), files)
+}
+
+// Clone returns a deep copy of x, including its configuration, funcs and templates,
+// so that funcs or templates can be added to the copy without affecting x.
+func (x *Extemplate) Clone() (*Extemplate, error) {
+	return x.clone(nil)
+}
+
+// Subset returns a copy of x like Clone, limited to the templates with names starting with prefix, e.g. "emails/".
+// Templates outside of prefix that are extended or invoked by templates in it are copied as well,
+// but can not be looked up or executed using the returned set.
+func (x *Extemplate) Subset(prefix string) (*Extemplate, error) {
+	prefix = x.normalize(prefix)
+	if !strings.HasPrefix(prefix, x.subset) {
+		prefix = x.subset
+	}
+
+	x.mu.RLock()
+	var names []string
+	for name := range x.files {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	keep := x.reachable(names)
+	x.mu.RUnlock()
+
+	c, err := x.clone(keep)
+	if err != nil {
+		return nil, err
+	}
+	c.subset = prefix
+	return c, nil
+}
+
+// clone returns a copy of x with the templates in keep, or all templates if keep is nil
+func (x *Extemplate) clone(keep map[string]bool) (*Extemplate, error) {
+	x.mu.RLock()
+	c := New()
+	c.Delims(x.leftDelim, x.rightDelim)
+	c.lazy = x.lazy
+	c.workers = x.workers
+	c.maxFileSize = x.maxFileSize
+	c.maxInlineSize = x.maxInlineSize
+	c.integrityManifest = x.integrityManifest
+	c.symlinks = x.symlinks
+	c.conflicts = x.conflicts
+	c.indexes = x.indexes
+	c.jsonFallback = x.jsonFallback
+	c.nameFunc = x.nameFunc
+	c.foldCase = x.foldCase
+	c.strictExts = x.strictExts
+	c.subset = x.subset
+	c.logFunc = x.logFunc
+	c.slowRender = x.slowRender
+	c.recoverPanics = x.recoverPanics
+	c.strictKeys = x.strictKeys
+	c.autoReload = x.autoReload
+	c.onReload = x.onReload
+	c.onReloadError = x.onReloadError
+	c.sandbox = x.sandbox
+	c.tracking = x.tracking
+	c.profile = x.profile
+	c.componentDir = x.componentDir
+	c.sections = x.sections
+	c.trimBlocks = x.trimBlocks
+	c.extendsPattern = x.extendsPattern
+	c.csrf = x.csrf
+	c.urlResolver = x.urlResolver
+	c.flashes = x.flashes
+	c.errorTemplate = x.errorTemplate
+	c.errorDetails = x.errorDetails
+	c.noCache = x.noCache
+	c.maxSnapshots = x.maxSnapshots
+	if lru, ok := x.responses.(*lruCache); ok {
+		WithResponseCache(lru.max, x.responseTTL)(c)
+	}
+	c.parseHooks = append(c.parseHooks, x.parseHooks...)
+	c.frontMatter = append(c.frontMatter, x.frontMatter...)
+	c.filters = append(c.filters, x.filters...)
+	for k, v := range x.textExts {
+		c.textExts[k] = v
+	}
+	for k, v := range x.directives {
+		c.directives[k] = v
+	}
+	for k, v := range x.dataFormats {
+		WithDataFormat(k, v)(c)
+	}
+	x.aliasMu.RLock()
+	for k, v := range x.aliases {
+		c.Alias(k, v)
+	}
+	x.aliasMu.RUnlock()
+	for k, v := range x.blockLoaders {
+		c.BlockData(k, v)
+	}
+
+	// the first context funcs and the bound funcs are the built-in ones, which are bound to c by New
+	c.Funcs(x.funcs)
+	c.Funcs(c.boundFuncs())
+	c.funcScopes = append(c.funcScopes, x.funcScopes...)
+	c.delimScopes = append(c.delimScopes, x.delimScopes...)
+	c.funcRestrictions = append(c.funcRestrictions, x.funcRestrictions...)
+	c.Funcs(c.ctxFuncs[0](context.Background()))
+	c.ctxFuncs = append(c.ctxFuncs, x.ctxFuncs[1:]...)
+
+	files := make(map[string]*templatefile, len(x.files))
+	for name, tf := range x.files {
+		if keep != nil && !keep[name] {
+			continue
+		}
+		cp := *tf
+		files[name] = &cp
+	}
+	x.mu.RUnlock()
+
+	if err := c.parseFiles(context.Background(), files); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
@@ -0,0 +1,136 @@
+// Copyright 2017 Danny van Kooten. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package extemplate
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+)
+
+type frontMatter struct {
+	delim     []byte
+	unmarshal func(data []byte, v interface{}) error
+}
+
+type templateNameKey struct{}
+
+// WithFrontMatter enables parsing a front-matter block at the top of template files (after any directives).
+// The block starts and ends with a line consisting of delim, and is decoded using unmarshal. For example:
+//
+//	extemplate.New(extemplate.WithFrontMatter("---", yaml.Unmarshal), extemplate.WithFrontMatter("+++", toml.Unmarshal))
+//
+// The decoded metadata is available through Meta and the meta template func: {{ meta "title" }}.
+func WithFrontMatter(delim string, unmarshal func(data []byte, v interface{}) error) Option {
+	return func(x *Extemplate) {
+		x.frontMatter = append(x.frontMatter, frontMatter{delim: []byte(delim), unmarshal: unmarshal})
+	}
+}
+
+// parseFrontMatter decodes a front-matter block at the start of c into f.Meta and returns the remaining contents
+func (x *Extemplate) parseFrontMatter(f *File, c []byte) ([]byte, error) {
+	for _, fm := range x.frontMatter {
+		line, rest := nextLine(c)
+		if !bytes.Equal(bytes.TrimSpace(line), fm.delim) {
+			continue
+		}
+
+		var block []byte
+		for len(rest) > 0 {
+			line, rest = nextLine(rest)
+			if bytes.Equal(bytes.TrimSpace(line), fm.delim) {
+				if err := fm.unmarshal(block, &f.Meta); err != nil {
+					return nil, fmt.Errorf("extemplate: %s: front matter: %s", f.Name, err)
+				}
+				return rest, nil
+			}
+			block = append(block, line...)
+		}
+
+		return nil, fmt.Errorf("extemplate: %s: unterminated front matter", f.Name)
+	}
+
+	return c, nil
+}
+
+// nextLine splits c after its first newline
+func nextLine(c []byte) ([]byte, []byte) {
+	if i := bytes.IndexByte(c, '\n'); i >= 0 {
+		return c[:i+1], c[i+1:]
+	}
+	return c, nil
+}
+
+// Meta returns the metadata of the template with the given name, including the metadata of its layouts.
+// Metadata of a template takes precedence over the metadata of the layout it extends.
+// It returns nil if there is no such template.
+func (x *Extemplate) Meta(name string) map[string]interface{} {
+	name = x.resolve(name)
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	tf, ok := x.files[name]
+	if !ok {
+		return nil
+	}
+
+	meta := make(map[string]interface{})
+	for i := 0; ok && i <= len(x.files); i++ {
+		for k, v := range tf.meta {
+			if _, exists := meta[k]; !exists {
+				meta[k] = v
+			}
+		}
+		tf, ok = x.files[tf.layout]
+	}
+	return meta
+}
+
+// templateName returns the name of the template being executed with ctx
+func templateName(ctx context.Context) string {
+	name, _ := ctx.Value(templateNameKey{}).(string)
+	return name
+}
+
+// executingKey is the context key for the set executing a template, which may be a copy of the set a func was registered on
+type executingKey struct{}
+
+// executing returns the set executing a template with ctx, or x if ctx does not belong to an execution
+func (x *Extemplate) executing(ctx context.Context) *Extemplate {
+	if ex, ok := ctx.Value(executingKey{}).(*Extemplate); ok {
+		return ex
+	}
+	return x
+}
+
+// RangeMeta calls fn for every parsed template with its metadata, in lexical order of template names.
+// If fn returns false, RangeMeta stops the iteration.
+func (x *Extemplate) RangeMeta(fn func(name string, meta map[string]interface{}) bool) {
+	x.mu.RLock()
+	names := make(map[string]bool, len(x.files))
+	for name := range x.files {
+		names[name] = true
+	}
+	x.mu.RUnlock()
+
+	for _, name := range sortedNames(names) {
+		if !fn(name, x.Meta(name)) {
+			return
+		}
+	}
+}
+
+// TemplatesWithMeta returns the names of all templates with the given metadata key set to value, in lexical order.
+// Values are compared by their string representation, so that the number 1 decoded from YAML or JSON matches 1.
+func (x *Extemplate) TemplatesWithMeta(key string, value interface{}) []string {
+	var names []string
+	x.RangeMeta(func(name string, meta map[string]interface{}) bool {
+		if v, ok := meta[key]; ok && fmt.Sprint(v) == fmt.Sprint(value) {
+			names = append(names, name)
+		}
+		return true
+	})
+	return names
+}
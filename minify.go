@@ -0,0 +1,121 @@
+// Copyright 2017 Danny van Kooten. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package extemplate
+
+import (
+	"bytes"
+	"io"
+)
+
+// rawElements are the elements whose contents are written as-is by the minifier
+var rawElements = map[string]bool{
+	"pre":      true,
+	"textarea": true,
+	"script":   true,
+	"style":    true,
+}
+
+// minifyWriter collapses runs of whitespace in HTML text to a single space or newline.
+// Tags and the contents of rawElements are left untouched.
+// HTML comments need no handling here, as html/template already strips them from template text.
+type minifyWriter struct {
+	w     io.Writer
+	out   []byte
+	space byte
+
+	inTag   bool
+	quote   byte
+	name    []byte
+	naming  bool
+	closing bool
+
+	raw   []byte
+	match int
+}
+
+func newMinifyWriter(w io.Writer) *minifyWriter {
+	return &minifyWriter{w: w}
+}
+
+func (m *minifyWriter) Write(p []byte) (int, error) {
+	m.out = m.out[:0]
+	for _, c := range p {
+		switch {
+		case m.raw != nil:
+			m.out = append(m.out, c)
+			m.matchRawEnd(c)
+		case m.inTag:
+			m.out = append(m.out, c)
+			m.readTag(c)
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			if m.space != '\n' {
+				m.space = ' '
+				if c == '\n' {
+					m.space = '\n'
+				}
+			}
+		default:
+			if m.space != 0 {
+				m.out = append(m.out, m.space)
+				m.space = 0
+			}
+			m.out = append(m.out, c)
+			if c == '<' {
+				m.inTag, m.naming, m.closing = true, true, false
+				m.name = m.name[:0]
+			}
+		}
+	}
+
+	if _, err := m.w.Write(m.out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// readTag keeps track of the name of the tag being written and of quoted attribute values
+func (m *minifyWriter) readTag(c byte) {
+	switch {
+	case m.quote != 0:
+		if c == m.quote {
+			m.quote = 0
+		}
+	case c == '"' || c == '\'':
+		m.quote = c
+		m.naming = false
+	case c == '>':
+		m.inTag = false
+		if name := bytes.ToLower(m.name); !m.closing && rawElements[string(name)] {
+			m.raw = append([]byte("</"), name...)
+			m.match = 0
+		}
+	case m.naming && c == '/' && len(m.name) == 0:
+		m.closing = true
+	case m.naming && (c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'):
+		m.name = append(m.name, c)
+	default:
+		m.naming = false
+	}
+}
+
+// matchRawEnd looks for the closing tag of the raw element we are in
+func (m *minifyWriter) matchRawEnd(c byte) {
+	if c >= 'A' && c <= 'Z' {
+		c += 'a' - 'A'
+	}
+
+	if c != m.raw[m.match] {
+		m.match = 0
+		if c != m.raw[0] {
+			return
+		}
+	}
+
+	m.match++
+	if m.match == len(m.raw) {
+		m.raw = nil
+		m.inTag, m.naming, m.closing = true, false, true
+	}
+}
@@ -0,0 +1,38 @@
+// Copyright 2017 Danny van Kooten. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package extemplate
+
+import (
+	"html/template"
+)
+
+// Must is a helper that wraps a call to a function returning (*Extemplate, error)
+// and panics if the error is non-nil. It is intended for use in variable initializations such as
+//
+//	var templates = extemplate.Must(load())
+func Must(x *Extemplate, err error) *Extemplate {
+	if err != nil {
+		panic(err)
+	}
+	return x
+}
+
+// MustParseDir is like ParseDir, but panics if the templates can not be parsed.
+// The return value is the Extemplate instance, so calls can be chained.
+func (x *Extemplate) MustParseDir(root string, extensions []string) *Extemplate {
+	if err := x.ParseDir(root, extensions); err != nil {
+		panic(err)
+	}
+	return x
+}
+
+// MustLookup is like Lookup, but panics if there is no template with the given name.
+func (x *Extemplate) MustLookup(name string) *template.Template {
+	t := x.Lookup(name)
+	if t == nil {
+		panic(notFound(name))
+	}
+	return t
+}
@@ -0,0 +1,107 @@
+// Copyright 2017 Danny van Kooten. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package extemplate
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// negotiatedFormats are the variants ExecuteNegotiated chooses from, in order of preference
+var negotiatedFormats = []struct {
+	suffix      string
+	contentType string
+	mediaTypes  []string
+}{
+	{"html", "text/html; charset=utf-8", []string{"text/html", "application/xhtml+xml"}},
+	{"txt", "text/plain; charset=utf-8", []string{"text/plain"}},
+	{"xml", "application/xml; charset=utf-8", []string{"application/xml", "text/xml"}},
+}
+
+// ExecuteNegotiated renders the variant of the named template that best matches the Accept header of r as response,
+// setting Content-Type accordingly. For name "page.tmpl", the variants are "page.html.tmpl" (text/html),
+// "page.txt.tmpl" (text/plain) and "page.xml.tmpl" (application/xml). Use WithTextExtensions to parse
+// the plain text variants using text/template, like WithTextExtensions(".txt.tmpl").
+// If none of the existing variants is acceptable, the first existing one in the order above is rendered;
+// if no variant exists, the named template itself is rendered. See WithJSONFallback for rendering data as JSON instead.
+// Like Render, the output is buffered, so that nothing is written to w if executing the template fails,
+// unless the error template is rendered in its place, which is written with status 500.
+func (x *Extemplate) ExecuteNegotiated(w http.ResponseWriter, r *http.Request, name string, data interface{}) error {
+	w.Header().Add("Vary", "Accept")
+
+	q := map[string]float64{"*/*": 1}
+	if accept := r.Header.Get("Accept"); accept != "" {
+		q = qualities(accept)
+	}
+	variant, contentType, weight := x.negotiate(name, q)
+	if x.jsonFallback && (mediaQuality(q, "application/json") > weight || variant == "" && !x.exists(name)) {
+		return writeJSON(w, data)
+	}
+	if variant == "" {
+		return x.render(r.Context(), w, http.StatusOK, name, data)
+	}
+
+	var buf bytes.Buffer
+	if err := x.ExecuteTemplateContext(r.Context(), &buf, variant, data); err != nil {
+		return x.writeFallback(w, &buf, err)
+	}
+	return writeBuffered(w, http.StatusOK, contentType, &buf)
+}
+
+// negotiate returns the name, content type and quality value of the existing variant of the named template
+// best matching the quality values q of an Accept header, or "" if no variant exists
+func (x *Extemplate) negotiate(name string, q map[string]float64) (variant string, contentType string, weight float64) {
+	bestQ := -1.0
+	for _, f := range negotiatedFormats {
+		n := suffixedName(name, f.suffix)
+		if !x.exists(n) {
+			continue
+		}
+
+		fq := 0.0
+		for _, mt := range f.mediaTypes {
+			if v := mediaQuality(q, mt); v > fq {
+				fq = v
+			}
+		}
+		if fq > bestQ {
+			variant, contentType, bestQ = n, f.contentType, fq
+		}
+	}
+	return variant, contentType, bestQ
+}
+
+// mediaQuality returns the quality value of the given media type in q, falling back to wildcards like "text/*"
+func mediaQuality(q map[string]float64, mediaType string) float64 {
+	if v, ok := q[mediaType]; ok {
+		return v
+	}
+	if i := strings.IndexByte(mediaType, '/'); i >= 0 {
+		if v, ok := q[mediaType[:i]+"/*"]; ok {
+			return v
+		}
+	}
+	return q["*/*"]
+}
+
+// WithJSONFallback makes ExecuteNegotiated respond with data encoded as JSON when the client prefers application/json
+// over all existing variants of the template, or when neither the template nor any of its variants exist.
+// This allows a single handler to serve both pages and API responses.
+func WithJSONFallback() Option {
+	return func(x *Extemplate) {
+		x.jsonFallback = true
+	}
+}
+
+// writeJSON writes data encoded as JSON as the response
+func writeJSON(w http.ResponseWriter, data interface{}) error {
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(data); err != nil {
+		return err
+	}
+	return writeBuffered(w, http.StatusOK, "application/json; charset=utf-8", &buf)
+}
@@ -0,0 +1,95 @@
+// Copyright 2017 Danny van Kooten. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package extemplate
+
+import (
+	"errors"
+)
+
+// Pagination describes the pages of a paginated list, as returned by the paginate template func.
+// Templates render it using a partial of their own, e.g.
+//
+//	{{ define "pagination" }}
+//	<nav>
+//		{{ if .HasPrev }}<a href="?page={{ .Prev }}">Previous</a>{{ end }}
+//		{{ range .Pages }}
+//			{{ if .Gap }}…{{ else if .Current }}<span>{{ .Number }}</span>{{ else }}<a href="?page={{ .Number }}">{{ .Number }}</a>{{ end }}
+//		{{ end }}
+//		{{ if .HasNext }}<a href="?page={{ .Next }}">Next</a>{{ end }}
+//	</nav>
+//	{{ end }}
+//
+//	{{ template "pagination" paginate .Total 20 .Page }}
+type Pagination struct {
+	// Current is the number of the current page, starting at 1
+	Current int
+	// Last is the number of the last page, which is 1 for an empty list
+	Last int
+	// Prev and Next are the numbers of the previous and next page, or 0 if there is none
+	Prev, Next int
+	// HasPrev and HasNext report whether there is a previous or next page
+	HasPrev, HasNext bool
+	// Offset is the index of the first item on the current page
+	Offset int
+	// Pages is the window of pages to link to: the first and last page and the pages around the current page,
+	// with gaps in between
+	Pages []Page
+}
+
+// Page is a page in the window of a Pagination
+type Page struct {
+	Number  int
+	Current bool
+	// Gap marks skipped pages, with Number 0
+	Gap bool
+}
+
+// paginate is the paginate template func, returning the pagination of total items in pages of size perPage.
+// The optional window is the number of pages to show on either side of the current page, 2 by default.
+// current is clamped to the existing pages.
+func paginate(total int, perPage int, current int, window ...int) (*Pagination, error) {
+	if perPage <= 0 {
+		return nil, errors.New("extemplate: paginate: page size must be positive")
+	}
+	w := 2
+	if len(window) > 0 {
+		w = window[0]
+	}
+
+	last := (total + perPage - 1) / perPage
+	if last < 1 {
+		last = 1
+	}
+	if current < 1 {
+		current = 1
+	} else if current > last {
+		current = last
+	}
+
+	p := &Pagination{
+		Current: current,
+		Last:    last,
+		HasPrev: current > 1,
+		HasNext: current < last,
+		Offset:  (current - 1) * perPage,
+	}
+	if p.HasPrev {
+		p.Prev = current - 1
+	}
+	if p.HasNext {
+		p.Next = current + 1
+	}
+
+	for n := 1; n <= last; n++ {
+		if n != 1 && n != last && (n < current-w || n > current+w) {
+			if len(p.Pages) > 0 && !p.Pages[len(p.Pages)-1].Gap {
+				p.Pages = append(p.Pages, Page{Gap: true})
+			}
+			continue
+		}
+		p.Pages = append(p.Pages, Page{Number: n, Current: n == current})
+	}
+	return p, nil
+}
@@ -0,0 +1,41 @@
+// Copyright 2017 Danny van Kooten. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package extemplate
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+// PanicError is returned when executing a template panics, see WithPanicRecovery
+type PanicError struct {
+	// Name is the name of the executed template
+	Name string
+	// Value is the value passed to panic
+	Value interface{}
+	// Stack is the stack trace of the goroutine at the time of the panic
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("extemplate: panic executing %q: %v", e.Name, e.Value)
+}
+
+// WithPanicRecovery recovers from panics while executing templates, returning a *PanicError instead.
+// Panics in funcs called by templates are already returned as errors by text/template,
+// this also covers panics in output filters, writers and the methods they call.
+// Output written before the panic is not undone, unless execution is buffered, like when an error template is set.
+func WithPanicRecovery() Option {
+	return func(x *Extemplate) {
+		x.recoverPanics = true
+	}
+}
+
+// recoverPanic recovers a panic while executing the named template into err
+func recoverPanic(name string, err *error) {
+	if r := recover(); r != nil {
+		*err = &PanicError{Name: name, Value: r, Stack: debug.Stack()}
+	}
+}
@@ -0,0 +1,201 @@
+// Copyright 2017 Danny van Kooten. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package extemplate
+
+import (
+	"bytes"
+	"context"
+	"html/template"
+	"io"
+	"sync"
+	texttemplate "text/template"
+)
+
+// BlockLoader loads the data a block is rendered with, given the data of the page it is rendered in
+type BlockLoader func(ctx context.Context, data interface{}) (interface{}, error)
+
+// BlockData registers a loader for the data the named block is rendered with, given the data of the page.
+// It is used by the async and blockData template funcs, so that partials can load their own data, e.g.
+//
+//	{{ template "sidebar" blockData "sidebar" . }}
+//
+// It must be called before templates are executed.
+// The return value is the Extemplate instance, so calls can be chained.
+func (x *Extemplate) BlockData(name string, fn BlockLoader) *Extemplate {
+	if x.blockLoaders == nil {
+		x.blockLoaders = make(map[string]BlockLoader)
+	}
+	x.blockLoaders[name] = fn
+	return x
+}
+
+// blockDataFunc returns the blockData template func, which returns the data loaded for the named block.
+// If no loader is registered for the block, data is returned as-is.
+func (x *Extemplate) blockDataFunc(ctx context.Context) func(block string, data interface{}) (interface{}, error) {
+	return func(block string, data interface{}) (interface{}, error) {
+		if fn, ok := x.blockLoaders[block]; ok {
+			return fn(ctx, data)
+		}
+		return data, nil
+	}
+}
+
+// parallelKey is the context key for the block loaders of an ExecuteTemplateParallel call
+type parallelKey struct{}
+
+// stitcherKey is the context key for the stitcher of the current execution, if it renders blocks concurrently
+type stitcherKey struct{}
+
+// ExecuteTemplateParallel is like ExecuteTemplateContext, but blocks rendered using the async template func
+// are rendered concurrently, e.g.
+//
+//	<main>{{ async "stats" . }}</main><aside>{{ async "sidebar" . }}</aside>
+//
+// If loaders has an entry for a block, it is called concurrently with other blocks to load the data the block is rendered with.
+// Otherwise, the loader registered using BlockData is used, if any.
+// Output is buffered until all blocks are rendered, and nothing is written to wr if any of them fails.
+// Outside of ExecuteTemplateParallel, the async func renders blocks synchronously.
+func (x *Extemplate) ExecuteTemplateParallel(ctx context.Context, wr io.Writer, name string, data interface{}, loaders map[string]BlockLoader) error {
+	if loaders == nil {
+		loaders = make(map[string]BlockLoader)
+	}
+	return x.ExecuteTemplateContext(context.WithValue(ctx, parallelKey{}, loaders), wr, name, data)
+}
+
+// stitcher collects the output of a template and of the blocks it renders concurrently, in order
+type stitcher struct {
+	loaders map[string]BlockLoader
+	parts   []*blockResult
+	cur     bytes.Buffer
+	wg      sync.WaitGroup
+	// sections holds the sections of the execution, if enabled, to add the content pushed by blocks to
+	sections *sectionState
+}
+
+type blockResult struct {
+	out      []byte
+	sections *sectionState
+	err      error
+}
+
+func (s *stitcher) Write(p []byte) (int, error) {
+	return s.cur.Write(p)
+}
+
+// reserve ends the current part of the output and returns the part to render a block into
+func (s *stitcher) reserve() *blockResult {
+	s.parts = append(s.parts, &blockResult{out: append([]byte(nil), s.cur.Bytes()...)})
+	s.cur.Reset()
+	r := &blockResult{}
+	s.parts = append(s.parts, r)
+	return r
+}
+
+// writeTo waits for all blocks to be rendered, then writes the output in order
+func (s *stitcher) writeTo(w io.Writer) error {
+	s.wg.Wait()
+	for _, p := range s.parts {
+		if p.err != nil {
+			return p.err
+		}
+		if s.sections != nil && p.sections != nil {
+			s.sections.merge(p.sections)
+		}
+	}
+
+	for _, p := range s.parts {
+		if _, err := w.Write(p.out); err != nil {
+			return err
+		}
+	}
+	_, err := s.cur.WriteTo(w)
+	return err
+}
+
+// asyncFunc returns the async template func, which renders the named block of the executing template with data.
+// When executed using ExecuteTemplateParallel, the block is rendered concurrently.
+func (x *Extemplate) asyncFunc(ctx context.Context) func(block string, data interface{}) (template.HTML, error) {
+	return func(block string, data interface{}) (template.HTML, error) {
+		// the block tracks its own sandbox nesting depth, copied before the template continues to update it
+		ctx := ctx
+		if state, ok := ctx.Value(sandboxKey{}).(*sandboxState); ok {
+			ctx = context.WithValue(ctx, sandboxKey{}, state.fork())
+		}
+		st, _ := ctx.Value(stitcherKey{}).(*stitcher)
+		if st == nil {
+			r := x.renderBlock(ctx, block, data, x.blockLoaders[block])
+			if parent, _ := ctx.Value(sectionsKey{}).(*sectionState); parent != nil && r.sections != nil {
+				parent.merge(r.sections)
+			}
+			return template.HTML(r.out), r.err
+		}
+
+		loader, ok := st.loaders[block]
+		if !ok {
+			loader = x.blockLoaders[block]
+		}
+
+		r := st.reserve()
+		st.wg.Add(1)
+		go func() {
+			defer st.wg.Done()
+			*r = *x.renderBlock(ctx, block, data, loader)
+		}()
+		return "", nil
+	}
+}
+
+// renderBlock renders the named block of the executing template using a copy from its pool.
+// The block gets its own components and sections, so that it can be rendered concurrently with the template;
+// the content it pushes into sections is returned to be added to those of the template.
+func (x *Extemplate) renderBlock(ctx context.Context, block string, data interface{}, loader BlockLoader) *blockResult {
+	// blocks do not render their own blocks concurrently
+	ctx = context.WithValue(ctx, stitcherKey{}, (*stitcher)(nil))
+	r := &blockResult{}
+	var buf bytes.Buffer
+	var out io.Writer = &buf
+	if x.componentDir != "" {
+		out = &componentWriter{w: out}
+		ctx = context.WithValue(ctx, componentWriterKey{}, out)
+	}
+	if x.sections {
+		r.sections = &sectionState{sections: make(map[string]*bytes.Buffer)}
+		ctx = context.WithValue(ctx, sectionsKey{}, r.sections)
+		out = &sectionWriter{w: out, state: r.sections}
+	}
+
+	if loader != nil {
+		if data, r.err = loader(ctx, data); r.err != nil {
+			return r
+		}
+	}
+
+	pool, err := x.pool(templateName(ctx))
+	if err != nil {
+		r.err = err
+		return r
+	}
+	v := pool.Get()
+	if err, ok := v.(error); ok {
+		r.err = err
+		return r
+	}
+	defer pool.Put(v)
+
+	switch t := v.(type) {
+	case *template.Template:
+		for _, fn := range x.ctxFuncs {
+			t.Funcs(fn(ctx))
+		}
+		r.err = t.ExecuteTemplate(out, block, data)
+	case *texttemplate.Template:
+		for _, fn := range x.ctxFuncs {
+			t.Funcs(texttemplate.FuncMap(fn(ctx)))
+		}
+		r.err = t.ExecuteTemplate(out, block, data)
+	}
+	r.out = buf.Bytes()
+	return r
+}
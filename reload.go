@@ -0,0 +1,247 @@
+// Copyright 2017 Danny van Kooten. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package extemplate
+
+import (
+	"context"
+	"errors"
+	"html/template"
+	"io/fs"
+	"sync"
+	texttemplate "text/template"
+	"text/template/parse"
+)
+
+// OnReload registers fn to be called with the paths of the template files that were reloaded
+// after templates were reloaded automatically, see WithEnvironment and WatchLoader.
+// The return value is the Extemplate instance, so calls can be chained.
+func (x *Extemplate) OnReload(fn func(paths []string)) *Extemplate {
+	x.onReload = fn
+	return x
+}
+
+// OnReloadError registers fn to be called when reloading templates automatically fails, see WithEnvironment and WatchLoader.
+// The previous versions of the templates keep being used, so that a typo while editing a template does not break rendering.
+// The return value is the Extemplate instance, so calls can be chained.
+func (x *Extemplate) OnReloadError(fn func(err error)) *Extemplate {
+	x.onReloadError = fn
+	return x
+}
+
+// reloaded calls the callbacks registered using OnReload or OnReloadError for the result of an automatic reload
+func (x *Extemplate) reloaded(paths []string, err error) {
+	if err != nil {
+		x.log(levelWarn, "extemplate: failed to reload templates, keeping previous versions", "error", err)
+		if x.onReloadError != nil {
+			x.onReloadError(err)
+		}
+		return
+	}
+	if x.onReload != nil && len(paths) > 0 {
+		x.onReload(paths)
+	}
+}
+
+// ReloadFile re-reads the file at path, relative to the directory or file system templates were last parsed from.
+// Only the file itself and templates that (transitively) extend it or invoke a template defined in it are recompiled.
+// If the file no longer exists, its template is removed from the set.
+// If the file fails to parse, the set is left as it was and the error is returned.
+func (x *Extemplate) ReloadFile(path string) error {
+	x.mu.RLock()
+	fsys := x.fsys
+	x.mu.RUnlock()
+	if fsys == nil {
+		return errors.New("extemplate: ReloadFile called before templates were parsed")
+	}
+	x.forgetAssets(path)
+	x.log(levelInfo, "extemplate: reloading template", "path", path)
+
+	tf, err := x.loadFile(fsys, path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return x.Remove(x.nameOf(path))
+	}
+	if err != nil {
+		return err
+	}
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	return x.parseFilesOrRestoreLocked(context.Background(), map[string]*templatefile{x.nameOf(path): tf})
+}
+
+// parseFilesOrRestoreLocked is like parseFilesLocked, but restores the previous versions of the files if parsing fails,
+// so that the set is left as it was. The caller must hold x.mu for writing.
+func (x *Extemplate) parseFilesOrRestoreLocked(ctx context.Context, files map[string]*templatefile) error {
+	old := make(map[string]*templatefile, len(files))
+	for name := range files {
+		old[name] = x.files[name]
+	}
+
+	err := x.parseFilesLocked(ctx, files)
+	if err == nil {
+		return nil
+	}
+	if rerr := x.restoreLocked(old); rerr != nil {
+		return rerr
+	}
+	return err
+}
+
+// restoreLocked restores the given previous versions of files, removing files without a previous version.
+// Templates can not be removed from a template namespace, so the set is rebuilt from scratch.
+// The caller must hold x.mu for writing.
+func (x *Extemplate) restoreLocked(old map[string]*templatefile) error {
+	for name, tf := range old {
+		if tf == nil {
+			delete(x.files, name)
+		} else {
+			x.files[name] = tf
+		}
+	}
+	x.shared = template.New("").Delims(x.leftDelim, x.rightDelim).Funcs(x.funcs)
+	x.text = texttemplate.New("").Delims(x.leftDelim, x.rightDelim).Funcs(texttemplate.FuncMap(x.funcs))
+	x.templates = make(map[string]*template.Template)
+	x.texts = make(map[string]*texttemplate.Template)
+	x.pools = make(map[string]*sync.Pool)
+	previous := x.files
+	x.files = make(map[string]*templatefile, len(previous))
+	return x.parseFilesLocked(context.Background(), previous)
+}
+
+// Affected returns the names of all templates whose output may change when the templates with the given names change,
+// including the templates themselves: templates extending them, and templates invoking a template they define.
+// Names of templates that were not parsed are ignored. This can be used to only re-render pages affected by a change.
+func (x *Extemplate) Affected(names ...string) []string {
+	resolved := make([]string, len(names))
+	for i, name := range names {
+		resolved[i] = x.resolve(name)
+	}
+
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	changed := make(map[string]bool, len(names))
+	for _, name := range resolved {
+		if _, ok := x.files[name]; ok {
+			changed[name] = true
+		}
+	}
+	return sortedNames(x.affected(changed))
+}
+
+// affected returns the names of all templates that need to be recompiled after the files with the given names changed.
+// The caller must hold x.mu.
+func (x *Extemplate) affected(changed map[string]bool) map[string]bool {
+	// collect the template names defined in changed shared files,
+	// then keep adding the definitions of shared files invoking any of them until nothing changes
+	dirty := make(map[string]bool)
+	sharedDirty := make(map[string]bool)
+	for name := range changed {
+		if tf := x.files[name]; tf.layout == "" {
+			sharedDirty[name] = true
+			for _, d := range tf.defines {
+				dirty[d] = true
+			}
+		}
+	}
+	for grown := len(sharedDirty) > 0; grown; {
+		grown = false
+		for name, tf := range x.files {
+			if tf.layout != "" || sharedDirty[name] || !usesAny(tf, dirty) {
+				continue
+			}
+
+			sharedDirty[name] = true
+			for _, d := range tf.defines {
+				dirty[d] = true
+			}
+			grown = true
+		}
+	}
+
+	recompile := make(map[string]bool)
+	for name := range x.files {
+		if x.dependsOn(name, changed) || x.dependsOn(name, sharedDirty) || x.chainUses(name, dirty) {
+			recompile[name] = true
+		}
+	}
+	return recompile
+}
+
+// chainUses reports whether any file in the layout chain of the named template invokes a template in names.
+// The caller must hold x.mu.
+func (x *Extemplate) chainUses(name string, names map[string]bool) bool {
+	for i := 0; i <= len(x.files); i++ {
+		tf, ok := x.files[name]
+		if !ok {
+			return false
+		}
+		if usesAny(tf, names) {
+			return true
+		}
+		if tf.layout == "" {
+			return false
+		}
+		name = tf.layout
+	}
+
+	// layout chain contains a cycle
+	return false
+}
+
+func usesAny(tf *templatefile, names map[string]bool) bool {
+	if len(names) == 0 {
+		return false
+	}
+	for _, u := range tf.uses {
+		if names[u] {
+			return true
+		}
+	}
+	return false
+}
+
+// references returns the names of the templates defined in c and the names of the templates invoked by it.
+// If c fails to parse, the template itself is returned as its only definition; the error surfaces when compiling.
+func references(name string, c []byte, left, right string) (defines []string, uses []string) {
+	t := parse.New(name)
+	t.Mode = parse.SkipFuncCheck
+	trees := make(map[string]*parse.Tree)
+	if _, err := t.Parse(string(c), left, right, trees); err != nil {
+		return []string{name}, nil
+	}
+
+	defines = []string{name}
+	for n, tree := range trees {
+		if n != name {
+			defines = append(defines, n)
+		}
+		if tree.Root != nil {
+			uses = appendInvoked(uses, tree.Root)
+		}
+	}
+	return defines, uses
+}
+
+// appendInvoked appends the names of all templates invoked in the given node and its children to names
+func appendInvoked(names []string, node parse.Node) []string {
+	switch n := node.(type) {
+	case *parse.TemplateNode:
+		names = append(names, n.Name)
+	case *parse.ListNode:
+		if n == nil {
+			return names
+		}
+		for _, c := range n.Nodes {
+			names = appendInvoked(names, c)
+		}
+	case *parse.IfNode:
+		names = appendInvoked(appendInvoked(names, n.List), n.ElseList)
+	case *parse.RangeNode:
+		names = appendInvoked(appendInvoked(names, n.List), n.ElseList)
+	case *parse.WithNode:
+		names = appendInvoked(appendInvoked(names, n.List), n.ElseList)
+	}
+	return names
+}
@@ -0,0 +1,389 @@
+// Copyright 2017 Danny van Kooten. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package extemplate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template/parse"
+	"unicode/utf8"
+)
+
+// Names of the funcs called by sandbox probes. Like coverage probes, these are only ever inserted into parse trees.
+const (
+	sandboxRangeFunc = "extemplateRange"
+	sandboxEnterFunc = "extemplateEnter"
+	sandboxLeaveFunc = "extemplateLeave"
+)
+
+// ErrLimitExceeded is returned, wrapped, when a sandboxed template exceeds one of the limits of its Sandbox
+var ErrLimitExceeded = errors.New("extemplate: sandbox limit exceeded")
+
+// builtinFuncs are the funcs predefined by text/template, which sandboxed templates may always call
+var builtinFuncs = map[string]bool{
+	"and": true, "call": true, "html": true, "index": true, "slice": true, "js": true, "len": true, "not": true,
+	"or": true, "print": true, "printf": true, "println": true, "urlquery": true,
+	"eq": true, "ge": true, "gt": true, "le": true, "lt": true, "ne": true,
+}
+
+// Sandbox limits the resources templates may use, for executing templates written by untrusted users.
+// A zero value for any of the limits means that it is not enforced.
+type Sandbox struct {
+	// MaxOutput is the maximum number of bytes a single execution may write
+	MaxOutput int64
+	// MaxRangeIterations is the maximum number of range iterations of a single execution, over all range actions
+	MaxRangeIterations int
+	// MaxDepth is the maximum nesting depth of template invocations
+	MaxDepth int
+	// Funcs lists the registered funcs templates may call, in addition to the funcs predefined by text/template.
+	// If nil, all registered funcs may be called. Calling any other func is reported as an error when parsing.
+	// See RestrictFuncs to restrict the funcs of some templates only.
+	Funcs []string
+}
+
+// maxPrintfWidth is the largest width or precision sandboxed templates may pass to printf,
+// as formatting allocates the padded value at once, regardless of MaxOutput
+const maxPrintfWidth = 1024
+
+// sandboxState holds the resources used by an execution, shared with the executions it starts
+type sandboxState struct {
+	*sandboxCounters
+	// depth is the nesting depth of the goroutine executing templates, see fork
+	depth int
+}
+
+// sandboxCounters are the resources counted over all goroutines of an execution, see async
+type sandboxCounters struct {
+	mu         sync.Mutex
+	iterations int
+	// output is the number of bytes written by the execution
+	output int64
+}
+
+// fork returns the state for executing a block in a new goroutine, which shares the counters of s
+// but tracks its own nesting depth, starting at that of s
+func (s *sandboxState) fork() *sandboxState {
+	return &sandboxState{sandboxCounters: s.sandboxCounters, depth: s.depth}
+}
+
+type sandboxKey struct{}
+
+// WithSandbox enforces the limits of s on all templates.
+// Templates are instrumented to count range iterations and template invocations, which slows down execution somewhat.
+// The print, printf and println funcs return an error for results larger than MaxOutput,
+// and printf for widths or precisions larger than 1024.
+func WithSandbox(s Sandbox) Option {
+	return func(x *Extemplate) {
+		x.sandbox = &s
+		x.ContextFuncs(func(ctx context.Context) template.FuncMap {
+			state, _ := ctx.Value(sandboxKey{}).(*sandboxState)
+			return template.FuncMap{
+				sandboxRangeFunc: func() (bool, error) {
+					if state == nil {
+						return false, nil
+					}
+					state.mu.Lock()
+					defer state.mu.Unlock()
+					state.iterations++
+					if s.MaxRangeIterations > 0 && state.iterations > s.MaxRangeIterations {
+						return false, fmt.Errorf("%w: more than %d range iterations", ErrLimitExceeded, s.MaxRangeIterations)
+					}
+					return false, nil
+				},
+				sandboxEnterFunc: func() (bool, error) {
+					if state == nil {
+						return false, nil
+					}
+					state.depth++
+					if s.MaxDepth > 0 && state.depth > s.MaxDepth {
+						return false, fmt.Errorf("%w: template invocations nested more than %d deep", ErrLimitExceeded, s.MaxDepth)
+					}
+					return false, nil
+				},
+				sandboxLeaveFunc: func() bool {
+					if state != nil {
+						state.depth--
+					}
+					return false
+				},
+			}
+		})
+		x.Funcs(template.FuncMap{
+			"print": func(args ...interface{}) (string, error) {
+				return s.limit(fmt.Sprint(args...))
+			},
+			"println": func(args ...interface{}) (string, error) {
+				return s.limit(fmt.Sprintln(args...))
+			},
+			"printf": func(format string, args ...interface{}) (string, error) {
+				out, err := sandboxPrintf(format, args...)
+				if err != nil {
+					return "", err
+				}
+				return s.limit(out)
+			},
+		})
+	}
+}
+
+// sandboxed returns ctx with the sandbox state of a new execution, unless it is part of one already,
+// and wr limited to the output left of the maximum output size.
+// Only the output of the outermost execution counts towards the maximum, as executions it starts,
+// such as includes, write to buffers that end up in its output.
+func (s *Sandbox) sandboxed(ctx context.Context, wr io.Writer) (context.Context, io.Writer) {
+	state, ok := ctx.Value(sandboxKey{}).(*sandboxState)
+	if !ok {
+		state = &sandboxState{sandboxCounters: &sandboxCounters{}}
+		ctx = context.WithValue(ctx, sandboxKey{}, state)
+	}
+	if s.MaxOutput > 0 {
+		wr = &limitWriter{w: wr, state: state, max: s.MaxOutput, outer: !ok}
+	}
+	return ctx, wr
+}
+
+// limitWriter returns an error once the output of an execution exceeds max bytes
+type limitWriter struct {
+	w     io.Writer
+	state *sandboxState
+	max   int64
+	// outer reports whether the writer counts the output of the execution, see sandboxed
+	outer bool
+	// n is the number of bytes written to a writer that is not outer
+	n int64
+}
+
+func (lw *limitWriter) Write(p []byte) (int, error) {
+	lw.state.mu.Lock()
+	n := &lw.n
+	if lw.outer {
+		n = &lw.state.output
+	}
+	left := lw.max - lw.state.output
+	if lw.outer {
+		left = lw.max
+	}
+	if *n+int64(len(p)) > left {
+		lw.state.mu.Unlock()
+		return 0, fmt.Errorf("%w: more than %d bytes of output", ErrLimitExceeded, lw.max)
+	}
+	*n += int64(len(p))
+	lw.state.mu.Unlock()
+	return lw.w.Write(p)
+}
+
+// limit returns an error if str is longer than the maximum output size, as it can not be written anyway.
+// Strings built in template variables are never written, so the print funcs check their results to bound memory use.
+func (s *Sandbox) limit(str string) (string, error) {
+	if s.MaxOutput > 0 && int64(len(str)) > s.MaxOutput {
+		return "", fmt.Errorf("%w: more than %d bytes of output", ErrLimitExceeded, s.MaxOutput)
+	}
+	return str, nil
+}
+
+// sandboxPrintf is fmt.Sprintf, returning an error for widths or precisions larger than maxPrintfWidth
+func sandboxPrintf(format string, args ...interface{}) (string, error) {
+	arg := 0
+	for i := 0; i < len(format); {
+		if format[i] != '%' {
+			i++
+			continue
+		}
+		i++
+		for i < len(format) && strings.IndexByte("+-# 0", format[i]) >= 0 {
+			i++
+		}
+
+		// width, precision and their argument indexes, e.g. %[2]*[1]d or %6.2f
+		for part := 0; part < 2 && i < len(format); part++ {
+			if part == 1 {
+				if format[i] != '.' {
+					break
+				}
+				i++
+			}
+			i, arg = printfIndex(format, i, arg)
+			if i < len(format) && format[i] == '*' {
+				i++
+				if arg < len(args) && tooWide(args[arg]) {
+					return "", fmt.Errorf("%w: printf width or precision larger than %d", ErrLimitExceeded, maxPrintfWidth)
+				}
+				arg++
+				continue
+			}
+			start := i
+			for i < len(format) && format[i] >= '0' && format[i] <= '9' {
+				i++
+			}
+			if n, err := strconv.Atoi(format[start:i]); i > start && (err != nil || n > maxPrintfWidth) {
+				return "", fmt.Errorf("%w: printf width or precision larger than %d", ErrLimitExceeded, maxPrintfWidth)
+			}
+		}
+
+		i, arg = printfIndex(format, i, arg)
+		if i < len(format) {
+			if format[i] != '%' {
+				arg++
+			}
+			_, size := utf8.DecodeRuneInString(format[i:])
+			i += size
+		}
+	}
+	return fmt.Sprintf(format, args...), nil
+}
+
+// printfIndex skips an argument index like [2] at format[i:], returning the new position and the index of the next argument
+func printfIndex(format string, i int, arg int) (int, int) {
+	if i >= len(format) || format[i] != '[' {
+		return i, arg
+	}
+	end := strings.IndexByte(format[i:], ']')
+	if end < 0 {
+		return i, arg
+	}
+	if n, err := strconv.Atoi(format[i+1 : i+end]); err == nil && n > 0 {
+		arg = n - 1
+	}
+	return i + end + 1, arg
+}
+
+// tooWide reports whether v is an integer argument used as width or precision larger than maxPrintfWidth
+func tooWide(v interface{}) bool {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int() > maxPrintfWidth || rv.Int() < -maxPrintfWidth
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return rv.Uint() > maxPrintfWidth
+	}
+	return false
+}
+
+// checkFuncs returns an error if the file with the given name calls a func that is not in allowed,
+// or predefined by text/template
+func checkFuncs(name string, tf *templatefile, allowed []string) error {
+	allow := make(map[string]bool, len(allowed))
+	for _, f := range allowed {
+		allow[f] = true
+	}
+
+	t := parse.New(name)
+	t.Mode = parse.SkipFuncCheck
+	trees := make(map[string]*parse.Tree)
+	if _, err := t.Parse(string(tf.contents), tf.leftDelim, tf.rightDelim, trees); err != nil {
+		// the error surfaces when parsing the file into the set
+		return nil
+	}
+	for _, tree := range trees {
+		for _, f := range appendCalled(nil, tree.Root) {
+			if !allow[f] && !builtinFuncs[f] {
+				return fmt.Errorf("extemplate: %s: function %q is not allowed", name, f)
+			}
+		}
+	}
+	return nil
+}
+
+// appendCalled appends the names of all funcs called in the given node and its children to names
+func appendCalled(names []string, node parse.Node) []string {
+	switch n := node.(type) {
+	case *parse.IdentifierNode:
+		names = append(names, n.Ident)
+	case *parse.ListNode:
+		if n == nil {
+			return names
+		}
+		for _, c := range n.Nodes {
+			names = appendCalled(names, c)
+		}
+	case *parse.ActionNode:
+		names = appendCalled(names, n.Pipe)
+	case *parse.TemplateNode:
+		names = appendCalled(names, n.Pipe)
+	case *parse.PipeNode:
+		if n == nil {
+			return names
+		}
+		for _, c := range n.Cmds {
+			names = appendCalled(names, c)
+		}
+	case *parse.CommandNode:
+		for _, a := range n.Args {
+			names = appendCalled(names, a)
+		}
+	case *parse.ChainNode:
+		names = appendCalled(names, n.Node)
+	case *parse.IfNode:
+		names = appendCalled(appendCalled(appendCalled(names, n.Pipe), n.List), n.ElseList)
+	case *parse.RangeNode:
+		names = appendCalled(appendCalled(appendCalled(names, n.Pipe), n.List), n.ElseList)
+	case *parse.WithNode:
+		names = appendCalled(appendCalled(appendCalled(names, n.Pipe), n.List), n.ElseList)
+	}
+	return names
+}
+
+// instrument adds probes to all trees that were not instrumented yet:
+// the body of every range action counts an iteration, and the body of every template tracks the nesting depth
+func (s *Sandbox) instrument(trees []*parse.Tree) {
+	for _, tree := range trees {
+		if tree == nil || tree.Root == nil || isSandboxed(tree.Root) {
+			continue
+		}
+		instrumentRanges(tree.Root)
+		tree.Root.Nodes = append([]parse.Node{newSandboxProbe(sandboxEnterFunc)}, tree.Root.Nodes...)
+		tree.Root.Nodes = append(tree.Root.Nodes, newSandboxProbe(sandboxLeaveFunc))
+	}
+}
+
+func instrumentRanges(list *parse.ListNode) {
+	if list == nil {
+		return
+	}
+	for _, n := range list.Nodes {
+		switch n := n.(type) {
+		case *parse.IfNode:
+			instrumentRanges(n.List)
+			instrumentRanges(n.ElseList)
+		case *parse.RangeNode:
+			instrumentRanges(n.List)
+			instrumentRanges(n.ElseList)
+			n.List.Nodes = append([]parse.Node{newSandboxProbe(sandboxRangeFunc)}, n.List.Nodes...)
+		case *parse.WithNode:
+			instrumentRanges(n.List)
+			instrumentRanges(n.ElseList)
+		}
+	}
+}
+
+// newSandboxProbe returns {{ if fn }}{{ end }}, see newProbe
+func newSandboxProbe(fn string) parse.Node {
+	t := parse.New("sandbox")
+	t.Mode = parse.SkipFuncCheck
+	if _, err := t.Parse("{{ if "+fn+" }}{{ end }}", "{{", "}}", make(map[string]*parse.Tree)); err != nil {
+		panic(err)
+	}
+	return t.Root.Nodes[0]
+}
+
+// isSandboxed reports whether list ends with the probe added by instrument
+func isSandboxed(list *parse.ListNode) bool {
+	if len(list.Nodes) == 0 {
+		return false
+	}
+	n, ok := list.Nodes[len(list.Nodes)-1].(*parse.IfNode)
+	if !ok || len(n.Pipe.Cmds) != 1 || len(n.Pipe.Cmds[0].Args) != 1 {
+		return false
+	}
+	id, ok := n.Pipe.Cmds[0].Args[0].(*parse.IdentifierNode)
+	return ok && id.Ident == sandboxLeaveFunc
+}
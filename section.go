@@ -0,0 +1,194 @@
+// Copyright 2017 Danny van Kooten. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package extemplate
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"html/template"
+	"io"
+	"strings"
+)
+
+// Names of the funcs that section actions are rewritten to. They are only ever inserted by rewriteSections.
+const (
+	sectionStartFunc = "extemplateSectionStart"
+	sectionEndFunc   = "extemplateSectionEnd"
+)
+
+// yieldMarker starts the placeholder written by the yield func, which is followed by the section name and a NUL byte
+const yieldMarker = "\x00extemplate:yield:"
+
+type sectionsKey struct{}
+
+// WithSections enables sections: content that templates push into a named section from anywhere,
+// to be emitted wherever the layout yields the section, before or after the content was pushed:
+//
+//	<head>{{ yield "scripts" }}</head>
+//
+//	{{ define "content" }}
+//		{{ section "scripts" }}<script src="/chart.js"></script>{{ end }}
+//	{{ end }}
+//
+// Content pushed into the same section multiple times is emitted in order.
+// Sections are shared with the templates included or rendered as component during the same execution.
+// Content pushed by blocks rendered using async is added after the content pushed by the rest of the template.
+// The output of a template is buffered until it is executed completely, in order to emit sections yielded before they are pushed.
+func WithSections() Option {
+	return func(x *Extemplate) {
+		x.sections = true
+		x.ContextFuncs(func(ctx context.Context) template.FuncMap {
+			state, _ := ctx.Value(sectionsKey{}).(*sectionState)
+			return template.FuncMap{
+				sectionStartFunc: func(name string) (string, error) {
+					if state == nil {
+						return "", errors.New("extemplate: section used outside of template execution")
+					}
+					buf, ok := state.sections[name]
+					if !ok {
+						buf = &bytes.Buffer{}
+						state.sections[name] = buf
+					}
+					state.capture = append(state.capture, buf)
+					return "", nil
+				},
+				sectionEndFunc: func() string {
+					state.capture = state.capture[:len(state.capture)-1]
+					return ""
+				},
+				"yield": func(name string) template.HTML {
+					return template.HTML(yieldMarker + name + "\x00")
+				},
+			}
+		})
+	}
+}
+
+// sectionState holds the sections of an execution, shared with the executions it starts
+type sectionState struct {
+	sections map[string]*bytes.Buffer
+	// capture holds the sections currently being pushed, innermost last
+	capture []*bytes.Buffer
+}
+
+// merge appends the content pushed into the sections of other to the sections of s
+func (s *sectionState) merge(other *sectionState) {
+	for name, buf := range other.sections {
+		if _, ok := s.sections[name]; !ok {
+			s.sections[name] = &bytes.Buffer{}
+		}
+		s.sections[name].Write(buf.Bytes())
+	}
+}
+
+// sectionWriter writes to the section being pushed, if any, or to w
+type sectionWriter struct {
+	w     io.Writer
+	state *sectionState
+}
+
+func (sw *sectionWriter) Write(p []byte) (int, error) {
+	if n := len(sw.state.capture); n > 0 {
+		return sw.state.capture[n-1].Write(p)
+	}
+	return sw.w.Write(p)
+}
+
+// yieldWriter buffers the output of an execution, to replace the placeholders of yielded sections once it is complete
+type yieldWriter struct {
+	w     io.Writer
+	state *sectionState
+	buf   bytes.Buffer
+}
+
+func (yw *yieldWriter) Write(p []byte) (int, error) {
+	return yw.buf.Write(p)
+}
+
+// flush writes the buffered output to w, replacing the placeholders of yielded sections with their content
+func (yw *yieldWriter) flush() error {
+	b := yw.buf.Bytes()
+	for {
+		i := bytes.Index(b, []byte(yieldMarker))
+		if i < 0 {
+			break
+		}
+		end := bytes.IndexByte(b[i+len(yieldMarker):], 0)
+		if end < 0 {
+			break
+		}
+		end += i + len(yieldMarker)
+
+		if _, err := yw.w.Write(b[:i]); err != nil {
+			return err
+		}
+		if s, ok := yw.state.sections[string(b[i+len(yieldMarker):end])]; ok {
+			if _, err := yw.w.Write(s.Bytes()); err != nil {
+				return err
+			}
+		}
+		b = b[end+1:]
+	}
+	_, err := yw.w.Write(b)
+	return err
+}
+
+// rewriteSections rewrites section actions in c, and the end actions closing them, into calls of the section funcs
+func rewriteSections(c []byte, left, right string) []byte {
+	var out bytes.Buffer
+	var stack []bool
+
+	for {
+		start := bytes.Index(c, []byte(left))
+		if start < 0 {
+			break
+		}
+		end := bytes.Index(c[start+len(left):], []byte(right))
+		if end < 0 {
+			break
+		}
+		end += start + len(left)
+
+		action := strings.TrimSpace(string(c[start+len(left) : end]))
+		ltrim, rtrim := "", ""
+		if strings.HasPrefix(action, "- ") {
+			ltrim, action = "- ", strings.TrimSpace(action[1:])
+		}
+		if strings.HasSuffix(action, " -") {
+			rtrim, action = " -", strings.TrimSpace(action[:len(action)-1])
+		}
+		fields := strings.Fields(action)
+
+		rewritten := ""
+		if len(fields) > 0 {
+			switch fields[0] {
+			case "section":
+				stack = append(stack, true)
+				rewritten = left + ltrim + " " + sectionStartFunc + strings.TrimPrefix(action, "section") + rtrim + right
+			case "if", "range", "with", "define", "block":
+				stack = append(stack, false)
+			case "end":
+				if len(stack) > 0 {
+					if stack[len(stack)-1] {
+						rewritten = left + ltrim + " " + sectionEndFunc + rtrim + right
+					}
+					stack = stack[:len(stack)-1]
+				}
+			}
+		}
+
+		out.Write(c[:start])
+		if rewritten != "" {
+			out.WriteString(rewritten)
+		} else {
+			out.Write(c[start : end+len(right)])
+		}
+		c = c[end+len(right):]
+	}
+
+	out.Write(c)
+	return out.Bytes()
+}
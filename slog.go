@@ -0,0 +1,29 @@
+// Copyright 2017 Danny van Kooten. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.21
+// +build go1.21
+
+package extemplate
+
+import (
+	"context"
+	"log/slog"
+)
+
+// SetLogger sets the logger that events are logged to: parsing and reloading templates at info level,
+// templates that fail to parse and slow renders (see SetSlowRenderThreshold) at warn level,
+// and fragment and response cache hits and misses at debug level.
+// Nothing is logged if l is nil, which is the default.
+// The return value is the Extemplate instance, so calls can be chained.
+func (x *Extemplate) SetLogger(l *slog.Logger) *Extemplate {
+	if l == nil {
+		x.logFunc = nil
+		return x
+	}
+	x.logFunc = func(level logLevel, msg string, args ...interface{}) {
+		l.Log(context.Background(), slog.Level(level), msg, args...)
+	}
+	return x
+}
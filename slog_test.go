@@ -0,0 +1,47 @@
+//go:build go1.21
+// +build go1.21
+
+package extemplate
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestSetLogger(t *testing.T) {
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	if err := New().SetLogger(logger).ParseFS(fstest.MapFS{
+		"broken.tmpl": {Data: []byte(`{{ if }}`)},
+	}, []string{".tmpl"}); err == nil {
+		t.Fatal("Expected parse error, got none")
+	}
+
+	x := New().SetLogger(logger).SetSlowRenderThreshold(time.Nanosecond)
+	if err := x.ParseFS(fstest.MapFS{
+		"page.tmpl": {Data: []byte(`{{ cache "k" "1m" "card.tmpl" . }}{{ cache "k" "1m" "card.tmpl" . }}`)},
+		"card.tmpl": {Data: []byte(`card`)},
+	}, []string{".tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := x.ExecuteTemplate(io.Discard, "page.tmpl", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, e := range []string{
+		`level=WARN msg="extemplate: failed to parse template" template=broken.tmpl`,
+		`level=INFO msg="extemplate: parsed templates" files=2`,
+		`level=DEBUG msg="extemplate: fragment cache miss" template=card.tmpl key=k`,
+		`level=DEBUG msg="extemplate: fragment cache hit" template=card.tmpl key=k`,
+		`level=WARN msg="extemplate: slow render" template=page.tmpl`,
+	} {
+		if !strings.Contains(logs.String(), e) {
+			t.Errorf("Expected logs to contain %q, got %s", e, logs.String())
+		}
+	}
+}
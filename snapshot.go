@@ -0,0 +1,85 @@
+// Copyright 2017 Danny van Kooten. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package extemplate
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"sync"
+	texttemplate "text/template"
+)
+
+// DefaultSnapshotHistory is the number of snapshots kept when no history size is set
+const DefaultSnapshotHistory = 10
+
+// Version identifies a snapshot of the template set, see Snapshot
+type Version int
+
+type snapshot struct {
+	version Version
+	files   map[string]*templatefile
+}
+
+// WithSnapshotHistory sets the number of snapshots kept for Rollback. Older snapshots are discarded.
+func WithSnapshotHistory(n int) Option {
+	return func(x *Extemplate) {
+		x.maxSnapshots = n
+	}
+}
+
+// Snapshot records the current state of the template set, so that it can be restored using Rollback.
+// Only the most recent snapshots are kept, see WithSnapshotHistory.
+func (x *Extemplate) Snapshot() Version {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	files := make(map[string]*templatefile, len(x.files))
+	for name, tf := range x.files {
+		files[name] = tf
+	}
+
+	x.lastVersion++
+	x.snapshots = append(x.snapshots, snapshot{version: x.lastVersion, files: files})
+
+	max := x.maxSnapshots
+	if max <= 0 {
+		max = DefaultSnapshotHistory
+	}
+	if len(x.snapshots) > max {
+		x.snapshots = append(x.snapshots[:0], x.snapshots[len(x.snapshots)-max:]...)
+	}
+	return x.lastVersion
+}
+
+// Rollback restores the template set to the given snapshot, recompiling all templates.
+// Templates added since the snapshot was taken are removed.
+func (x *Extemplate) Rollback(v Version) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	var files map[string]*templatefile
+	for _, s := range x.snapshots {
+		if s.version == v {
+			files = s.files
+		}
+	}
+	if files == nil {
+		return fmt.Errorf("extemplate: no snapshot with version %d", v)
+	}
+
+	x.shared = template.New("").Delims(x.leftDelim, x.rightDelim).Funcs(x.funcs)
+	x.text = texttemplate.New("").Delims(x.leftDelim, x.rightDelim).Funcs(texttemplate.FuncMap(x.funcs))
+	x.templates = make(map[string]*template.Template)
+	x.texts = make(map[string]*texttemplate.Template)
+	x.pools = make(map[string]*sync.Pool)
+	x.files = make(map[string]*templatefile, len(files))
+
+	restore := make(map[string]*templatefile, len(files))
+	for name, tf := range files {
+		restore[name] = tf
+	}
+	return x.parseFilesLocked(context.Background(), restore)
+}
@@ -0,0 +1,208 @@
+// Copyright 2017 Danny van Kooten. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// Package sqlstore loads templates from a database table, so that they can be edited without deploying.
+//
+// The table needs at least the following columns:
+//
+//	CREATE TABLE templates (
+//		name       VARCHAR(255) PRIMARY KEY,
+//		content    TEXT NOT NULL,
+//		version    INTEGER NOT NULL,
+//		updated_at TIMESTAMP NOT NULL
+//	);
+//
+// Applications should increment version whenever content changes, as that is how changes are detected.
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dannyvankooten/extemplate"
+)
+
+// Store is an extemplate.Loader reading templates from a database table.
+// List reads all templates in a single query, so that Read serves a consistent snapshot of the table.
+type Store struct {
+	DB *sql.DB
+	// Table is the name of the table holding the templates, "templates" if empty
+	Table string
+	// Interval is the polling interval of Watch, 10 seconds if zero
+	Interval time.Duration
+
+	mu       sync.RWMutex
+	contents map[string][]byte
+	versions map[string]int64
+
+	// applying holds the snapshot steady while it is applied
+	applying sync.Mutex
+}
+
+var _ extemplate.Loader = (*Store)(nil)
+
+func (s *Store) table() string {
+	if s.Table == "" {
+		return "templates"
+	}
+	return s.Table
+}
+
+// List returns the names of all templates in the table and takes a snapshot of their contents
+func (s *Store) List(ctx context.Context) ([]string, error) {
+	rows, err := s.DB.QueryContext(ctx, fmt.Sprintf("SELECT name, content, version FROM %s", s.table()))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var names []string
+	contents := make(map[string][]byte)
+	versions := make(map[string]int64)
+	for rows.Next() {
+		var name, content string
+		var version int64
+		if err := rows.Scan(&name, &content, &version); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+		contents[name] = []byte(content)
+		versions[name] = version
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	s.contents, s.versions = contents, versions
+	s.mu.Unlock()
+	return names, nil
+}
+
+// Read returns the content of the named template, as of the last call to List
+func (s *Store) Read(ctx context.Context, name string) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	c, ok := s.contents[name]
+	if !ok {
+		return nil, fmt.Errorf("sqlstore: %s: %w", name, fs.ErrNotExist)
+	}
+	return c, nil
+}
+
+// Versions returns the version of every template, as of the last call to List
+func (s *Store) Versions() map[string]int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	versions := make(map[string]int64, len(s.versions))
+	for name, v := range s.versions {
+		versions[name] = v
+	}
+	return versions
+}
+
+// Watch calls fn whenever a template is added or removed, or its version changes
+func (s *Store) Watch(ctx context.Context, fn func()) error {
+	interval := s.Interval
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+
+	last, err := s.signature(ctx)
+	if err != nil {
+		return err
+	}
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+
+		sig, err := s.signature(ctx)
+		if err != nil {
+			return err
+		}
+		if sig != last {
+			last = sig
+			fn()
+		}
+	}
+}
+
+// signature returns a string that changes whenever a template is added, removed or its version changes
+func (s *Store) signature(ctx context.Context) (string, error) {
+	rows, err := s.DB.QueryContext(ctx, fmt.Sprintf("SELECT name, version FROM %s", s.table()))
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	var lines []string
+	for rows.Next() {
+		var name string
+		var version int64
+		if err := rows.Scan(&name, &version); err != nil {
+			return "", err
+		}
+		lines = append(lines, fmt.Sprintf("%s %d", name, version))
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "\n"), rows.Err()
+}
+
+// Apply parses the templates in the table into x, but only if all of them compile:
+// templates are parsed into a clone of x first, so that a broken edit leaves x untouched.
+func (s *Store) Apply(ctx context.Context, x *extemplate.Extemplate) error {
+	s.applying.Lock()
+	defer s.applying.Unlock()
+
+	c, err := x.Clone()
+	if err != nil {
+		return err
+	}
+	if err := c.ParseLoader(ctx, s); err != nil {
+		return err
+	}
+
+	// parse the same snapshot into x, without listing the table again
+	return x.ParseLoader(ctx, snapshot{s})
+}
+
+// Sync applies the templates in the table to x whenever they change, until ctx is done.
+// Errors applying changed templates are passed to onError, if it is not nil, and do not stop syncing.
+func (s *Store) Sync(ctx context.Context, x *extemplate.Extemplate, onError func(err error)) error {
+	return s.Watch(ctx, func() {
+		if err := s.Apply(ctx, x); err != nil && onError != nil {
+			onError(err)
+		}
+	})
+}
+
+// snapshot is a Loader serving the templates of the last call to List on the store
+type snapshot struct {
+	*Store
+}
+
+func (s snapshot) List(ctx context.Context) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	names := make([]string, 0, len(s.contents))
+	for name := range s.contents {
+		names = append(names, name)
+	}
+	return names, nil
+}
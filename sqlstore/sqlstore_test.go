@@ -0,0 +1,132 @@
+package sqlstore
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/dannyvankooten/extemplate"
+)
+
+// fakeDriver serves the rows of an in-memory templates table for the queries used by Store
+type fakeDriver struct {
+	mu   sync.Mutex
+	rows map[string]fakeRow
+}
+
+type fakeRow struct {
+	content string
+	version int64
+}
+
+func (d *fakeDriver) set(name, content string, version int64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.rows[name] = fakeRow{content, version}
+}
+
+func (d *fakeDriver) Open(name string) (driver.Conn, error) { return &fakeConn{d}, nil }
+
+type fakeConn struct{ d *fakeDriver }
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) { return &fakeStmt{c.d, query}, nil }
+func (c *fakeConn) Close() error                              { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error)                 { return nil, driver.ErrSkip }
+
+type fakeStmt struct {
+	d     *fakeDriver
+	query string
+}
+
+func (s *fakeStmt) Close() error                                    { return nil }
+func (s *fakeStmt) NumInput() int                                   { return 0 }
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) { return nil, driver.ErrSkip }
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+
+	withContent := strings.Contains(s.query, "content")
+	r := &fakeRows{cols: []string{"name", "version"}}
+	if withContent {
+		r.cols = []string{"name", "content", "version"}
+	}
+	for name, row := range s.d.rows {
+		if withContent {
+			r.values = append(r.values, []driver.Value{name, row.content, row.version})
+		} else {
+			r.values = append(r.values, []driver.Value{name, row.version})
+		}
+	}
+	sort.Slice(r.values, func(i, j int) bool { return r.values[i][0].(string) < r.values[j][0].(string) })
+	return r, nil
+}
+
+type fakeRows struct {
+	cols   []string
+	values [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return r.cols }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
+
+func TestStore(t *testing.T) {
+	d := &fakeDriver{rows: map[string]fakeRow{
+		"base.tmpl":  {`base {{ block "content" . }}{{ end }}`, 1},
+		"email.tmpl": {"{{ extends \"base.tmpl\" }}\n{{ define \"content\" }}hello{{ end }}", 1},
+	}}
+	sql.Register("extemplate-fake", d)
+	db, err := sql.Open("extemplate-fake", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Store{DB: db}
+	x := extemplate.New()
+	if err := s.Apply(context.Background(), x); err != nil {
+		t.Fatal(err)
+	}
+	assertOutput(t, x, "base hello")
+
+	// a broken edit is not applied
+	d.set("email.tmpl", "{{ extends \"base.tmpl\" }}\n{{ define \"content\" }}{{ if }}{{ end }}", 2)
+	if err := s.Apply(context.Background(), x); err == nil {
+		t.Error("Expected error applying broken template, got none")
+	}
+	assertOutput(t, x, "base hello")
+
+	d.set("email.tmpl", "{{ extends \"base.tmpl\" }}\n{{ define \"content\" }}edited{{ end }}", 3)
+	if err := s.Apply(context.Background(), x); err != nil {
+		t.Fatal(err)
+	}
+	assertOutput(t, x, "base edited")
+	if v := s.Versions()["email.tmpl"]; v != 3 {
+		t.Errorf("Expected version 3, got %d", v)
+	}
+}
+
+func assertOutput(t *testing.T, x *extemplate.Extemplate, e string) {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := x.ExecuteTemplate(&buf, "email.tmpl", nil); err != nil {
+		t.Fatal(err)
+	}
+	if a := buf.String(); a != e {
+		t.Errorf("Expected %q, got %q", e, a)
+	}
+}
@@ -0,0 +1,292 @@
+// Copyright 2017 Danny van Kooten. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package extemplate
+
+import (
+	"context"
+	"html/template"
+	"io"
+	"runtime/pprof"
+	"sort"
+	"strconv"
+	"sync"
+	"text/template/parse"
+	"time"
+)
+
+// Names of the funcs called by tracking probes. Like coverage probes, these are only ever inserted into parse trees.
+const (
+	trackFunc    = "extemplateTrack"
+	trackEndFunc = "extemplateTrackEnd"
+)
+
+// RenderStats describes a single execution of a template, see ExecuteTemplateStats
+type RenderStats struct {
+	// Bytes is the number of bytes written
+	Bytes int64
+	// Duration is the wall time the execution took
+	Duration time.Duration
+	// Templates are the sorted names of the templates that were executed, including the executed template itself,
+	// the templates it invoked and the templates they invoked in turn. Only recorded if templates are tracked, see WithTemplateTracking.
+	Templates []string
+}
+
+// ProfileHook is called with the name and duration of every template execution, see WithProfiling
+type ProfileHook func(ctx context.Context, name string, d time.Duration)
+
+// renderState holds the templates executed by an execution, shared with the executions it starts
+type renderState struct {
+	mu        sync.Mutex
+	templates map[string]bool
+	// templates that are executing, innermost last, when profiling
+	stack []frame
+}
+
+type frame struct {
+	name  string
+	start time.Time
+}
+
+type renderStateKey struct{}
+
+// WithTemplateTracking instruments templates to record which templates are executed, see RenderStats.
+// Every template invocation calls a func, which slows down execution somewhat.
+func WithTemplateTracking() Option {
+	return func(x *Extemplate) {
+		x.enableTracking()
+	}
+}
+
+// WithProfiling instruments templates to measure how long every template takes to execute, including partials
+// invoked using template actions or include, and passes the durations to hook.
+// While a template executes, the goroutine carries the pprof label "template" with its name,
+// so that CPU profiles can be broken down by template.
+// Durations of nested templates are included in the durations of the templates invoking them.
+func WithProfiling(hook ProfileHook) Option {
+	return func(x *Extemplate) {
+		x.profile = hook
+		x.enableTracking()
+	}
+}
+
+// enableTracking registers the funcs called by tracking probes, see instrumentTracking
+func (x *Extemplate) enableTracking() {
+	if x.tracking {
+		return
+	}
+	x.tracking = true
+	x.ContextFuncs(func(ctx context.Context) template.FuncMap {
+		state, _ := ctx.Value(renderStateKey{}).(*renderState)
+		return template.FuncMap{
+			trackFunc: func(name string) bool {
+				if state == nil {
+					return false
+				}
+				state.mu.Lock()
+				state.templates[name] = true
+				if x.profile != nil {
+					state.stack = append(state.stack, frame{name: name, start: time.Now()})
+				}
+				state.mu.Unlock()
+				if x.profile != nil {
+					pprof.SetGoroutineLabels(pprof.WithLabels(ctx, pprof.Labels("template", name)))
+				}
+				return false
+			},
+			trackEndFunc: func(name string) bool {
+				if state == nil || x.profile == nil {
+					return false
+				}
+
+				// frames of templates that failed are left on the stack, so pop up to the frame of this template
+				state.mu.Lock()
+				i := len(state.stack) - 1
+				for i >= 0 && state.stack[i].name != name {
+					i--
+				}
+				if i < 0 {
+					state.mu.Unlock()
+					return false
+				}
+				f := state.stack[i]
+				state.stack = state.stack[:i]
+				labels := ctx
+				if i > 0 {
+					labels = pprof.WithLabels(ctx, pprof.Labels("template", state.stack[i-1].name))
+				}
+				state.mu.Unlock()
+
+				pprof.SetGoroutineLabels(labels)
+				x.profile(ctx, name, time.Since(f.start))
+				return false
+			},
+		}
+	})
+}
+
+// tracked returns ctx with the state of a new execution, unless it is part of one already.
+// When profiling, the returned func must be called once the execution is done: it drops the frames of templates
+// that failed to finish and restores the pprof labels of the invoking template, if any.
+func (x *Extemplate) tracked(ctx context.Context) (context.Context, func()) {
+	state, ok := ctx.Value(renderStateKey{}).(*renderState)
+	if !ok {
+		state = &renderState{templates: make(map[string]bool)}
+		ctx = context.WithValue(ctx, renderStateKey{}, state)
+	}
+	if x.profile == nil {
+		return ctx, func() {}
+	}
+
+	state.mu.Lock()
+	depth := len(state.stack)
+	state.mu.Unlock()
+	return ctx, func() {
+		state.mu.Lock()
+		if len(state.stack) > depth {
+			state.stack = state.stack[:depth]
+		}
+		labels := ctx
+		if depth > 0 {
+			labels = pprof.WithLabels(ctx, pprof.Labels("template", state.stack[depth-1].name))
+		}
+		state.mu.Unlock()
+		pprof.SetGoroutineLabels(labels)
+	}
+}
+
+// ExecuteTemplateStats is like ExecuteTemplate, but also returns the number of bytes written,
+// the duration of the execution and, if templates are tracked, the templates that were executed.
+// The stats are returned even if executing the template fails.
+func (x *Extemplate) ExecuteTemplateStats(wr io.Writer, name string, data interface{}) (RenderStats, error) {
+	return x.ExecuteTemplateStatsContext(context.Background(), wr, name, data)
+}
+
+// ExecuteTemplateStatsContext is like ExecuteTemplateStats, but binds context-aware template funcs to ctx, see ExecuteTemplateContext.
+func (x *Extemplate) ExecuteTemplateStatsContext(ctx context.Context, wr io.Writer, name string, data interface{}) (RenderStats, error) {
+	state := &renderState{templates: make(map[string]bool)}
+	ctx = context.WithValue(ctx, renderStateKey{}, state)
+	cw := &countWriter{w: wr}
+
+	start := time.Now()
+	err := x.ExecuteTemplateContext(ctx, cw, name, data)
+	stats := RenderStats{Bytes: cw.n, Duration: time.Since(start)}
+
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	if len(state.templates) > 0 {
+		stats.Templates = make([]string, 0, len(state.templates))
+		for name := range state.templates {
+			stats.Templates = append(stats.Templates, name)
+		}
+		sort.Strings(stats.Templates)
+	}
+	return stats, err
+}
+
+// countWriter counts the bytes written to it
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// instrumentTracking adds probes recording the start and end of the execution of the template to all trees that were not instrumented yet.
+// It must be called before the sandbox instruments the trees, as that expects its own probe to come last.
+func instrumentTracking(trees []*parse.Tree) {
+	for _, tree := range trees {
+		if tree == nil || tree.Root == nil || isTracked(tree.Root) {
+			continue
+		}
+		tree.Root.Nodes = append([]parse.Node{newTrackProbe(trackFunc, tree.Name)}, tree.Root.Nodes...)
+		tree.Root.Nodes = append(tree.Root.Nodes, newTrackProbe(trackEndFunc, tree.Name))
+	}
+}
+
+// newTrackProbe returns {{ if fn "name" }}{{ end }}, see newProbe
+func newTrackProbe(fn string, name string) parse.Node {
+	t := parse.New("track")
+	t.Mode = parse.SkipFuncCheck
+	if _, err := t.Parse("{{ if "+fn+" "+strconv.Quote(name)+" }}{{ end }}", "{{", "}}", make(map[string]*parse.Tree)); err != nil {
+		panic(err)
+	}
+	return t.Root.Nodes[0]
+}
+
+// isTracked reports whether list contains a tracking probe. Other probes may have been added before it since.
+func isTracked(list *parse.ListNode) bool {
+	for _, n := range list.Nodes {
+		n, ok := n.(*parse.IfNode)
+		if !ok || len(n.Pipe.Cmds) != 1 || len(n.Pipe.Cmds[0].Args) == 0 {
+			continue
+		}
+		if id, ok := n.Pipe.Cmds[0].Args[0].(*parse.IdentifierNode); ok && id.Ident == trackFunc {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseStats describes the parsed template set, see Extemplate.ParseStats
+type ParseStats struct {
+	// Files is the number of parsed template files
+	Files int
+	// Bytes is the total size of the parsed template files
+	Bytes int64
+	// Durations are the times it took to parse and compile each template, by name, as of the last time it was compiled.
+	// Templates compiled lazily only include their compile time once compiled, see WithLazyCompilation.
+	Durations map[string]time.Duration
+	// Slowest are the names of the templates that took longest to parse and compile, slowest first, at most 10
+	Slowest []string
+	// Depths is the number of templates by the length of their layout chain, 0 for templates that do not extend a layout
+	Depths map[int]int
+}
+
+// maxSlowest is the maximum number of templates in ParseStats.Slowest
+const maxSlowest = 10
+
+// ParseStats returns statistics about the parsed templates, for tracking the size and startup cost of the template set over time.
+func (x *Extemplate) ParseStats() ParseStats {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	stats := ParseStats{
+		Files:     len(x.files),
+		Durations: make(map[string]time.Duration, len(x.files)),
+		Depths:    make(map[int]int),
+	}
+	for name, tf := range x.files {
+		stats.Bytes += int64(len(tf.contents))
+		stats.Durations[name] = tf.parseTime + tf.compileTime
+
+		// stop at missing layouts and cycles
+		depth := 0
+		for l := tf.layout; l != "" && depth < len(x.files); depth++ {
+			parent, ok := x.files[l]
+			if !ok {
+				break
+			}
+			l = parent.layout
+		}
+		stats.Depths[depth]++
+	}
+
+	stats.Slowest = make([]string, 0, len(stats.Durations))
+	for name := range stats.Durations {
+		stats.Slowest = append(stats.Slowest, name)
+	}
+	sort.Strings(stats.Slowest)
+	sort.SliceStable(stats.Slowest, func(i, j int) bool {
+		return stats.Durations[stats.Slowest[i]] > stats.Durations[stats.Slowest[j]]
+	})
+	if len(stats.Slowest) > maxSlowest {
+		stats.Slowest = stats.Slowest[:maxSlowest]
+	}
+	return stats
+}
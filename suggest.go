@@ -0,0 +1,104 @@
+// Copyright 2017 Danny van Kooten. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package extemplate
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+)
+
+// missingTemplateRegex matches the errors returned by html/template and text/template when invoking an undefined template
+var missingTemplateRegex = regexp.MustCompile(`no such template "([^"]*)"|template "([^"]*)" not defined`)
+
+// didYouMean returns a suggestion like ", did you mean "layouts/base.tmpl"?" for the candidate closest to name,
+// or "" if no candidate is close enough to be a likely typo
+func didYouMean(name string, candidates []string) string {
+	best, bestDist := "", -1
+	for _, c := range candidates {
+		if c == name {
+			continue
+		}
+
+		// also match names that only differ in their directory, like "base.tmpl" for "layouts/base.tmpl"
+		d := levenshtein(name, c)
+		if d > 1 && path.Base(name) == path.Base(c) {
+			d = 1
+		}
+		if d < bestDist || bestDist < 0 || (d == bestDist && c < best) {
+			best, bestDist = c, d
+		}
+	}
+
+	limit := len(name) / 3
+	if limit < 2 {
+		limit = 2
+	}
+	if bestDist < 0 || bestDist > limit {
+		return ""
+	}
+	return fmt.Sprintf(", did you mean %q?", best)
+}
+
+// levenshtein returns the edit distance between a and b
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+// definedNames returns the names of all parsed templates and the templates they define. The caller must hold x.mu.
+func (x *Extemplate) definedNames() map[string]bool {
+	names := make(map[string]bool, len(x.files))
+	for name, tf := range x.files {
+		names[name] = true
+		for _, d := range tf.defines {
+			names[d] = true
+		}
+	}
+	return names
+}
+
+// suggestTemplate adds a suggestion to err if it is returned for invoking an undefined template with a name close to a defined one
+func (x *Extemplate) suggestTemplate(err error) error {
+	m := missingTemplateRegex.FindStringSubmatch(err.Error())
+	if m == nil {
+		return err
+	}
+	name := m[1] + m[2]
+
+	x.mu.RLock()
+	s := didYouMean(name, sortedNames(x.definedNames()))
+	x.mu.RUnlock()
+	if s == "" {
+		return err
+	}
+	return fmt.Errorf("%w%s", err, s)
+}
@@ -6,231 +6,1505 @@ package extemplate
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
 	"fmt"
 	"html/template"
 	"io"
+	"io/fs"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"regexp"
+	"runtime"
+	"sort"
 	"strings"
+	"sync"
+	texttemplate "text/template"
+	"text/template/parse"
+	"time"
 )
 
-var extendsRegex *regexp.Regexp
+// defaultDirectiveRegex and defaultHeaderRegex match directive lines and the blank lines or comments that may precede them,
+// for the default delimiters
+var defaultDirectiveRegex, defaultHeaderRegex = directiveRegexes("{{", "}}")
 
 // Extemplate holds a reference to all templates
 // and shared configuration like Delims or FuncMap
 type Extemplate struct {
 	shared    *template.Template
 	templates map[string]*template.Template
+	text      *texttemplate.Template
+	texts     map[string]*texttemplate.Template
+	textExts  map[string]bool
+	files     map[string]*templatefile
+	mu        sync.RWMutex
+	lazy      bool
+	workers   int
+	funcs     template.FuncMap
+
+	maxFileSize int64
+	parseHooks  []ParseHook
+	directives  map[string]Directive
+	frontMatter []frontMatter
+	dataFormats map[string]func(data []byte, v interface{}) error
+	nameFunc    func(path string) string
+	foldCase    bool
+	strictExts  bool
+	subset      string
+	symlinks    SymlinkPolicy
+	conflicts   ConflictPolicy
+	pools       map[string]*sync.Pool
+	ctxFuncs    []func(ctx context.Context) template.FuncMap
+	csrf        func(ctx context.Context) template.HTML
+	urlResolver URLResolver
+	flashes     FlashProvider
+	filters     []OutputFilter
+
+	funcScopes       []funcScope
+	delimScopes      []delimScope
+	funcRestrictions []funcRestriction
+
+	logFunc    func(level logLevel, msg string, args ...interface{})
+	slowRender time.Duration
+
+	recoverPanics bool
+	strictKeys    bool
+	autoReload    bool
+	reloadMu      sync.Mutex
+	lastReload    time.Time
+	failedReloads map[string]time.Time
+	onReload      func(paths []string)
+	onReloadError func(err error)
+	sandbox       *Sandbox
+	tracking      bool
+	profile       ProfileHook
+	componentDir  string
+	sections      bool
+
+	errorTemplate string
+	errorDetails  bool
+	fragments     Cache
+	responses     Cache
+	noCache       bool
+	responseTTL   time.Duration
+	blockLoaders  map[string]BlockLoader
+
+	snapshots    []snapshot
+	lastVersion  Version
+	maxSnapshots int
+
+	usage    *usage
+	coverage *coverage
+
+	leftDelim  string
+	rightDelim string
+	trimBlocks bool
+
+	extendsPattern *regexp.Regexp
+	directiveRegex *regexp.Regexp
+	headerRegex    *regexp.Regexp
+
+	// file system and extensions templates were last parsed from, used by ReloadFile and to find new files in Dev
+	fsys       fs.FS
+	extensions []string
+	// warnings found since templates were last parsed from a file system
+	warnings []Warning
+
+	// files embedded using the inline func and integrity hashes of files, by path
+	inlined           map[string]template.HTML
+	integrities       map[string]string
+	integrityManifest map[string]string
+	assetMu           sync.RWMutex
+	maxInlineSize     int64
+
+	// other names of templates, see Alias, and index templates of directories, see WithDirectoryIndex
+	aliases map[string]string
+	aliasMu sync.RWMutex
+	indexes []string
+
+	jsonFallback bool
 }
 
+// OutputFilter wraps the writer that the template with the given name is executed into.
+// If the returned writer implements io.Closer, it is closed after the template is executed successfully.
+// If executing the template fails, the writer is not closed, so that any output it buffers is dropped.
+type OutputFilter func(name string, w io.Writer) io.Writer
+
+// ParseHook is called with the name and contents of every discovered template file,
+// and returns the (possibly modified) contents to parse.
+// Returning SkipFile as the error skips the file, any other error aborts parsing.
+type ParseHook func(name string, contents []byte) ([]byte, error)
+
+// SkipFile is used as a return value from a ParseHook to indicate that the file should not be parsed.
+var SkipFile = errors.New("skip this file")
+
+// SymlinkPolicy controls how symbolic links are handled when walking a template directory
+type SymlinkPolicy int
+
+const (
+	// SymlinkFiles follows symbolic links to files, but not to directories. This is the default.
+	SymlinkFiles SymlinkPolicy = iota
+	// SymlinkNone skips all symbolic links.
+	SymlinkNone
+	// SymlinkAll follows symbolic links to both files and directories, returning an error on symlink loops.
+	SymlinkAll
+)
+
+// maxWalkDepth is the maximum directory depth when following symbolic links to directories
+const maxWalkDepth = 64
+
+// Option configures an Extemplate instance, see New
+type Option func(x *Extemplate)
+
 type templatefile struct {
+	path string
+	// source identifies the file system or loader the file was read from, see sourceOf
+	source   string
 	contents []byte
 	layout   string
+	meta     map[string]interface{}
+	hash     [sha256.Size]byte
+	modTime  time.Time
+	// number of lines stripped from the start of the file, like directives and front matter
+	offset int
+	// action delimiters the file is written with
+	leftDelim, rightDelim string
+
+	// names of the templates defined in and invoked by this file, see references
+	defines []string
+	uses    []string
+	// paths of other files with the same name that were ignored, see ConflictPolicy
+	ignored []string
+	// time it took to parse the file into the shared set and to compile the template, see ParseStats
+	parseTime, compileTime time.Duration
 }
 
-func init() {
-	var err error
-	extendsRegex, err = regexp.Compile(`\{\{ *?extends +?"(.+?)" *?\}\}`)
-	if err != nil {
-		panic(err)
+// directiveRegexes returns the patterns matching a directive line and a header line (blank or comment) for the given delimiters.
+// Directives are written as an action, {{ extends "a.tmpl" }}, or as a comment, {{/* extends "a.tmpl" */}}.
+func directiveRegexes(left, right string) (directive *regexp.Regexp, header *regexp.Regexp) {
+	l, r := regexp.QuoteMeta(left), regexp.QuoteMeta(right)
+	directive = regexp.MustCompile(`^[ \t]*` + l + `-?[ \t]*(?:/\*[ \t]*([a-zA-Z_][a-zA-Z0-9_]*)(.*?)[ \t]*\*/|([a-zA-Z_][a-zA-Z0-9_]*)(.*?))[ \t]*-?` + r + `\s*$`)
+	header = regexp.MustCompile(`^(?:[ \t]*\r?\n|[ \t]*` + l + `-?[ \t]*/\*(?:[^*]|\*+[^*/])*\*+/[ \t]*-?` + r + `[ \t]*(?:\r?\n|$))`)
+	return directive, header
+}
+
+// WithMinify collapses whitespace in the rendered output of all templates.
+// The contents of pre, textarea, script and style elements are left untouched.
+func WithMinify() Option {
+	return func(x *Extemplate) {
+		x.AddOutputFilter(func(name string, w io.Writer) io.Writer {
+			return newMinifyWriter(w)
+		})
+	}
+}
+
+// WithTextExtensions parses files with any of the given extensions using text/template instead of html/template.
+// Text templates share the extends mechanism and FuncMap with HTML templates,
+// but can only include other text templates.
+func WithTextExtensions(extensions ...string) Option {
+	return func(x *Extemplate) {
+		for _, e := range extensions {
+			x.textExts[e] = true
+		}
+	}
+}
+
+// WithLazyCompilation defers compiling templates that extend a layout until they are first looked up or executed.
+// This speeds up parsing of large template sets, but means that errors in the layout chain are only
+// reported by ExecuteTemplate (or a nil return value from Lookup) instead of by ParseDir.
+func WithLazyCompilation() Option {
+	return func(x *Extemplate) {
+		x.lazy = true
+	}
+}
+
+// WithWorkers sets the number of goroutines used to read and compile template files, defaulting to GOMAXPROCS.
+func WithWorkers(n int) Option {
+	return func(x *Extemplate) {
+		if n > 0 {
+			x.workers = n
+		}
+	}
+}
+
+// WithMaxFileSize makes parsing fail when a template file is larger than the given number of bytes.
+func WithMaxFileSize(n int64) Option {
+	return func(x *Extemplate) {
+		x.maxFileSize = n
+	}
+}
+
+// WithDirectoryIndex resolves the name of a directory, like "users" or "users/", to the first of the given index templates
+// in that directory that exists, like "users/index.tmpl", wherever a template name is accepted.
+// The root directory is named "" or "/". Names of existing templates are never resolved to an index template.
+func WithDirectoryIndex(names ...string) Option {
+	return func(x *Extemplate) {
+		x.indexes = names
+	}
+}
+
+// WithSymlinkPolicy sets how symbolic links are handled when walking a template directory.
+func WithSymlinkPolicy(p SymlinkPolicy) Option {
+	return func(x *Extemplate) {
+		x.symlinks = p
+	}
+}
+
+// WithParseHook adds a hook that is called for every discovered template file before it is parsed.
+// Hooks are called in the order in which they were added, possibly concurrently for different files.
+func WithParseHook(hook ParseHook) Option {
+	return func(x *Extemplate) {
+		x.parseHooks = append(x.parseHooks, hook)
+	}
+}
+
+// WithNameFunc sets the function mapping file paths to template names.
+// It receives the slash-separated path relative to the parsed root directory, e.g. "users/show.tmpl",
+// and is also applied to the paths in extends directives.
+// By default, templates are named by their path.
+func WithNameFunc(fn func(path string) string) Option {
+	return func(x *Extemplate) {
+		x.nameFunc = fn
+	}
+}
+
+// WithCaseInsensitiveLookup makes template names case-insensitive,
+// by lowercasing names when templates are registered and when they are looked up or executed.
+func WithCaseInsensitiveLookup() Option {
+	return func(x *Extemplate) {
+		x.foldCase = true
 	}
 }
 
-// New allocates a new, empty, template map
-func New() *Extemplate {
-	shared := template.New("")
-	return &Extemplate{
-		shared:    shared,
-		templates: make(map[string]*template.Template),
+// WithStrictExtensions matches file extensions exactly, instead of case-insensitively and with an optional leading dot.
+// For example, ".tmpl" then no longer matches index.TMPL and "tmpl" matches no files at all.
+func WithStrictExtensions() Option {
+	return func(x *Extemplate) {
+		x.strictExts = true
 	}
 }
 
+// New allocates a new, empty, template map, configured with the given options
+func New(opts ...Option) *Extemplate {
+	x := &Extemplate{
+		shared:     template.New(""),
+		templates:  make(map[string]*template.Template),
+		text:       texttemplate.New(""),
+		texts:      make(map[string]*texttemplate.Template),
+		textExts:   make(map[string]bool),
+		files:      make(map[string]*templatefile),
+		workers:    runtime.GOMAXPROCS(0),
+		funcs:      make(template.FuncMap),
+		directives: map[string]Directive{"extends": extendsDirective},
+		pools:      make(map[string]*sync.Pool),
+		fragments:  NewLRUCache(DefaultCacheSize),
+		leftDelim:  "{{",
+		rightDelim: "}}",
+
+		directiveRegex: defaultDirectiveRegex,
+		headerRegex:    defaultHeaderRegex,
+	}
+	x.ContextFuncs(func(ctx context.Context) template.FuncMap {
+		return template.FuncMap{
+			"csrfField": func() template.HTML {
+				if x.csrf == nil {
+					return ""
+				}
+				return x.csrf(ctx)
+			},
+			"meta": func(key string) interface{} {
+				return x.Meta(templateName(ctx))[key]
+			},
+			"cache":     x.cacheFunc(ctx),
+			"flush":     flushFunc(ctx),
+			"async":     x.asyncFunc(ctx),
+			"blockData": x.blockDataFunc(ctx),
+			"include":   x.includeFunc(ctx),
+			"flashes":   x.flashesFunc(ctx),
+		}
+	})
+	x.Funcs(template.FuncMap{"dict": dict, "paginate": paginate})
+	x.Funcs(x.boundFuncs())
+	for _, opt := range opts {
+		opt(x)
+	}
+	return x
+}
+
+// boundFuncs returns the built-in funcs that depend on the configuration of x,
+// which copies of x must re-bind to themselves, see clone and Merge
+func (x *Extemplate) boundFuncs() template.FuncMap {
+	return template.FuncMap{"url": x.urlFunc, "inline": x.inlineFunc, "integrity": x.integrityFunc}
+}
+
+// ContextFuncs registers funcs that are re-bound to the context of every execution.
+// It must be called before templates are parsed, as the funcs are registered with a
+// background context so that templates using them can be parsed.
+// The return value is the Extemplate instance, so calls can be chained.
+func (x *Extemplate) ContextFuncs(fn func(ctx context.Context) template.FuncMap) *Extemplate {
+	x.ctxFuncs = append(x.ctxFuncs, fn)
+	x.Funcs(fn(context.Background()))
+	return x
+}
+
+// SetCSRFProvider sets the function used by the csrfField template func to render a CSRF token field.
+// The provider is called with the context passed to ExecuteTemplateContext.
+func (x *Extemplate) SetCSRFProvider(fn func(ctx context.Context) template.HTML) *Extemplate {
+	x.csrf = fn
+	return x
+}
+
 // Delims sets the action delimiters to the specified strings,
 // to be used in subsequent calls to ParseDir.
 // Nested template  definitions will inherit the settings.
 // An empty delimiter stands for the corresponding default: {{ or }}.
+// Directives like extends are written using the same delimiters, e.g. [[ extends "base.tmpl" ]].
 // The return value is the template, so calls can be chained.
 func (x *Extemplate) Delims(left, right string) *Extemplate {
 	x.shared.Delims(left, right)
+	x.text.Delims(left, right)
+	x.leftDelim, x.rightDelim = "{{", "}}"
+	if left != "" {
+		x.leftDelim = left
+	}
+	if right != "" {
+		x.rightDelim = right
+	}
+
+	// directives are written using the same delimiters as actions
+	x.directiveRegex, x.headerRegex = defaultDirectiveRegex, defaultHeaderRegex
+	if x.leftDelim != "{{" || x.rightDelim != "}}" {
+		x.directiveRegex, x.headerRegex = directiveRegexes(x.leftDelim, x.rightDelim)
+	}
+	return x
+}
+
+// delimScope holds the delimiters of the templates with names starting with prefix
+type delimScope struct {
+	prefix         string
+	left, right    string
+	directiveRegex *regexp.Regexp
+	headerRegex    *regexp.Regexp
+}
+
+// DelimsFor sets the action delimiters of templates with names starting with prefix, e.g. "vendor/theme/",
+// overriding the delimiters set using Delims.
+// This allows combining template sources written with different delimiters,
+// for example a child template using {{ }} may extend a layout written with [[ ]].
+// An empty delimiter stands for the corresponding default: {{ or }}.
+// It must be called before templates are parsed.
+// The return value is the Extemplate instance, so calls can be chained.
+func (x *Extemplate) DelimsFor(prefix string, left, right string) *Extemplate {
+	s := delimScope{prefix: x.normalize(prefix), left: "{{", right: "}}"}
+	if left != "" {
+		s.left = left
+	}
+	if right != "" {
+		s.right = right
+	}
+	s.directiveRegex, s.headerRegex = directiveRegexes(s.left, s.right)
+	x.delimScopes = append(x.delimScopes, s)
 	return x
 }
 
 // Funcs adds the elements of the argument map to the template's function map.
-// It must be called before templates are parsed
 // It panics if a value in the map is not a function with appropriate return
 // type or if the name cannot be used syntactically as a function in a template.
-// It is legal to overwrite elements of the map. The return value is the Extemplate instance,
-// so calls can be chained.
+// It is legal to overwrite elements of the map, in which case parsed templates are recompiled, see AddFuncs.
+// The return value is the Extemplate instance, so calls can be chained.
 func (x *Extemplate) Funcs(funcMap template.FuncMap) *Extemplate {
-	x.shared.Funcs(funcMap)
+	if err := x.AddFuncs(funcMap); err != nil {
+		panic(err)
+	}
 	return x
 }
 
 // Lookup returns the template with the given name
 // It returns nil if there is no such template or the template has no definition.
+// The returned template is shared with x: modifying or executing it directly can break recompiling templates,
+// so use ExecuteTemplate to execute it or LookupCopy for a copy owned by the caller.
 func (x *Extemplate) Lookup(name string) *template.Template {
-	if t, ok := x.templates[name]; ok {
-		return t
+	name = x.resolve(name)
+	if _, err := x.pool(name); err != nil {
+		return nil
+	}
+
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return x.templates[name]
+}
+
+// LookupCopy is like Lookup, but returns a copy of the template owned by the caller,
+// so adding templates, parse trees or funcs to it does not affect x.
+func (x *Extemplate) LookupCopy(name string) *template.Template {
+	tmpl := x.Lookup(name)
+	if tmpl == nil {
+		return nil
+	}
+
+	t, err := tmpl.Clone()
+	if err != nil {
+		return nil
+	}
+	return t
+}
+
+// LookupFirst returns the first template of the given names that exists, e.g. for falling back
+// from "errors/404.tmpl" to "errors/default.tmpl". It returns nil if none of the templates exist.
+func (x *Extemplate) LookupFirst(names ...string) *template.Template {
+	for _, name := range names {
+		if t := x.Lookup(name); t != nil {
+			return t
+		}
 	}
 
 	return nil
 }
 
+// LookupText returns the text template with the given name, see WithTextExtensions.
+// It returns nil if there is no such template or the template has no definition.
+// The returned template is shared with x, so it must not be modified, see LookupTextCopy.
+func (x *Extemplate) LookupText(name string) *texttemplate.Template {
+	name = x.resolve(name)
+	if _, err := x.pool(name); err != nil {
+		return nil
+	}
+
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return x.texts[name]
+}
+
+// LookupTextCopy is like LookupText, but returns a copy of the template owned by the caller.
+func (x *Extemplate) LookupTextCopy(name string) *texttemplate.Template {
+	tmpl := x.LookupText(name)
+	if tmpl == nil {
+		return nil
+	}
+
+	t, err := tmpl.Clone()
+	if err != nil {
+		return nil
+	}
+	return t
+}
+
+// Shared returns the set of shared HTML templates, that is all templates that do not extend a layout,
+// together with the templates they define, like partials. It can be used to inspect the defined templates
+// or to execute a single partial. The returned template is a copy owned by the caller,
+// so adding templates or parse trees to it does not affect x.
+func (x *Extemplate) Shared() *template.Template {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	t, err := x.shared.Clone()
+	if err != nil {
+		return nil
+	}
+	return t
+}
+
+// AddOutputFilter adds a filter through which the output of every template execution is written.
+// Output passes through filters in the order in which they were added.
+// The return value is the Extemplate instance, so calls can be chained.
+func (x *Extemplate) AddOutputFilter(f OutputFilter) *Extemplate {
+	x.filters = append(x.filters, f)
+	return x
+}
+
 // ExecuteTemplate applies the template named name to the specified data object and writes the output to wr.
 func (x *Extemplate) ExecuteTemplate(wr io.Writer, name string, data interface{}) error {
-	tmpl := x.Lookup(name)
-	if tmpl == nil {
-		return fmt.Errorf("extemplate: no template %q", name)
+	return x.ExecuteTemplateContext(context.Background(), wr, name, data)
+}
+
+// ExecuteTemplateContext is like ExecuteTemplate but binds context-aware template funcs, like csrfField, to ctx.
+func (x *Extemplate) ExecuteTemplateContext(ctx context.Context, wr io.Writer, name string, data interface{}) error {
+	if x.errorTemplate != "" && x.resolve(name) != x.errorTemplate {
+		return x.executeOrError(ctx, wr, name, data)
 	}
 
-	return tmpl.Execute(wr, data)
+	return x.execute(ctx, wr, name, data)
+}
+
+// nestedKey is the context key marking executions started by another execution, like those of include and cache
+type nestedKey struct{}
+
+// executeNested executes the named template as part of the execution ctx belongs to, see execute.
+// Its output is not passed through output filters, as it ends up in the output of the outer execution.
+func (x *Extemplate) executeNested(ctx context.Context, wr io.Writer, name string, data interface{}) error {
+	return x.execute(context.WithValue(ctx, nestedKey{}, true), wr, name, data)
+}
+
+// execute applies the named template to data, binding context funcs to ctx and writing the output through all filters
+func (x *Extemplate) execute(ctx context.Context, wr io.Writer, name string, data interface{}) (err error) {
+	if x.recoverPanics {
+		defer recoverPanic(name, &err)
+	}
+	if x.autoReload {
+		x.reloadChanged()
+	}
+	if x.logFunc != nil {
+		defer x.logSlowRender(name, time.Now())
+	}
+
+	pool, err := x.pool(name)
+	if err != nil {
+		return err
+	}
+	if x.usage != nil {
+		x.usage.record(x.resolve(name))
+	}
+	ctx = context.WithValue(ctx, templateNameKey{}, name)
+	ctx = context.WithValue(ctx, executingKey{}, x)
+	block, _ := ctx.Value(blockKey{}).(string)
+	if block != "" {
+		ctx = context.WithValue(ctx, blockKey{}, "")
+	}
+	if x.sandbox != nil {
+		ctx, wr = x.sandbox.sandboxed(ctx, wr)
+	}
+	if x.tracking {
+		var done func()
+		ctx, done = x.tracked(ctx)
+		defer done()
+	}
+
+	v := pool.Get()
+	if err, ok := v.(error); ok {
+		return err
+	}
+	defer pool.Put(v)
+
+	// wrap in reverse order, so that output flows through the first filter first.
+	// The output of nested executions ends up in the output of the outermost one, which filters it.
+	filters := x.filters
+	nested, _ := ctx.Value(nestedKey{}).(bool)
+	if nested {
+		filters = nil
+	}
+	writers := make([]io.Writer, len(filters)+1)
+	writers[len(filters)] = wr
+	for i := len(filters) - 1; i >= 0; i-- {
+		wr = filters[i](name, wr)
+		writers[i] = wr
+	}
+	if !nested {
+		ctx = context.WithValue(ctx, writersKey{}, writers)
+	}
+
+	// render blocks concurrently into a stitcher, if requested
+	var st *stitcher
+	if loaders, ok := ctx.Value(parallelKey{}).(map[string]BlockLoader); ok {
+		st = &stitcher{loaders: loaders}
+		ctx = context.WithValue(ctx, parallelKey{}, nil)
+	}
+	ctx = context.WithValue(ctx, stitcherKey{}, st)
+
+	// buffer the output until all sections are pushed, unless part of an execution that does so already
+	var out io.Writer = wr
+	var yw *yieldWriter
+	state, _ := ctx.Value(sectionsKey{}).(*sectionState)
+	if x.sections && state == nil {
+		state = &sectionState{sections: make(map[string]*bytes.Buffer)}
+		ctx = context.WithValue(ctx, sectionsKey{}, state)
+		yw = &yieldWriter{w: out, state: state}
+		out = yw
+	}
+	// blocks rendered concurrently may push into sections as well, so stitch them together before yielding sections
+	stitched := out
+	if st != nil {
+		st.sections = state
+		out = st
+	}
+	// capture the output inside component actions into slots
+	if x.componentDir != "" {
+		out = &componentWriter{w: out}
+		ctx = context.WithValue(ctx, componentWriterKey{}, out)
+	}
+	if x.sections {
+		out = &sectionWriter{w: out, state: state}
+	}
+
+	var tmpl executable
+	switch t := v.(type) {
+	case *template.Template:
+		for _, fn := range x.ctxFuncs {
+			t.Funcs(fn(ctx))
+		}
+		if x.strictKeys {
+			t.Option("missingkey=error")
+		}
+		tmpl = t
+	case *texttemplate.Template:
+		for _, fn := range x.ctxFuncs {
+			t.Funcs(texttemplate.FuncMap(fn(ctx)))
+		}
+		if x.strictKeys {
+			t.Option("missingkey=error")
+		}
+		tmpl = t
+	}
+
+	if block != "" {
+		err = tmpl.ExecuteTemplate(out, block, data)
+	} else {
+		err = tmpl.Execute(out, data)
+	}
+	if err != nil {
+		err = x.execError(name, err)
+	}
+	if err == nil && st != nil {
+		err = st.writeTo(stitched)
+	}
+	if err == nil && yw != nil {
+		err = yw.flush()
+	}
+	if err != nil {
+		// drop the output buffered by filters
+		return err
+	}
+	for _, w := range writers[:len(filters)] {
+		if c, ok := w.(io.Closer); ok {
+			if cerr := c.Close(); err == nil {
+				err = cerr
+			}
+		}
+	}
+	return err
+}
+
+// ExecuteFirst applies the first template of the given names that exists to data, writing the output to wr.
+func (x *Extemplate) ExecuteFirst(wr io.Writer, names []string, data interface{}) error {
+	for _, name := range names {
+		if x.exists(name) {
+			return x.ExecuteTemplate(wr, name, data)
+		}
+	}
+
+	return fmt.Errorf("%w: none of %q exist", ErrTemplateNotFound, names)
+}
+
+// ExecuteTemplateLocale applies the locale-specific variant of the named template to data, writing the output to wr.
+// For name "emails/welcome.tmpl" and locale "nl-BE" it tries "emails/welcome.nl-BE.tmpl", "emails/welcome.nl.tmpl"
+// and finally falls back to "emails/welcome.tmpl".
+func (x *Extemplate) ExecuteTemplateLocale(wr io.Writer, name string, locale string, data interface{}) error {
+	for locale != "" {
+		if n := suffixedName(name, locale); x.exists(n) {
+			return x.ExecuteTemplate(wr, n, data)
+		}
+
+		// strip region or script subtag
+		i := strings.LastIndexAny(locale, "-_")
+		if i < 0 {
+			break
+		}
+		locale = locale[:i]
+	}
+
+	return x.ExecuteTemplate(wr, name, data)
+}
+
+// ExecuteVariant applies the given variant of the named template to data, writing the output to wr.
+// For name "pricing.tmpl" and variant "b" it executes "pricing.b.tmpl", falling back to "pricing.tmpl" if that does not exist
+// or variant is empty.
+func (x *Extemplate) ExecuteVariant(wr io.Writer, name string, variant string, data interface{}) error {
+	if variant != "" {
+		if n := suffixedName(name, variant); x.exists(n) {
+			return x.ExecuteTemplate(wr, n, data)
+		}
+	}
+
+	return x.ExecuteTemplate(wr, name, data)
+}
+
+// blockKey is the context key for the name of the block to execute instead of the template itself, see ExecuteBlock
+type blockKey struct{}
+
+// ExecuteBlock applies the named block (or template defined using define) of the named template to data, writing the output to wr.
+// The block is rendered as defined by the template, after overriding the blocks of its layouts.
+func (x *Extemplate) ExecuteBlock(ctx context.Context, wr io.Writer, name string, block string, data interface{}) error {
+	if !x.hasBlock(name, block) {
+		return fmt.Errorf("%w: template %q has no block %q", ErrTemplateNotFound, name, block)
+	}
+	return x.execute(context.WithValue(ctx, blockKey{}, block), wr, name, data)
+}
+
+// hasBlock reports whether the named template defines or inherits the named block
+func (x *Extemplate) hasBlock(name string, block string) bool {
+	pool, err := x.pool(name)
+	if err != nil {
+		return false
+	}
+	v := pool.Get()
+	defer pool.Put(v)
+	switch t := v.(type) {
+	case *template.Template:
+		return t.Lookup(block) != nil
+	case *texttemplate.Template:
+		return t.Lookup(block) != nil
+	}
+	return false
+}
+
+// exists reports whether a template with the given name was parsed
+func (x *Extemplate) exists(name string) bool {
+	name = x.resolve(name)
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	_, ok := x.files[name]
+	return ok
+}
+
+// pool returns the pool of executable copies of the named template, compiling the template if needed
+func (x *Extemplate) pool(name string) (*sync.Pool, error) {
+	name = x.resolve(name)
+	if !strings.HasPrefix(name, x.subset) {
+		return nil, notFound(name)
+	}
+
+	x.mu.RLock()
+	pool, ok := x.pools[name]
+	x.mu.RUnlock()
+	if ok {
+		return pool, nil
+	}
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if _, ok := x.files[name]; !ok {
+		return nil, notFound(name)
+	}
+	register, err := x.compile(name)
+	if err != nil {
+		return nil, err
+	}
+	register()
+	return x.pools[name], nil
+}
+
+// suffixedName inserts suffix right before the file extension of name
+func suffixedName(name string, suffix string) string {
+	ext := filepath.Ext(name)
+	return strings.TrimSuffix(name, ext) + "." + suffix + ext
+}
+
+// executable is implemented by both html/template and text/template templates
+type executable interface {
+	Execute(wr io.Writer, data interface{}) error
+	ExecuteTemplate(wr io.Writer, name string, data interface{}) error
+}
+
+// newPool returns a pool of executable copies of tmpl, so that funcs can be re-bound per execution.
+// The copies are made from a copy of tmpl that is never executed, as html/template does not allow cloning
+// executed templates and tmpl itself is returned by Lookup.
+func newPool(tmpl *template.Template) *sync.Pool {
+	proto, err := tmpl.Clone()
+	if err != nil {
+		return errPool(err)
+	}
+	return &sync.Pool{
+		New: func() interface{} {
+			t, err := proto.Clone()
+			if err != nil {
+				return err
+			}
+			return t
+		},
+	}
+}
+
+// newTextPool is like newPool, but for text templates
+func newTextPool(tmpl *texttemplate.Template) *sync.Pool {
+	return &sync.Pool{
+		New: func() interface{} {
+			t, err := tmpl.Clone()
+			if err != nil {
+				return err
+			}
+			return t
+		},
+	}
+}
+
+// errPool returns a pool yielding err, for templates that can not be executed
+func errPool(err error) *sync.Pool {
+	return &sync.Pool{
+		New: func() interface{} {
+			return err
+		},
+	}
 }
 
 // ParseDir walks the given directory root and parses all files with any of the registered extensions.
 // Default extensions are .html and .tmpl
-// If a template file has {{/* extends "other-file.tmpl" */}} as its first line it will parse that file for base templates.
+// Extensions are matched case-insensitively and may be given without their leading dot, see WithStrictExtensions.
+// If a template file starts with {{/* extends "other-file.tmpl" */}} or {{ extends "other-file.tmpl" }},
+// optionally preceded by blank lines and comments, it will parse that file for base templates.
 // Parsed templates are named relative to the given root directory
+// Calling ParseDir again only recompiles templates of which a file in their layout chain changed,
+// or which invoke a template defined in a changed file.
 func (x *Extemplate) ParseDir(root string, extensions []string) error {
-	var b []byte
-	var err error
+	return x.ParseDirContext(context.Background(), root, extensions)
+}
+
+// ParseDirContext is like ParseDir, but aborts walking and parsing the directory when ctx is done.
+func (x *Extemplate) ParseDirContext(ctx context.Context, root string, extensions []string) error {
+	return x.ParseFSContext(ctx, os.DirFS(filepath.Clean(root)), extensions)
+}
 
-	files, err := findTemplateFiles(root, extensions)
+// ParseFS is like ParseDir, but walks the root of the given file system instead.
+func (x *Extemplate) ParseFS(fsys fs.FS, extensions []string) error {
+	return x.ParseFSContext(context.Background(), fsys, extensions)
+}
+
+// ParseFSContext is like ParseFS, but aborts walking and parsing the file system when ctx is done.
+func (x *Extemplate) ParseFSContext(ctx context.Context, fsys fs.FS, extensions []string) error {
+	files, err := x.findTemplateFiles(ctx, fsys, extensions)
 	if err != nil {
 		return err
 	}
 
-	// parse all non-child templates into the shared template namespace
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.forgetAssets("")
+	x.fsys = fsys
+	x.extensions = extensions
+	x.warnings = nil
+	if err := x.resolveConflicts(files); err != nil {
+		return err
+	}
+	return x.parseFilesLocked(ctx, files)
+}
+
+// parseFiles parses the given template files into the set
+func (x *Extemplate) parseFiles(ctx context.Context, files map[string]*templatefile) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	return x.parseFilesLocked(ctx, files)
+}
+
+// parseFilesLocked is like parseFiles, but the caller must hold x.mu for writing
+func (x *Extemplate) parseFilesLocked(ctx context.Context, files map[string]*templatefile) (err error) {
+	if x.logFunc != nil {
+		x.log(levelDebug, "extemplate: parsing templates", "files", len(files))
+		defer func(start time.Time) {
+			if err == nil {
+				x.log(levelInfo, "extemplate: parsed templates", "files", len(files), "duration", time.Since(start))
+			}
+		}(time.Now())
+	}
+
+	// find files that are new or changed since they were last parsed
+	// if parsing fails, their previous versions are restored, so that parsing again does not consider them unchanged
+	changed := make(map[string]bool)
+	previous := make(map[string]*templatefile)
+	defer func() {
+		if err == nil {
+			return
+		}
+		for name, tf := range previous {
+			if tf == nil {
+				delete(x.files, name)
+			} else {
+				x.files[name] = tf
+			}
+		}
+	}()
 	for name, tf := range files {
+		if old, ok := x.files[name]; ok && old.hash == tf.hash && old.layout == tf.layout {
+			continue
+		}
+
+		var blocks []string
+		if x.sections {
+			blocks = append(blocks, "section")
+		}
+		if x.trimBlocks {
+			trimmed := blocks
+			if x.componentDir != "" {
+				trimmed = append([]string{"component", "fill", "slot"}, blocks...)
+			}
+			tf.contents = trimBlocks(tf.contents, tf.leftDelim, tf.rightDelim, trimmed...)
+		}
+		if x.componentDir != "" {
+			tf.contents = rewriteComponents(tf.contents, tf.leftDelim, tf.rightDelim, blocks...)
+		}
+		if x.sections {
+			tf.contents = rewriteSections(tf.contents, tf.leftDelim, tf.rightDelim)
+		}
+		tf.defines, tf.uses = references(name, tf.contents, tf.leftDelim, tf.rightDelim)
+		for i, u := range tf.uses {
+			if t := x.target(u); t != "" {
+				tf.uses[i] = t
+			}
+		}
+		if err := x.checkFuncs(name, tf); err != nil {
+			x.log(levelWarn, "extemplate: failed to parse template", "template", name, "error", err)
+			return &ParseError{Name: name, Err: err}
+		}
+		previous[name] = x.files[name]
+		x.files[name] = tf
+		changed[name] = true
+	}
+	x.checkFiles(changed)
+
+	// parse all changed non-child templates into the shared template namespace
+	// sort names so that errors are reported deterministically
+	for _, name := range sortedNames(changed) {
+		tf := files[name]
 		if tf.layout != "" {
 			continue
 		}
 
-		_, err = x.shared.New(name).Parse(string(tf.contents))
-		if err != nil {
-			return err
+		start := time.Now()
+		if err = x.parseShared(name, tf); err != nil {
+			x.log(levelWarn, "extemplate: failed to parse template", "template", name, "error", err)
+			return &ParseError{Name: name, Err: err}
+		}
+		tf.parseTime = time.Since(start)
+
+		if x.coverage != nil && x.isText(tf) {
+			x.coverage.instrument(tf, name, textTrees(x.text))
+		} else if x.coverage != nil {
+			x.coverage.instrument(tf, name, htmlTrees(x.shared))
 		}
 	}
+	if err = x.addAliasTrees(); err != nil {
+		return err
+	}
+	if x.tracking {
+		instrumentTracking(textTrees(x.text))
+		instrumentTracking(htmlTrees(x.shared))
+	}
+	if x.sandbox != nil {
+		x.sandbox.instrument(textTrees(x.text))
+		x.sandbox.instrument(htmlTrees(x.shared))
+	}
+
+	// only recompile templates with a changed file in their layout chain,
+	// or invoking a template defined in a changed file
+	recompile := x.affected(changed)
 
 	// then, parse all templates again but with inheritance
-	for name, tf := range files {
+	names := sortedNames(recompile)
+	registers := make([]func(), len(names))
+	errs := make([]error, len(names))
+	parallel(len(names), x.workers, func(i int) {
+		if errs[i] = ctx.Err(); errs[i] != nil {
+			return
+		}
 
-		// if this is a non-child template, no need to re-parse
-		if tf.layout == "" {
-			x.templates[name] = x.shared.Lookup(name)
+		// child templates are compiled on first use in lazy mode
+		if x.lazy && x.files[names[i]].layout != "" {
+			return
+		}
+
+		registers[i], errs[i] = x.compile(names[i])
+	})
+
+	if len(names) > 0 {
+		purge(x.fragments)
+		purge(x.responses)
+	}
+
+	for i, name := range names {
+		if errs[i] != nil {
+			x.log(levelWarn, "extemplate: failed to parse template", "template", name, "error", errs[i])
+			return errs[i]
+		}
+
+		delete(x.pools, name)
+		if registers[i] != nil {
+			registers[i]()
+		}
+	}
+
+	return nil
+}
+
+// dependsOn reports whether the template with the given name, or any template in its layout chain, is in names.
+// The caller must hold x.mu.
+func (x *Extemplate) dependsOn(name string, names map[string]bool) bool {
+	for i := 0; i <= len(x.files); i++ {
+		if names[name] {
+			return true
+		}
+
+		tf, ok := x.files[name]
+		if !ok || tf.layout == "" {
+			return false
+		}
+		name = tf.layout
+	}
+
+	// layout chain contains a cycle
+	return false
+}
+
+func sortedNames(m map[string]bool) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// newSet returns a new template with the given name, associated with all shared templates.
+// Instead of cloning the shared set, the parse trees of shared templates are shared between sets to save memory.
+// This is safe because these templates are never executed: html/template only rewrites
+// the (deep copied) trees of the executable copies in the pool.
+func (x *Extemplate) newSet(name string) *template.Template {
+	t := template.New(name).Delims(x.leftDelim, x.rightDelim).Funcs(x.funcs).Funcs(x.funcsFor(name))
+	for _, st := range x.shared.Templates() {
+		if st.Tree == nil || st.Name() == "" {
 			continue
 		}
+		template.Must(t.AddParseTree(st.Name(), st.Tree))
+	}
+	return t
+}
 
-		tmpl := template.Must(x.shared.Clone()).New(name)
+// newTextSet is like newSet, but for text templates
+func (x *Extemplate) newTextSet(name string) *texttemplate.Template {
+	t := texttemplate.New(name).Delims(x.leftDelim, x.rightDelim).Funcs(texttemplate.FuncMap(x.funcs)).Funcs(texttemplate.FuncMap(x.funcsFor(name)))
+	for _, st := range x.text.Templates() {
+		if st.Tree == nil || st.Name() == "" {
+			continue
+		}
+		texttemplate.Must(t.AddParseTree(st.Name(), st.Tree))
+	}
+	return t
+}
+
+// parallel calls fn for every i in [0, n) using the given number of goroutines
+func parallel(n int, workers int, fn func(i int)) {
+	var wg sync.WaitGroup
+	jobs := make(chan int)
+	for w := 0; w < workers && w < n; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				fn(i)
+			}
+		}()
+	}
+
+	for i := 0; i < n; i++ {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+}
 
-		// add to set under normalized name (path from root)
-		x.templates[name] = tmpl
+// compile parses the template with the given name together with its layout chain.
+// It returns a func registering the compiled template in the set, so that templates can be compiled concurrently.
+// The caller must hold x.mu, and must hold it for writing when calling the returned func.
+func (x *Extemplate) compile(name string) (func(), error) {
+	start := time.Now()
+	register, err := x.compileSet(name)
+	if err != nil {
+		return nil, err
+	}
 
-		// parse parent templates
-		templateFiles := []string{name}
-		pname := tf.layout
-		parent, parentExists := files[pname]
-		for parentExists {
-			templateFiles = append(templateFiles, pname)
-			pname = parent.layout
-			parent, parentExists = files[pname]
+	d := time.Since(start)
+	return func() {
+		register()
+		x.files[name].compileTime = d
+	}, nil
+}
+
+// compileSet is like compile, but does not record how long compiling took
+func (x *Extemplate) compileSet(name string) (func(), error) {
+	tf := x.files[name]
+
+	// if this is a non-child template, no need to re-parse
+	// templates with scoped funcs need a set of their own though, see FuncsFor
+	if tf.layout == "" && x.funcsFor(name) == nil {
+		return func() {
+			if x.isText(tf) {
+				x.texts[name] = x.text.Lookup(name)
+				x.pools[name] = newTextPool(x.texts[name])
+			} else {
+				x.templates[name] = x.shared.Lookup(name)
+				x.pools[name] = newPool(x.templates[name])
+			}
+		}, nil
+	}
+
+	// parse parent templates
+	templateFiles := []string{name}
+	seen := map[string]bool{name: true}
+	var missing error
+	for pname := tf.layout; pname != ""; pname = x.files[pname].layout {
+		if _, ok := x.files[pname]; !ok {
+			suggestion := didYouMean(pname, sortedFileNames(x.files))
+			missing = &ParseError{Name: name, Err: fmt.Errorf("%w: %s extends %q%s", ErrLayoutNotFound, templateFiles[len(templateFiles)-1], pname, suggestion)}
+			break
+		}
+		if seen[pname] {
+			return nil, &ParseError{Name: name, Err: fmt.Errorf("%w: %s extends %q", ErrCycle, templateFiles[len(templateFiles)-1], pname)}
 		}
+		seen[pname] = true
+		templateFiles = append(templateFiles, pname)
+	}
 
-		// parse template files in reverse order (because childs should override parents)
-		for j := len(templateFiles) - 1; j >= 0; j-- {
-			b = files[templateFiles[j]].contents
-			_, err = tmpl.Parse(string(b))
-			if err != nil {
-				return err
+	// add to set under normalized name (path from root)
+	var parseFile func(file *templatefile) error
+	var register func()
+	var instrument func(file *templatefile)
+	var trees func() []*parse.Tree
+	if x.isText(tf) {
+		t := x.newTextSet(name)
+		parseFile = func(file *templatefile) error {
+			if !x.ownDelims(file) {
+				return x.addTrees(name, file, func(name string, tree *parse.Tree) error { _, err := t.AddParseTree(name, tree); return err })
 			}
+			_, err := t.Parse(string(file.contents))
+			return err
+		}
+		register = func() {
+			x.texts[name] = t
+			x.pools[name] = newTextPool(t)
+		}
+		instrument = func(file *templatefile) { x.coverage.instrument(file, name, textTrees(t)) }
+		trees = func() []*parse.Tree { return textTrees(t) }
+	} else {
+		t := x.newSet(name)
+		parseFile = func(file *templatefile) error {
+			if !x.ownDelims(file) {
+				return x.addTrees(name, file, func(name string, tree *parse.Tree) error { _, err := t.AddParseTree(name, tree); return err })
+			}
+			_, err := t.Parse(string(file.contents))
+			return err
+		}
+		register = func() {
+			x.templates[name] = t
+			x.pools[name] = newPool(t)
+		}
+		instrument = func(file *templatefile) { x.coverage.instrument(file, name, htmlTrees(t)) }
+		trees = func() []*parse.Tree { return htmlTrees(t) }
+	}
+
+	// parse template files in reverse order (because childs should override parents)
+	for j := len(templateFiles) - 1; j >= 0; j-- {
+		if err := parseFile(x.files[templateFiles[j]]); err != nil {
+			return nil, &ParseError{Name: name, Err: err}
 		}
 
+		// trees parsed from earlier files in the chain are instrumented already
+		if x.coverage != nil {
+			instrument(x.files[templateFiles[j]])
+		}
+	}
+	if x.tracking {
+		instrumentTracking(trees())
+	}
+	if x.sandbox != nil {
+		x.sandbox.instrument(trees())
 	}
 
-	return nil
+	// the layout may still be added, so only fail executing the template
+	if missing != nil {
+		return func() {
+			delete(x.templates, name)
+			delete(x.texts, name)
+			x.pools[name] = errPool(missing)
+		}, nil
+	}
+	return register, nil
 }
 
-func findTemplateFiles(root string, extensions []string) (map[string]*templatefile, error) {
-	var files = map[string]*templatefile{}
-	var exts = map[string]bool{}
+// nameOf returns the template name for the file at the given path
+func (x *Extemplate) nameOf(path string) string {
+	if x.nameFunc != nil {
+		path = x.nameFunc(path)
+	}
+	return x.normalize(path)
+}
 
-	root = filepath.Clean(root)
+// normalize returns the name under which a template with the given name is registered
+func (x *Extemplate) normalize(name string) string {
+	if x.foldCase {
+		return strings.ToLower(name)
+	}
+	return name
+}
 
-	// convert os speficic path into forward slashes
-	root = filepath.ToSlash(root)
+// isText reports whether the given file should be parsed using text/template
+func (x *Extemplate) isText(tf *templatefile) bool {
+	for ext := range x.textExts {
+		if hasExtension(tf.path, ext, x.strictExts) {
+			return true
+		}
+	}
+	return false
+}
 
-	// ensure root path has trailing separator
-	root = strings.TrimSuffix(root, "/") + "/"
+// hasExtension reports whether path ends in the extension ext.
+// Unless strict, extensions are matched case-insensitively and ext may be given without its leading dot,
+// which also allows matching extensions containing dots, like ".html.tmpl".
+func hasExtension(path string, ext string, strict bool) bool {
+	if strict {
+		return filepath.Ext(path) == ext
+	}
 
-	// create map of allowed extensions
-	for _, e := range extensions {
-		exts[e] = true
+	if !strings.HasPrefix(ext, ".") {
+		ext = "." + ext
 	}
+	return len(path) >= len(ext) && strings.EqualFold(path[len(path)-len(ext):], ext)
+}
+
+func (x *Extemplate) findTemplateFiles(ctx context.Context, fsys fs.FS, extensions []string) (map[string]*templatefile, error) {
+	var paths []string
 
 	// find all template files
-	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
-		// skip dirs as they can never be valid templates
-		if info == nil || info.IsDir() {
-			return nil
+	err := x.walk(ctx, fsys, ".", nil, func(path string) {
+		// skip if extension not in list of allowed extensions
+		for _, ext := range extensions {
+			if hasExtension(path, ext, x.strictExts) {
+				paths = append(paths, path)
+				return
+			}
 		}
+	})
+	if err != nil {
+		return nil, err
+	}
 
-		// skip if extension not in list of allowed extensions
-		e := filepath.Ext(path)
-		if _, ok := exts[e]; !ok {
-			return nil
+	return x.loadFiles(ctx, paths, func(path string) (*templatefile, error) {
+		return x.loadFile(fsys, path)
+	})
+}
+
+// loadFiles calls load for all paths concurrently, returning the loaded files by template name.
+// Files for which load returns SkipFile are left out.
+func (x *Extemplate) loadFiles(ctx context.Context, paths []string, load func(path string) (*templatefile, error)) (map[string]*templatefile, error) {
+	files := make(map[string]*templatefile, len(paths))
+	tfs := make([]*templatefile, len(paths))
+	errs := make([]error, len(paths))
+	parallel(len(paths), x.workers, func(i int) {
+		if errs[i] = ctx.Err(); errs[i] != nil {
+			return
+		}
+
+		tfs[i], errs[i] = load(paths[i])
+	})
+
+	// paths are in lexical order, so the first error is deterministic
+	for i, path := range paths {
+		if errs[i] == SkipFile {
+			continue
+		}
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+
+		if err := x.addFile(files, x.nameOf(path), tfs[i]); err != nil {
+			return nil, err
 		}
+	}
 
-		path = filepath.ToSlash(path)
-		name := strings.TrimPrefix(path, root)
+	return files, nil
+}
 
-		// read file into memory
-		contents, err := ioutil.ReadFile(path)
-		if err != nil {
+// loadFile reads the file at path from fsys and prepares it for parsing
+func (x *Extemplate) loadFile(fsys fs.FS, path string) (*templatefile, error) {
+	contents, err := x.readFile(fsys, path)
+	if err != nil {
+		return nil, err
+	}
+
+	tf, err := x.prepareFile(path, contents)
+	if err != nil {
+		return nil, err
+	}
+
+	if info, err := fs.Stat(fsys, path); err == nil {
+		tf.modTime = info.ModTime()
+	}
+	tf.source = sourceOf(fsys)
+	return tf, nil
+}
+
+// prepareFile runs the parse hooks on the contents of the file at path and processes its directives
+func (x *Extemplate) prepareFile(path string, contents []byte) (*templatefile, error) {
+	var err error
+	for _, hook := range x.parseHooks {
+		if contents, err = hook(path, contents); err != nil {
+			return nil, err
+		}
+	}
+
+	return x.newTemplateFile(path, x.nameOf(path), contents)
+}
+
+// walk calls fn for every file in dir and its subdirectories, in lexical order.
+// Symbolic links are handled according to x.symlinks, ancestors are used to detect symlink loops.
+func (x *Extemplate) walk(ctx context.Context, fsys fs.FS, dir string, ancestors []fs.FileInfo, fn func(path string)) error {
+	// skip unreadable directories as they can never contain valid templates
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil
+	}
+
+	for _, d := range entries {
+		if err := ctx.Err(); err != nil {
 			return err
 		}
 
-		tf, err := newTemplateFile(contents)
+		path := d.Name()
+		if dir != "." {
+			path = dir + "/" + path
+		}
+
+		isDir := d.IsDir()
+		if d.Type()&fs.ModeSymlink != 0 {
+			if x.symlinks == SymlinkNone {
+				continue
+			}
+
+			info, err := fs.Stat(fsys, path)
+			if err != nil {
+				continue
+			}
+			isDir = info.IsDir()
+			if isDir && x.symlinks != SymlinkAll {
+				continue
+			}
+		}
+
+		if !isDir {
+			fn(path)
+			continue
+		}
+
+		info, err := fs.Stat(fsys, path)
 		if err != nil {
+			continue
+		}
+		if isLoop(info, ancestors) {
+			return fmt.Errorf("extemplate: symlink loop at %q", path)
+		}
+		if err := x.walk(ctx, fsys, path, append(ancestors, info), fn); err != nil {
 			return err
 		}
+	}
 
-		files[name] = tf
-		return nil
-	})
+	return nil
+}
+
+// isLoop reports whether dir is the same directory as one of its ancestors.
+// For file systems not backed by the operating system, a maximum depth is used instead.
+func isLoop(dir fs.FileInfo, ancestors []fs.FileInfo) bool {
+	if len(ancestors) >= maxWalkDepth {
+		return true
+	}
 
-	return files, err
+	for _, a := range ancestors {
+		if os.SameFile(a, dir) {
+			return true
+		}
+	}
+	return false
 }
 
-// newTemplateFile parses the file contents into something that text/template can understand
-func newTemplateFile(c []byte) (*templatefile, error) {
+// readFile reads the named file from fsys, enforcing the configured maximum file size
+func (x *Extemplate) readFile(fsys fs.FS, name string) ([]byte, error) {
+	if x.maxFileSize <= 0 {
+		return fs.ReadFile(fsys, name)
+	}
+
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	// read one byte more than allowed, so we can tell whether the file exceeds the limit
+	contents, err := ioutil.ReadAll(io.LimitReader(f, x.maxFileSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(contents)) > x.maxFileSize {
+		return nil, fmt.Errorf("extemplate: %s exceeds maximum file size of %d bytes", name, x.maxFileSize)
+	}
+	return contents, nil
+}
+
+// newTemplateFile parses the file contents into something that text/template can understand.
+// Leading lines consisting of a single registered directive are handled and stripped from the contents.
+// Directives may be preceded by blank lines and comments, like a license header.
+func (x *Extemplate) newTemplateFile(path string, name string, c []byte) (*templatefile, error) {
+	// editors on Windows may start files with a byte order mark, which would hide leading directives
+	c = bytes.TrimPrefix(c, []byte("\xef\xbb\xbf"))
+
 	tf := &templatefile{
+		path:     path,
 		contents: c,
+		hash:     sha256.Sum256(c),
 	}
 
-	r := bytes.NewReader(tf.contents)
-	pos := 0
-	var line []byte
-	for {
-		ch, l, err := r.ReadRune()
-		pos += l
-
-		// read until first line or EOF
-		if ch == '\n' || err == io.EOF {
-			line = c[0:pos]
-			break
+	// files in a prefix registered using DelimsFor use its delimiters, for directives as well
+	tf.leftDelim, tf.rightDelim = x.leftDelim, x.rightDelim
+	directiveRegex, headerRegex := x.directiveRegex, x.headerRegex
+	for _, s := range x.delimScopes {
+		if strings.HasPrefix(name, s.prefix) {
+			tf.leftDelim, tf.rightDelim = s.left, s.right
+			directiveRegex, headerRegex = s.directiveRegex, s.headerRegex
 		}
 	}
 
-	if len(line) < 10 {
-		return tf, nil
+	f := &File{
+		Name: name,
+		Meta: make(map[string]interface{}),
 	}
 
-	// if we have a match, strip first line of content
-	if m := extendsRegex.FindSubmatch(line); m != nil {
-		tf.layout = filepath.ToSlash(string(m[1]))
-		tf.contents = c[len(line):]
+	// pos is the start of the current line, skipping over a leading header of blank lines and comments
+	pos := 0
+	for pos < len(tf.contents) {
+		// read until end of line or EOF
+		line := tf.contents[pos:]
+		if i := bytes.IndexByte(line, '\n'); i >= 0 {
+			line = line[:i+1]
+		}
+
+		d, rawArgs := x.matchDirective(line, directiveRegex)
+		if d == nil {
+			h := headerRegex.Find(tf.contents[pos:])
+			if h == nil {
+				break
+			}
+			pos += len(h)
+			continue
+		}
+
+		args, err := splitArgs(rawArgs)
+		if err != nil {
+			return nil, fmt.Errorf("extemplate: %s: %s", name, err)
+		}
+		if err := d(f, args); err != nil {
+			return nil, err
+		}
+
+		// strip directive line from content, keeping the header before it
+		tf.contents = append(tf.contents[:pos:pos], tf.contents[pos+len(line):]...)
+	}
+
+	var err error
+	if tf.contents, err = x.parseFrontMatter(f, tf.contents); err != nil {
+		return nil, err
 	}
 
+	tf.offset = bytes.Count(c, []byte("\n")) - bytes.Count(tf.contents, []byte("\n"))
+	tf.layout = f.Layout
+	if tf.layout != "" {
+		tf.layout = x.aliased(x.nameOf(tf.layout))
+	}
+	if len(f.Meta) > 0 {
+		tf.meta = f.Meta
+	}
 	return tf, nil
 }
@@ -2,10 +2,29 @@ package extemplate
 
 import (
 	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"context"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"html/template"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
 	"strings"
 	"sync"
 	"testing"
+	"testing/fstest"
+	"time"
 )
 
 var x *Extemplate
@@ -21,6 +40,18 @@ func setup() {
 	}
 }
 
+// parseExamples parses the examples directory into x, failing the test on error
+func parseExamples(t *testing.T, x *Extemplate) *Extemplate {
+	t.Helper()
+	x.Funcs(template.FuncMap{
+		"tolower": strings.ToLower,
+	})
+	if err := x.ParseDir("examples", []string{".tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+	return x
+}
+
 func TestLookup(t *testing.T) {
 	once.Do(setup)
 
@@ -31,6 +62,28 @@ func TestLookup(t *testing.T) {
 	if tmpl := x.Lookup("child.tmpl"); tmpl == nil {
 		t.Error("Lookup: expected template, got nil")
 	}
+	if x.Lookup("child.tmpl") != x.Lookup("child.tmpl") {
+		t.Error("Lookup: expected the same template for every call")
+	}
+
+	// copies can be modified without affecting x
+	tmpl := x.LookupCopy("child.tmpl")
+	if tmpl == nil || tmpl == x.Lookup("child.tmpl") {
+		t.Fatalf("LookupCopy: expected a copy, got %v", tmpl)
+	}
+	if _, err := tmpl.New("partials/question.tmpl").Parse("changed"); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := x.ExecuteTemplate(&buf, "child.tmpl", nil); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buf.String(), "changed") {
+		t.Errorf("Expected copy not to affect x, got %q", buf.String())
+	}
+	if x.LookupCopy("foobar") != nil || x.LookupTextCopy("child.tmpl") != nil {
+		t.Error("Expected nil copies for unexisting templates")
+	}
 }
 
 func TestExecuteTemplate(t *testing.T) {
@@ -78,24 +131,36 @@ func TestNewTemplateFile(t *testing.T) {
 	tests := map[string]string{
 		"{{ extends \"foo.html\" }}": "foo.html",
 		"Nothing":                    "",
-		"{{ extends \"dir/file.html\" }}\n {{ .Var }}": "dir/file.html",
+		"{{ extends \"dir/file.html\" }}\n {{ .Var }}":                   "dir/file.html",
+		"\xef\xbb\xbf{{ extends \"bom.html\" }}\n":                       "bom.html",
+		"{{ extends \"crlf.html\" }}\r\n{{ .Var }}":                      "crlf.html",
+		"  \t{{\textends \"indented.html\" }}\n":                         "indented.html",
+		"{{/* extends \"comment.html\" */}}\n":                           "comment.html",
+		"{{- /* extends \"trim.html\" */ -}}\n{{ .Var }}":                "trim.html",
+		"{{/*\n Copyright 2020\n*/}}\n\n{{ extends \"header.html\" }}\n": "header.html",
+		"{{/* License: MIT */}}\n{{/* extends \"license.html\" */}}":     "license.html",
+		"\n{{ .Var }}\n{{ extends \"late.html\" }}":                      "",
 	}
 
 	for c, e := range tests {
-		tf, err := newTemplateFile([]byte(c))
+		tf, err := New().newTemplateFile("test.tmpl", "test.tmpl", []byte(c))
 		if err != nil {
 			t.Error(err)
 		}
 		if tf.layout != e {
 			t.Errorf("Expected layout %s, got %s", e, tf.layout)
 		}
+		if e != "" && (bytes.Contains(tf.contents, []byte("extends")) || bytes.HasPrefix(tf.contents, []byte("\r"))) {
+			t.Errorf("Expected directive line to be stripped, got %q", tf.contents)
+		}
 	}
 }
 
 func BenchmarkExtemplateGetLayoutForTemplate(b *testing.B) {
 	c := []byte("{{ extends \"foo.html\" }}")
+	x := New()
 	for i := 0; i < b.N; i++ {
-		if _, err := newTemplateFile(c); err != nil {
+		if _, err := x.newTemplateFile("test.tmpl", "test.tmpl", c); err != nil {
 			b.Error(err)
 		}
 	}
@@ -109,3 +174,3393 @@ func BenchmarkExtemplateParseDir(b *testing.B) {
 		x.ParseDir("examples", []string{".tmpl"})
 	}
 }
+
+func TestCSRFField(t *testing.T) {
+	type key struct{}
+	x := New().SetCSRFProvider(func(ctx context.Context) template.HTML {
+		return template.HTML(`<input type="hidden" name="csrf" value="` + ctx.Value(key{}).(string) + `">`)
+	})
+	parseExamples(t, x)
+
+	var buf bytes.Buffer
+	ctx := context.WithValue(context.Background(), key{}, "token")
+	if err := x.ExecuteTemplateContext(ctx, &buf, "form.tmpl", nil); err != nil {
+		t.Fatal(err)
+	}
+	if e, a := `<form><input type="hidden" name="csrf" value="token"></form>`, strings.TrimSpace(buf.String()); e != a {
+		t.Errorf("Expected %q, got %q", e, a)
+	}
+}
+
+func TestExecuteTemplateLocale(t *testing.T) {
+	once.Do(setup)
+
+	tests := map[string]string{
+		"nl":    "Hallo van partials/question.nl.tmpl",
+		"nl-BE": "Hallo van partials/question.nl.tmpl",
+		"de":    "Hello from partials/question.tmpl",
+		"":      "Hello from partials/question.tmpl",
+	}
+
+	for locale, e := range tests {
+		var buf bytes.Buffer
+		if err := x.ExecuteTemplateLocale(&buf, "partials/question.tmpl", locale, nil); err != nil {
+			t.Fatal(err)
+		}
+		if a := strings.TrimSpace(buf.String()); a != e {
+			t.Errorf("locale %q: expected %q, got %q", locale, e, a)
+		}
+	}
+}
+
+func TestMinify(t *testing.T) {
+	tests := map[string]string{
+		"<p>\n\t\tHello   world\n</p>  <p>x</p>":                          "<p>\nHello world\n</p> <p>x</p>",
+		"<pre>  keep\n  this </pre>\n\n<p title=\"a  b\">  </p>":          "<pre>  keep\n  this </pre>\n<p title=\"a  b\"> </p>",
+		"<SCRIPT>\n var a = 1 > 0;\n</Script>   <textarea> a </textarea>": "<SCRIPT>\n var a = 1 > 0;\n</Script> <textarea> a </textarea>",
+	}
+
+	for in, e := range tests {
+		var buf bytes.Buffer
+
+		// write byte by byte to make sure state is kept between writes
+		w := newMinifyWriter(&buf)
+		for i := 0; i < len(in); i++ {
+			if _, err := w.Write([]byte{in[i]}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if a := buf.String(); a != e {
+			t.Errorf("Expected %q, got %q", e, a)
+		}
+	}
+
+	x := parseExamples(t, New(WithMinify()))
+	var buf bytes.Buffer
+	if err := x.ExecuteTemplate(&buf, "child.tmpl", nil); err != nil {
+		t.Fatal(err)
+	}
+	if e, a := "Hello from child.tmpl\nHello from partials/question.tmpl", buf.String(); a != e {
+		t.Errorf("Expected %q, got %q", e, a)
+	}
+}
+
+type upperWriter struct {
+	w io.Writer
+}
+
+func (u *upperWriter) Write(p []byte) (int, error) {
+	return u.w.Write(bytes.ToUpper(p))
+}
+
+func TestAddOutputFilter(t *testing.T) {
+	var names []string
+	x := parseExamples(t, New().AddOutputFilter(func(name string, w io.Writer) io.Writer {
+		names = append(names, name)
+		return &upperWriter{w}
+	}))
+
+	var buf bytes.Buffer
+	if err := x.ExecuteTemplate(&buf, "partials/question.tmpl", nil); err != nil {
+		t.Fatal(err)
+	}
+	if e, a := "HELLO FROM PARTIALS/QUESTION.TMPL", strings.TrimSpace(buf.String()); a != e {
+		t.Errorf("Expected %q, got %q", e, a)
+	}
+	if len(names) != 1 || names[0] != "partials/question.tmpl" {
+		t.Errorf("Expected filter to be called with template name, got %v", names)
+	}
+}
+
+// closeWriter buffers the output until closed
+type closeWriter struct {
+	w   io.Writer
+	buf bytes.Buffer
+}
+
+func (c *closeWriter) Write(p []byte) (int, error) {
+	return c.buf.Write(p)
+}
+
+func (c *closeWriter) Close() error {
+	_, err := c.buf.WriteTo(c.w)
+	return err
+}
+
+func TestOutputFilterError(t *testing.T) {
+	x := New().AddOutputFilter(func(name string, w io.Writer) io.Writer {
+		return &closeWriter{w: w}
+	})
+	if err := x.SetTemplate("page.tmpl", `partial output {{ index . 1 }}`); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := x.ExecuteTemplate(&buf, "page.tmpl", []int{1, 2}); err != nil {
+		t.Fatal(err)
+	}
+	if e, a := "partial output 2", buf.String(); a != e {
+		t.Errorf("Expected %q, got %q", e, a)
+	}
+
+	buf.Reset()
+	if err := x.ExecuteTemplate(&buf, "page.tmpl", nil); err == nil {
+		t.Fatal("Expected error, got none")
+	}
+	if buf.Len() > 0 {
+		t.Errorf("Expected buffered output to be dropped, got %q", buf.String())
+	}
+}
+
+func TestTrimBlocks(t *testing.T) {
+	tests := map[string]string{
+		"a\n{{ define \"x\" }}\n  b\n{{ end }}\nc":                                "a\n{{- define \"x\" -}}\n  b\n{{- end -}}\nc",
+		"{{block \"x\" .}}{{ if . }} y {{ end }}{{end}}":                          "{{- block \"x\" . -}}{{ if . }} y {{ end }}{{- end -}}",
+		"{{- define \"x\" -}} {{/* comment */}} {{ range . }}{{ end }} {{ end }}": "{{- define \"x\" -}} {{/* comment */}} {{ range . }}{{ end }} {{- end -}}",
+	}
+
+	for in, e := range tests {
+		if a := string(trimBlocks([]byte(in), "{{", "}}")); a != e {
+			t.Errorf("Expected %q, got %q", e, a)
+		}
+	}
+
+	x := parseExamples(t, New(WithTrimBlocks()))
+	var buf bytes.Buffer
+	if err := x.ExecuteTemplate(&buf, "grand-child.tmpl", nil); err != nil {
+		t.Fatal(err)
+	}
+	if e, a := "Hellofrom grand-child.tmpl", buf.String(); a != e {
+		t.Errorf("Expected %q, got %q", e, a)
+	}
+
+	// sections and components are closed by end actions too
+	in := "{{ define \"x\" }} {{ section \"s\" }} a {{ end }} {{ end }}"
+	if e, a := "{{- define \"x\" -}} {{ section \"s\" }} a {{ end }} {{- end -}}", string(trimBlocks([]byte(in), "{{", "}}", "section")); a != e {
+		t.Errorf("Expected %q, got %q", e, a)
+	}
+
+	x = New(WithTrimBlocks(), WithSections(), WithComponents("components/"))
+	if err := x.ParseFS(fstest.MapFS{
+		"base.tmpl":            {Data: []byte("<head>{{ yield \"scripts\" }}</head>\n{{ block \"content\" . }}\n{{ end }}\n")},
+		"page.tmpl":            {Data: []byte("{{ extends \"base.tmpl\" }}\n{{ define \"content\" }}\n{{ section \"scripts\" }}<script></script>{{ end }}\n{{ component \"card\" }}{{ fill \"title\" }}Hi{{ end }}{{ end }}\n{{ end }}\n")},
+		"components/card.tmpl": {Data: []byte(`<h2>{{ slot "title" }}{{ end }}</h2>`)},
+	}, []string{".tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	if err := x.ExecuteTemplate(&buf, "page.tmpl", nil); err != nil {
+		t.Fatal(err)
+	}
+	if e, a := "<head><script></script></head>\n<h2>Hi</h2>", buf.String(); a != e {
+		t.Errorf("Expected %q, got %q", e, a)
+	}
+}
+
+func TestTextExtensions(t *testing.T) {
+	x := New(WithTextExtensions(".txt")).Funcs(template.FuncMap{
+		"tolower": strings.ToLower,
+	})
+	if err := x.ParseDir("examples", []string{".tmpl", ".txt"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if x.Lookup("text/child.txt") != nil || x.LookupText("text/child.txt") == nil {
+		t.Error("Expected text/child.txt to be a text template")
+	}
+
+	var buf bytes.Buffer
+	if err := x.ExecuteTemplate(&buf, "text/child.txt", "<i>"); err != nil {
+		t.Fatal(err)
+	}
+	if e, a := "Plain <b><i></b>", strings.TrimSpace(buf.String()); a != e {
+		t.Errorf("Expected %q, got %q", e, a)
+	}
+}
+
+func TestLazyCompilation(t *testing.T) {
+	x := parseExamples(t, New(WithLazyCompilation()))
+
+	if _, ok := x.pools["child.tmpl"]; ok {
+		t.Error("Expected child.tmpl to not be compiled yet")
+	}
+
+	var buf bytes.Buffer
+	if err := x.ExecuteTemplate(&buf, "grand-child.tmpl", nil); err != nil {
+		t.Fatal(err)
+	}
+	if e, a := "Hello from grand-child.tmpl", strings.TrimSpace(buf.String()); a != e {
+		t.Errorf("Expected %q, got %q", e, a)
+	}
+	if _, ok := x.pools["grand-child.tmpl"]; !ok {
+		t.Error("Expected grand-child.tmpl to be compiled")
+	}
+	if x.Lookup("child.tmpl") == nil {
+		t.Error("Lookup: expected template, got nil")
+	}
+}
+
+func TestParseDirErrorIsDeterministic(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.tmpl", "b.tmpl", "c.tmpl"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(`{{ extends "base.tmpl" }}`+"\n{{ if }}"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < 10; i++ {
+		err := New(WithWorkers(3)).ParseDir(dir, []string{".tmpl"})
+		if err == nil || !strings.Contains(err.Error(), "a.tmpl") {
+			t.Fatalf("Expected error for a.tmpl, got %v", err)
+		}
+	}
+}
+
+func TestParseTreesAreShared(t *testing.T) {
+	x := parseExamples(t, New())
+
+	shared := x.shared.Lookup("partials/question.tmpl").Tree
+	if tree := x.templates["child.tmpl"].Lookup("partials/question.tmpl").Tree; tree != shared {
+		t.Error("Expected child.tmpl to share the parse tree of partials/question.tmpl")
+	}
+}
+
+func TestParseDirIncremental(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, contents string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("base.tmpl", `base {{ block "content" . }}{{ end }}`)
+	write("a.tmpl", "{{ extends \"base.tmpl\" }}\n{{ define \"content\" }}a{{ end }}")
+	write("b.tmpl", "{{ extends \"base.tmpl\" }}\n{{ define \"content\" }}b{{ end }}")
+
+	x := New()
+	if err := x.ParseDir(dir, []string{".tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+	a, b := x.templates["a.tmpl"], x.templates["b.tmpl"]
+
+	write("b.tmpl", "{{ extends \"base.tmpl\" }}\n{{ define \"content\" }}b2{{ end }}")
+	if err := x.ParseDir(dir, []string{".tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+	if x.templates["a.tmpl"] != a {
+		t.Error("Expected unchanged a.tmpl to not be recompiled")
+	}
+	if x.templates["b.tmpl"] == b {
+		t.Error("Expected changed b.tmpl to be recompiled")
+	}
+
+	var buf bytes.Buffer
+	if err := x.ExecuteTemplate(&buf, "b.tmpl", nil); err != nil {
+		t.Fatal(err)
+	}
+	if e, a := "base b2", buf.String(); a != e {
+		t.Errorf("Expected %q, got %q", e, a)
+	}
+}
+
+func TestParseFSContext(t *testing.T) {
+	fsys := fstest.MapFS{
+		"base.tmpl":       {Data: []byte(`base {{ block "content" . }}{{ end }}`)},
+		"pages/page.tmpl": {Data: []byte("{{ extends \"base.tmpl\" }}\n{{ define \"content\" }}page{{ end }}")},
+	}
+
+	x := New()
+	if err := x.ParseFS(fsys, []string{".tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := x.ExecuteTemplate(&buf, "pages/page.tmpl", nil); err != nil {
+		t.Fatal(err)
+	}
+	if e, a := "base page", buf.String(); a != e {
+		t.Errorf("Expected %q, got %q", e, a)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := New().ParseFSContext(ctx, fsys, []string{".tmpl"}); err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+}
+
+func TestDelimsDirectives(t *testing.T) {
+	fsys := fstest.MapFS{
+		"base.tmpl":    {Data: []byte(`base [[ block "content" . ]][[ end ]] {{ raw }}`)},
+		"action.tmpl":  {Data: []byte("[[ extends \"base.tmpl\" ]]\n[[ define \"content\" ]]action[[ end ]]")},
+		"comment.tmpl": {Data: []byte("[[/* license */]]\n[[/* extends \"base.tmpl\" */]]\n[[ define \"content\" ]]comment[[ end ]]")},
+	}
+
+	x := New().Delims("[[", "]]")
+	if err := x.ParseFS(fsys, []string{".tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"action", "comment"} {
+		var buf bytes.Buffer
+		if err := x.ExecuteTemplate(&buf, name+".tmpl", nil); err != nil {
+			t.Fatal(err)
+		}
+		if e, a := "base "+name+" {{ raw }}", strings.TrimSpace(buf.String()); a != e {
+			t.Errorf("Expected %q, got %q", e, a)
+		}
+	}
+}
+
+func TestDelimsFor(t *testing.T) {
+	fsys := fstest.MapFS{
+		"vendor/base.tmpl":    {Data: []byte(`base [[ block "content" . ]][[ end ]] [[ template "vendor/partial.tmpl" ]]`)},
+		"vendor/partial.tmpl": {Data: []byte(`[[ "partial" ]]`)},
+		"vendor/page.tmpl":    {Data: []byte("[[ extends \"vendor/base.tmpl\" ]]\n[[ define \"content\" ]]vendor {{ raw }}[[ end ]]")},
+		"page.tmpl":           {Data: []byte("{{ extends \"vendor/base.tmpl\" }}\n{{ define \"content\" }}page [[ raw ]]{{ end }}")},
+	}
+
+	x := New().DelimsFor("vendor/", "[[", "]]")
+	if err := x.ParseFS(fsys, []string{".tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]string{
+		"vendor/page.tmpl":    "base vendor {{ raw }} partial",
+		"vendor/partial.tmpl": "partial",
+		"page.tmpl":           "base page [[ raw ]] partial",
+	}
+	for name, e := range tests {
+		var buf bytes.Buffer
+		if err := x.ExecuteTemplate(&buf, name, nil); err != nil {
+			t.Fatal(err)
+		}
+		if a := buf.String(); a != e {
+			t.Errorf("%s: expected %q, got %q", name, e, a)
+		}
+	}
+
+	// sources parsed separately keep their delimiters when merged
+	vendor := New().Delims("[[", "]]")
+	if err := vendor.ParseFS(fstest.MapFS{"base.tmpl": fsys["vendor/base.tmpl"], "vendor/partial.tmpl": fsys["vendor/partial.tmpl"]}, []string{".tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+	y := New()
+	if err := y.Merge(vendor, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := y.SetTemplate("page.tmpl", "{{ extends \"base.tmpl\" }}\n{{ define \"content\" }}merged{{ end }}"); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := y.ExecuteTemplate(&buf, "page.tmpl", nil); err != nil {
+		t.Fatal(err)
+	}
+	if e, a := "base merged partial", buf.String(); a != e {
+		t.Errorf("Expected %q, got %q", e, a)
+	}
+}
+
+func TestFuncsFor(t *testing.T) {
+	fsys := fstest.MapFS{
+		"base.tmpl":          {Data: []byte(`base {{ block "content" . }}{{ end }}`)},
+		"admin/page.tmpl":    {Data: []byte("{{ extends \"base.tmpl\" }}\n{{ define \"content\" }}{{ greet }} {{ template \"admin/partial.tmpl\" }}{{ end }}")},
+		"admin/partial.tmpl": {Data: []byte(`{{ greet }}`)},
+		"blog/page.tmpl":     {Data: []byte(`{{ greet }}`)},
+	}
+
+	x := New().
+		FuncsFor("admin/", template.FuncMap{"greet": func() string { return "admin" }}).
+		FuncsFor("blog/", template.FuncMap{"greet": func() string { return "blog" }})
+	if err := x.ParseFS(fsys, []string{".tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]string{
+		"admin/page.tmpl":    "base admin admin",
+		"admin/partial.tmpl": "admin",
+		"blog/page.tmpl":     "blog",
+	}
+	for name, e := range tests {
+		var buf bytes.Buffer
+		if err := x.ExecuteTemplate(&buf, name, nil); err != nil {
+			t.Fatal(err)
+		}
+		if a := buf.String(); a != e {
+			t.Errorf("%s: expected %q, got %q", name, e, a)
+		}
+	}
+
+	fsys = fstest.MapFS{"index.tmpl": {Data: []byte(`{{ greet }}`)}}
+	if err := New().FuncsFor("admin/", template.FuncMap{"greet": strings.ToLower}).ParseFS(fsys, []string{".tmpl"}); err == nil {
+		t.Error("Expected error for scoped func used outside of its prefix, got none")
+	}
+}
+
+func TestSubset(t *testing.T) {
+	fsys := fstest.MapFS{
+		"layouts/email.tmpl":   {Data: []byte(`email {{ block "content" . }}{{ end }}`)},
+		"partials/footer.tmpl": {Data: []byte(`footer`)},
+		"emails/welcome.tmpl":  {Data: []byte("{{ extends \"layouts/email.tmpl\" }}\n{{ define \"content\" }}welcome {{ template \"partials/footer.tmpl\" }}{{ end }}")},
+		"admin/index.tmpl":     {Data: []byte(`admin`)},
+	}
+	x := New()
+	if err := x.ParseFS(fsys, []string{".tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+
+	emails, err := x.Subset("emails/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := emails.ExecuteTemplate(&buf, "emails/welcome.tmpl", nil); err != nil {
+		t.Fatal(err)
+	}
+	if e, a := "email welcome footer", buf.String(); a != e {
+		t.Errorf("Expected %q, got %q", e, a)
+	}
+
+	for _, name := range []string{"admin/index.tmpl", "layouts/email.tmpl", "partials/footer.tmpl"} {
+		if err := emails.ExecuteTemplate(io.Discard, name, nil); err == nil {
+			t.Errorf("Expected error executing %s outside of subset, got none", name)
+		}
+	}
+	if _, ok := emails.files["admin/index.tmpl"]; ok {
+		t.Error("Expected unreachable template outside of subset not to be copied")
+	}
+	if err := x.ExecuteTemplate(io.Discard, "admin/index.tmpl", nil); err != nil {
+		t.Errorf("Expected original set to be unaffected, got %v", err)
+	}
+}
+
+func TestGlob(t *testing.T) {
+	x := New()
+	if err := x.ParseFS(fstest.MapFS{
+		"widgets/a.tmpl":     {Data: []byte(`a{{ . }} `)},
+		"widgets/b.tmpl":     {Data: []byte(`b{{ . }} `)},
+		"widgets/sub/c.tmpl": {Data: []byte(`c`)},
+		"index.tmpl":         {Data: []byte(`index`)},
+	}, []string{".tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if e, a := []string{"widgets/a.tmpl", "widgets/b.tmpl"}, x.Glob("widgets/*.tmpl"); !reflect.DeepEqual(a, e) {
+		t.Errorf("Expected %v, got %v", e, a)
+	}
+	if a := x.Glob("widgets/["); a != nil {
+		t.Errorf("Expected no matches for malformed pattern, got %v", a)
+	}
+
+	var buf bytes.Buffer
+	if err := x.ExecuteGlob(&buf, "widgets/*.tmpl", 1); err != nil {
+		t.Fatal(err)
+	}
+	if e, a := "a1 b1 ", buf.String(); a != e {
+		t.Errorf("Expected %q, got %q", e, a)
+	}
+	if err := x.ExecuteGlob(&buf, "nothing/*", nil); err == nil {
+		t.Error("Expected error for pattern matching no templates, got none")
+	}
+	if err := x.ExecuteGlob(&buf, "widgets/[", nil); err != path.ErrBadPattern {
+		t.Errorf("Expected path.ErrBadPattern, got %v", err)
+	}
+}
+
+func TestWalk(t *testing.T) {
+	x := parseExamples(t, New(WithTextExtensions(".txt")))
+
+	var walked []string
+	err := x.Walk(func(name string, tmpl *template.Template, layout string) error {
+		walked = append(walked, name+":"+layout)
+		if (tmpl == nil) != strings.HasSuffix(name, ".txt") {
+			t.Errorf("%s: expected only text templates to be passed without template, got %v", name, tmpl)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := "child.tmpl:parent.tmpl"; walked[0] != e {
+		t.Errorf("Expected first walked template to be %q, got %q", e, walked[0])
+	}
+	if len(walked) != len(x.files) {
+		t.Errorf("Expected %d templates to be walked, got %d", len(x.files), len(walked))
+	}
+
+	stop := errors.New("stop")
+	n := 0
+	if err := x.Walk(func(string, *template.Template, string) error { n++; return stop }); err != stop || n != 1 {
+		t.Errorf("Expected Walk to stop with the returned error, got %v after %d calls", err, n)
+	}
+}
+
+func TestParseDirs(t *testing.T) {
+	app, module := t.TempDir(), t.TempDir()
+	files := map[string]string{
+		filepath.Join(module, "base.tmpl"):         `base {{ block "content" . }}{{ end }}`,
+		filepath.Join(module, "page.tmpl"):         "{{ extends \"base.tmpl\" }}\n{{ define \"content\" }}module{{ end }}",
+		filepath.Join(module, "widgets", "a.tmpl"): `module a`,
+		filepath.Join(app, "page.tmpl"):            "{{ extends \"base.tmpl\" }}\n{{ define \"content\" }}app{{ end }}",
+		filepath.Join(app, "widgets", "b.tmpl"):    `app b`,
+	}
+	for path, contents := range files {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	x := New()
+	if err := x.ParseDirs([]string{app, module}, []string{".tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]string{
+		"page.tmpl":      "base app",
+		"widgets/a.tmpl": "module a",
+		"widgets/b.tmpl": "app b",
+	}
+	for name, e := range tests {
+		var buf bytes.Buffer
+		if err := x.ExecuteTemplate(&buf, name, nil); err != nil {
+			t.Fatal(err)
+		}
+		if a := buf.String(); a != e {
+			t.Errorf("%s: expected %q, got %q", name, e, a)
+		}
+	}
+
+	// removing the overriding file falls back to the next root
+	if err := os.Remove(filepath.Join(app, "page.tmpl")); err != nil {
+		t.Fatal(err)
+	}
+	if err := x.ReloadFile("page.tmpl"); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := x.ExecuteTemplate(&buf, "page.tmpl", nil); err != nil {
+		t.Fatal(err)
+	}
+	if e, a := "base module", buf.String(); a != e {
+		t.Errorf("Expected %q after reload, got %q", e, a)
+	}
+}
+
+func TestExtensions(t *testing.T) {
+	fsys := fstest.MapFS{
+		"a.tmpl":      {Data: []byte(`a`)},
+		"b.TMPL":      {Data: []byte(`b`)},
+		"c.Html":      {Data: []byte(`c`)},
+		"d.html.tmpl": {Data: []byte(`d`)},
+		"e.TXT":       {Data: []byte(`<e>`)},
+		"f.go":        {Data: []byte(`f`)},
+	}
+
+	tests := []struct {
+		x          *Extemplate
+		extensions []string
+		names      []string
+	}{
+		{New(WithTextExtensions(".txt")), []string{"tmpl", ".html", "TXT"}, []string{"a.tmpl", "b.TMPL", "c.Html", "d.html.tmpl", "e.TXT"}},
+		{New(WithStrictExtensions()), []string{".tmpl", "html"}, []string{"a.tmpl", "d.html.tmpl"}},
+	}
+	for _, test := range tests {
+		if err := test.x.ParseFS(fsys, test.extensions); err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for name := range test.x.files {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		if !reflect.DeepEqual(names, test.names) {
+			t.Errorf("Expected templates %v for extensions %v, got %v", test.names, test.extensions, names)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := tests[0].x.ExecuteTemplate(&buf, "e.TXT", nil); err != nil {
+		t.Fatal(err)
+	}
+	if e, a := "<e>", buf.String(); a != e {
+		t.Errorf("Expected text template output %q, got %q", e, a)
+	}
+}
+
+func TestWalkLimits(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "big.tmpl"), bytes.Repeat([]byte("a"), 100), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := New(WithMaxFileSize(99)).ParseDir(dir, []string{".tmpl"}); err == nil {
+		t.Error("Expected error for file exceeding maximum size, got none")
+	}
+	if err := New(WithMaxFileSize(100)).ParseDir(dir, []string{".tmpl"}); err != nil {
+		t.Error(err)
+	}
+
+	if err := os.Symlink(dir, filepath.Join(dir, "loop")); err != nil {
+		t.Skip("symlinks not supported")
+	}
+	if err := New(WithSymlinkPolicy(SymlinkAll)).ParseDir(dir, []string{".tmpl"}); err == nil {
+		t.Error("Expected error for symlink loop, got none")
+	}
+	if err := New().ParseDir(dir, []string{".tmpl"}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestParseHook(t *testing.T) {
+	x := parseExamples(t, New(WithParseHook(func(name string, contents []byte) ([]byte, error) {
+		if name == "form.tmpl" {
+			return nil, SkipFile
+		}
+		return bytes.Replace(contents, []byte("Hello"), []byte("Hi"), -1), nil
+	})))
+
+	if x.Lookup("form.tmpl") != nil {
+		t.Error("Expected form.tmpl to be skipped")
+	}
+
+	var buf bytes.Buffer
+	if err := x.ExecuteTemplate(&buf, "partials/question.tmpl", nil); err != nil {
+		t.Fatal(err)
+	}
+	if e, a := "Hi from partials/question.tmpl", strings.TrimSpace(buf.String()); a != e {
+		t.Errorf("Expected %q, got %q", e, a)
+	}
+}
+
+func TestDirective(t *testing.T) {
+	x := New().Directive("meta", func(f *File, args []string) error {
+		for _, a := range args {
+			kv := strings.SplitN(a, "=", 2)
+			f.Meta[kv[0]] = kv[1]
+		}
+		return nil
+	})
+
+	c := "{{ extends \"base.tmpl\" }}\n{{ meta title=\"Hello world\" lang='en' }}\n{{ template \"x\" }}"
+	tf, err := x.newTemplateFile("page.tmpl", "page.tmpl", []byte(c))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tf.layout != "base.tmpl" {
+		t.Errorf("Expected layout base.tmpl, got %s", tf.layout)
+	}
+	if tf.meta["title"] != "Hello world" || tf.meta["lang"] != "en" {
+		t.Errorf("Expected meta to be set by directive, got %v", tf.meta)
+	}
+	if e, a := "{{ template \"x\" }}", string(tf.contents); a != e {
+		t.Errorf("Expected directives to be stripped, got %q", a)
+	}
+}
+
+func TestExtendsPattern(t *testing.T) {
+	x := New(WithExtendsPattern(regexp.MustCompile(`^<!-- layout: (.+) -->$`)))
+
+	tests := map[string]string{
+		"<!-- layout: base.tmpl -->\r\n<p>Hi</p>":   "base.tmpl",
+		"<!-- layout: 'quoted.tmpl' -->\n<p>Hi</p>": "quoted.tmpl",
+		"{{ extends \"action.tmpl\" }}\n<p>Hi</p>":  "action.tmpl",
+	}
+	for c, e := range tests {
+		tf, err := x.newTemplateFile("page.tmpl", "page.tmpl", []byte(c))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tf.layout != e {
+			t.Errorf("Expected layout %s, got %s", e, tf.layout)
+		}
+		if a := string(tf.contents); a != "<p>Hi</p>" {
+			t.Errorf("Expected directive to be stripped, got %q", a)
+		}
+	}
+}
+
+func TestFrontMatter(t *testing.T) {
+	x := parseExamples(t, New(WithFrontMatter("---", json.Unmarshal)))
+
+	meta := x.Meta("meta/page.tmpl")
+	if meta["title"] != "Page title" || meta["lang"] != "en" {
+		t.Errorf("Expected merged meta, got %v", meta)
+	}
+
+	var buf bytes.Buffer
+	if err := x.ExecuteTemplate(&buf, "meta/page.tmpl", nil); err != nil {
+		t.Fatal(err)
+	}
+	if e, a := "<title>Page title</title> en", strings.TrimSpace(buf.String()); a != e {
+		t.Errorf("Expected %q, got %q", e, a)
+	}
+}
+
+func TestTemplatesWithMeta(t *testing.T) {
+	x := parseExamples(t, New(WithFrontMatter("---", json.Unmarshal)))
+
+	names := x.TemplatesWithMeta("lang", "en")
+	if len(names) != 2 || names[0] != "meta/base.tmpl" || names[1] != "meta/page.tmpl" {
+		t.Errorf("Expected meta/base.tmpl and meta/page.tmpl, got %v", names)
+	}
+	if names := x.TemplatesWithMeta("title", "Page title"); len(names) != 1 {
+		t.Errorf("Expected 1 template, got %v", names)
+	}
+}
+
+func TestNameFunc(t *testing.T) {
+	x := parseExamples(t, New(WithNameFunc(func(path string) string {
+		return strings.ToUpper(strings.TrimSuffix(path, ".tmpl"))
+	})))
+
+	if x.Lookup("child.tmpl") != nil {
+		t.Error("Expected child.tmpl to be renamed")
+	}
+
+	var buf bytes.Buffer
+	if err := x.ExecuteTemplate(&buf, "GRAND-CHILD", nil); err != nil {
+		t.Fatal(err)
+	}
+	if e, a := "Hello from grand-child.tmpl", strings.TrimSpace(buf.String()); a != e {
+		t.Errorf("Expected %q, got %q", e, a)
+	}
+}
+
+func TestCaseInsensitiveLookup(t *testing.T) {
+	x := parseExamples(t, New(WithCaseInsensitiveLookup()))
+
+	if x.Lookup("Partials/Question.TMPL") == nil {
+		t.Error("Lookup: expected template, got nil")
+	}
+
+	var buf bytes.Buffer
+	if err := x.ExecuteTemplate(&buf, "Grand-Child.tmpl", nil); err != nil {
+		t.Fatal(err)
+	}
+	if e, a := "Hello from grand-child.tmpl", strings.TrimSpace(buf.String()); a != e {
+		t.Errorf("Expected %q, got %q", e, a)
+	}
+}
+
+func TestExecuteFirst(t *testing.T) {
+	once.Do(setup)
+
+	if tmpl := x.LookupFirst("errors/404.tmpl", "parent.tmpl"); tmpl == nil || tmpl.Name() != "parent.tmpl" {
+		t.Errorf("LookupFirst: expected parent.tmpl, got %v", tmpl)
+	}
+	if tmpl := x.LookupFirst("errors/404.tmpl"); tmpl != nil {
+		t.Errorf("LookupFirst: expected nil, got %v", tmpl)
+	}
+
+	var buf bytes.Buffer
+	if err := x.ExecuteFirst(&buf, []string{"errors/404.tmpl", "grand-child.tmpl", "parent.tmpl"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if e, a := "Hello from grand-child.tmpl", strings.TrimSpace(buf.String()); a != e {
+		t.Errorf("Expected %q, got %q", e, a)
+	}
+	if err := x.ExecuteFirst(&buf, []string{"errors/404.tmpl"}, nil); err == nil {
+		t.Error("ExecuteFirst: expected err for unexisting templates, got none")
+	}
+}
+
+func TestMerge(t *testing.T) {
+	a := New()
+	if err := a.ParseFS(fstest.MapFS{
+		"base.tmpl": {Data: []byte(`base {{ block "content" . }}{{ end }}`)},
+	}, []string{".tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+
+	b := New().Funcs(template.FuncMap{"upper": strings.ToUpper})
+	fsys := fstest.MapFS{
+		"base.tmpl": {Data: []byte(`other base`)},
+		"page.tmpl": {Data: []byte("{{ extends \"base.tmpl\" }}\n{{ define \"content\" }}{{ upper \"page\" }}{{ end }}")},
+	}
+	if err := b.ParseFS(fsys, []string{".tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := a.Merge(b, false); err == nil {
+		t.Error("Expected error for conflicting base.tmpl, got none")
+	}
+
+	delete(fsys, "base.tmpl")
+	b = New().Funcs(template.FuncMap{"upper": strings.ToUpper})
+	if err := b.ParseFS(fsys, []string{".tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Merge(b, false); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := a.ExecuteTemplate(&buf, "page.tmpl", nil); err != nil {
+		t.Fatal(err)
+	}
+	if e, a := "base PAGE", buf.String(); a != e {
+		t.Errorf("Expected %q, got %q", e, a)
+	}
+}
+
+func TestClone(t *testing.T) {
+	x := parseExamples(t, New())
+	c, err := x.Clone()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.Funcs(template.FuncMap{"tolower": strings.ToUpper})
+	if err := c.ParseFS(fstest.MapFS{
+		"partials/question.tmpl": {Data: []byte(`{{ tolower "overridden" }}`)},
+	}, []string{".tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[*Extemplate]string{
+		x: "Hello from partials/question.tmpl",
+		c: "OVERRIDDEN",
+	}
+	for set, e := range tests {
+		var buf bytes.Buffer
+		if err := set.ExecuteTemplate(&buf, "partials/question.tmpl", nil); err != nil {
+			t.Fatal(err)
+		}
+		if a := strings.TrimSpace(buf.String()); a != e {
+			t.Errorf("Expected %q, got %q", e, a)
+		}
+	}
+
+	// funcs depending on the configuration use that of the clone
+	x.SetURLResolver(func(name string, params ...interface{}) (string, error) { return "/x", nil })
+	if err := x.ParseFS(fstest.MapFS{"link.tmpl": {Data: []byte(`{{ url "home" }}`)}}, []string{".tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+	c, err = x.Clone()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetURLResolver(func(name string, params ...interface{}) (string, error) { return "/c", nil })
+	tests = map[*Extemplate]string{x: "/x", c: "/c"}
+	for set, e := range tests {
+		var buf bytes.Buffer
+		if err := set.ExecuteTemplate(&buf, "link.tmpl", nil); err != nil {
+			t.Fatal(err)
+		}
+		if a := buf.String(); a != e {
+			t.Errorf("Expected %q, got %q", e, a)
+		}
+	}
+}
+
+func TestAddFileAndRemove(t *testing.T) {
+	fsys := fstest.MapFS{
+		"base.tmpl":    {Data: []byte(`base {{ block "content" . }}{{ end }} {{ template "partial.tmpl" }}`)},
+		"child.tmpl":   {Data: []byte("{{ extends \"base.tmpl\" }}\n{{ define \"content\" }}child{{ end }}")},
+		"partial.tmpl": {Data: []byte(`partial`)},
+		"page.tmpl":    {Data: []byte(`page`)},
+	}
+
+	x := New()
+	if err := x.ParseFS(fsys, []string{".tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+
+	fsys["new.tmpl"] = &fstest.MapFile{Data: []byte("{{ extends \"child.tmpl\" }}\n{{ define \"content\" }}new{{ end }}")}
+	if err := x.AddFile(fsys, "new.tmpl"); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := x.ExecuteTemplate(&buf, "new.tmpl", nil); err != nil {
+		t.Fatal(err)
+	}
+	if e, a := "base new partial", buf.String(); a != e {
+		t.Errorf("Expected %q, got %q", e, a)
+	}
+
+	if err := x.Remove("page.tmpl"); err != nil {
+		t.Fatal(err)
+	}
+	if x.Lookup("page.tmpl") != nil {
+		t.Error("Expected page.tmpl to be removed")
+	}
+
+	if err := x.Remove("partial.tmpl"); err != nil {
+		t.Fatal(err)
+	}
+	if err := x.ExecuteTemplate(&buf, "new.tmpl", nil); err == nil {
+		t.Error("Expected error executing template using removed partial, got none")
+	}
+
+	if err := x.Remove("foo.tmpl"); err == nil {
+		t.Error("Expected error removing unexisting template, got none")
+	}
+
+	// templates extending a removed template that extends another are recompiled without it
+	if err := x.Remove("child.tmpl"); err != nil {
+		t.Fatal(err)
+	}
+	if err := x.ExecuteTemplate(io.Discard, "new.tmpl", nil); !errors.Is(err, ErrLayoutNotFound) {
+		t.Errorf("Expected ErrLayoutNotFound executing template extending removed template, got %v", err)
+	}
+}
+
+func TestReloadFile(t *testing.T) {
+	fsys := fstest.MapFS{
+		"base.tmpl":     {Data: []byte(`base {{ block "content" . }}{{ end }}`)},
+		"child.tmpl":    {Data: []byte("{{ extends \"base.tmpl\" }}\n{{ define \"content\" }}{{ template \"partial\" }}{{ end }}")},
+		"partials.tmpl": {Data: []byte(`{{ define "partial" }}partial{{ end }}`)},
+		"other.tmpl":    {Data: []byte(`other`)},
+	}
+
+	x := New()
+	if err := x.ParseFS(fsys, []string{".tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+	other := x.templates["other.tmpl"]
+
+	fsys["partials.tmpl"] = &fstest.MapFile{Data: []byte(`{{ define "partial" }}reloaded{{ end }}`)}
+	if err := x.ReloadFile("partials.tmpl"); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := x.ExecuteTemplate(&buf, "child.tmpl", nil); err != nil {
+		t.Fatal(err)
+	}
+	if e, a := "base reloaded", buf.String(); a != e {
+		t.Errorf("Expected %q, got %q", e, a)
+	}
+	if x.templates["other.tmpl"] != other {
+		t.Error("Expected other.tmpl not to be recompiled")
+	}
+
+	delete(fsys, "other.tmpl")
+	if err := x.ReloadFile("other.tmpl"); err != nil {
+		t.Fatal(err)
+	}
+	if x.Lookup("other.tmpl") != nil {
+		t.Error("Expected other.tmpl to be removed")
+	}
+}
+
+func TestHandler(t *testing.T) {
+	x := New()
+	if err := x.ParseFS(fstest.MapFS{
+		"index.tmpl":        {Data: []byte(`index`)},
+		"blog/index.tmpl":   {Data: []byte(`blog`)},
+		"about.tmpl":        {Data: []byte(`about {{ . }}`)},
+		"broken.tmpl":       {Data: []byte(`{{ .Foo.Bar }}`)},
+		"404.tmpl":          {Data: []byte(`not found`)},
+		"500.tmpl":          {Data: []byte(`error: {{ . }}`)},
+		"layouts/base.tmpl": {Data: []byte(`base {{ block "content" . }}{{ end }} {{ template "partials/nav.tmpl" }}`)},
+		"partials/nav.tmpl": {Data: []byte(`nav`)},
+		"page.tmpl":         {Data: []byte("{{ extends \"layouts/base.tmpl\" }}\n{{ define \"content\" }}page{{ end }}")},
+	}, []string{".tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+
+	h := Handler(x, WithDataProvider(func(r *http.Request, name string) (interface{}, error) {
+		if r.URL.Query().Get("fail") != "" {
+			return nil, errors.New("oops")
+		}
+		return name, nil
+	}))
+
+	tests := []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/", 200, "index"},
+		{"/blog/", 200, "blog"},
+		{"/about", 200, "about about.tmpl"},
+		{"/about?fail=1", 500, "error: oops"},
+		{"/404", 404, "not found"},
+		{"/foo", 404, "not found"},
+		{"/../../etc/passwd", 404, "not found"},
+		{"/broken", 500, "error: template: broken.tmpl"},
+		{"/page", 200, "base page nav"},
+		{"/layouts/base", 404, "not found"},
+		{"/partials/nav", 404, "not found"},
+	}
+
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", test.path, nil))
+		if rec.Code != test.status {
+			t.Errorf("%s: expected status %d, got %d", test.path, test.status, rec.Code)
+		}
+		if !strings.HasPrefix(rec.Body.String(), test.body) {
+			t.Errorf("%s: expected body %q, got %q", test.path, test.body, rec.Body.String())
+		}
+	}
+}
+
+func TestErrorTemplate(t *testing.T) {
+	x := New().SetErrorTemplate("error.tmpl")
+	if err := x.ParseFS(fstest.MapFS{
+		"error.tmpl":   {Data: []byte(`error in {{ .Name }}{{ with .Err }}: {{ . }}{{ end }}`)},
+		"broken.tmpl":  {Data: []byte(`partial output {{ .Foo }}`)},
+		"failing.tmpl": {Data: []byte(`partial output {{ index . 1 }}`)},
+	}, []string{".tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the error template is written, and the original error returned
+	var buf bytes.Buffer
+	err := x.ExecuteTemplate(&buf, "broken.tmpl", "data")
+	var ee *ExecError
+	if !errors.As(err, &ee) || !ee.Fallback || ee.Name != "broken.tmpl" {
+		t.Errorf("Expected *ExecError with Fallback set, got %#v", err)
+	}
+	if e, a := "error in broken.tmpl", buf.String(); a != e {
+		t.Errorf("Expected %q, got %q", e, a)
+	}
+
+	buf.Reset()
+	x.ShowErrorDetails(true)
+	if err := x.ExecuteTemplate(&buf, "broken.tmpl", "data"); err == nil {
+		t.Error("Expected error, got none")
+	}
+	if e, a := "error in broken.tmpl: template: broken.tmpl", buf.String(); !strings.HasPrefix(a, e) {
+		t.Errorf("Expected output starting with %q, got %q", e, a)
+	}
+
+	if err := x.ExecuteTemplate(io.Discard, "foo.tmpl", nil); !errors.Is(err, ErrTemplateNotFound) {
+		t.Errorf("Expected ErrTemplateNotFound, got %v", err)
+	}
+
+	// Render writes the error template with status 500
+	rec := httptest.NewRecorder()
+	if err := x.Render(rec, http.StatusOK, "broken.tmpl", "data"); err == nil {
+		t.Error("Expected error, got none")
+	}
+	if rec.Code != http.StatusInternalServerError || !strings.HasPrefix(rec.Body.String(), "error in broken.tmpl") {
+		t.Errorf("Expected status 500 with error template, got %d: %q", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	Handler(x).ServeHTTP(rec, httptest.NewRequest("GET", "/failing", nil))
+	if rec.Code != http.StatusInternalServerError || !strings.HasPrefix(rec.Body.String(), "error in failing.tmpl") {
+		t.Errorf("Expected status 500 with error template, got %d: %q", rec.Code, rec.Body.String())
+	}
+
+	// as do the other helpers writing responses
+	r := httptest.NewRequest("GET", "/", nil)
+	helpers := map[string]func(w http.ResponseWriter) error{
+		"RenderCompressed": func(w http.ResponseWriter) error {
+			return x.RenderCompressed(w, r, http.StatusOK, "failing.tmpl", nil)
+		},
+		"ServeTemplate": func(w http.ResponseWriter) error {
+			return x.ServeTemplate(w, r, "failing.tmpl", nil)
+		},
+		"ExecuteNegotiated": func(w http.ResponseWriter) error {
+			return x.ExecuteNegotiated(w, r, "failing.tmpl", nil)
+		},
+	}
+	if err := x.SetTemplate("failing.html.tmpl", `partial output {{ index . 1 }}`); err != nil {
+		t.Fatal(err)
+	}
+	for name, fn := range helpers {
+		rec := httptest.NewRecorder()
+		if err := fn(rec); !isFallback(err) {
+			t.Errorf("%s: expected fallback error, got %v", name, err)
+		}
+		if rec.Code != http.StatusInternalServerError || !strings.HasPrefix(rec.Body.String(), "error in failing") {
+			t.Errorf("%s: expected status 500 with error template, got %d: %q", name, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+type panicWriter struct{ w io.Writer }
+
+func (pw panicWriter) Write(p []byte) (int, error) {
+	if bytes.Contains(p, []byte("boom")) {
+		panic("boom")
+	}
+	return pw.w.Write(p)
+}
+
+func TestPanicRecovery(t *testing.T) {
+	x := New(WithPanicRecovery()).AddOutputFilter(func(name string, w io.Writer) io.Writer {
+		return panicWriter{w}
+	})
+	if err := x.ParseFS(fstest.MapFS{
+		"error.tmpl": {Data: []byte(`error in {{ .Name }}`)},
+		"page.tmpl":  {Data: []byte(`{{ . }}`)},
+	}, []string{".tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	err := x.ExecuteTemplate(&buf, "page.tmpl", "boom")
+	perr, ok := err.(*PanicError)
+	if !ok {
+		t.Fatalf("Expected *PanicError, got %v", err)
+	}
+	if perr.Name != "page.tmpl" || perr.Value != "boom" || len(perr.Stack) == 0 {
+		t.Errorf("Expected panic details, got %+v", perr)
+	}
+
+	x.SetErrorTemplate("error.tmpl")
+	buf.Reset()
+	if err := x.ExecuteTemplate(&buf, "page.tmpl", "boom"); !errors.As(err, &perr) {
+		t.Fatalf("Expected *PanicError, got %v", err)
+	}
+	if e, a := "error in page.tmpl", buf.String(); a != e {
+		t.Errorf("Expected %q, got %q", e, a)
+	}
+}
+
+func TestSandbox(t *testing.T) {
+	x := New(WithSandbox(Sandbox{MaxOutput: 20, MaxRangeIterations: 5, MaxDepth: 3, Funcs: []string{"upper", "include"}})).
+		Funcs(template.FuncMap{"upper": strings.ToUpper, "secret": func() string { return "secret" }})
+	if err := x.ParseFS(fstest.MapFS{
+		"base.tmpl":      {Data: []byte(`{{ block "content" . }}{{ end }}`)},
+		"range.tmpl":     {Data: []byte("{{ extends \"base.tmpl\" }}\n{{ define \"content\" }}{{ range . }}{{ range . }}.{{ end }}{{ end }}{{ end }}")},
+		"output.tmpl":    {Data: []byte(`{{ range . }}{{ upper "abcdefghij" }}{{ end }}`)},
+		"recursive.tmpl": {Data: []byte(`{{ define "r" }}{{ if . }}r{{ template "r" slice . 1 }}{{ end }}{{ end }}{{ template "r" . }}`)},
+		"include.tmpl":   {Data: []byte(`{{ $discarded := include "output.tmpl" . }}`)},
+		"printf.tmpl":    {Data: []byte(`{{ printf . 1 2000 }}`)},
+		"double.tmpl":    {Data: []byte(`{{ $s := "xx" }}{{ range . }}{{ $s = print $s $s }}{{ end }}{{ len $s }}`)},
+	}, []string{".tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		data interface{}
+		ok   bool
+	}{
+		{"range.tmpl", [][]int{{1, 2}, {3}}, true},
+		{"range.tmpl", [][]int{{1, 2}, {3, 4}}, false},
+		{"output.tmpl", []int{1, 2}, true},
+		{"output.tmpl", []int{1, 2, 3}, false},
+		{"recursive.tmpl", []int{1}, true},
+		{"recursive.tmpl", []int{1, 2, 3}, false},
+		{"include.tmpl", []int{1, 2}, true},
+		{"include.tmpl", []int{1, 2, 3}, false},
+		{"printf.tmpl", "%5d%.0d", true},
+		{"printf.tmpl", "%999999999d", false},
+		{"printf.tmpl", "%.2000f", false},
+		{"printf.tmpl", "%[2]*[1]d", false},
+		{"double.tmpl", []int{1, 2}, true},
+		{"double.tmpl", []int{1, 2, 3, 4}, false},
+	}
+	for _, test := range tests {
+		err := x.ExecuteTemplate(io.Discard, test.name, test.data)
+		if test.ok && err != nil {
+			t.Errorf("%s: expected no error for %v, got %v", test.name, test.data, err)
+		}
+		if !test.ok && !errors.Is(err, ErrLimitExceeded) {
+			t.Errorf("%s: expected ErrLimitExceeded for %v, got %v", test.name, test.data, err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := x.ExecuteTemplate(&buf, "range.tmpl", [][]int{{1, 2}, {3}}); err != nil {
+		t.Fatal(err)
+	}
+	if e, a := "...", buf.String(); a != e {
+		t.Errorf("Expected instrumented template to render %q, got %q", e, a)
+	}
+
+	if err := x.SetTemplate("secret.tmpl", `{{ secret }}`); err == nil {
+		t.Error("Expected error for template calling func outside of allowlist, got none")
+	}
+}
+
+func TestRestrictFuncs(t *testing.T) {
+	x := New().
+		Funcs(template.FuncMap{"upper": strings.ToUpper, "query": func() string { return "rows" }}).
+		RestrictFuncs("themes/", []string{"upper"})
+	if err := x.ParseFS(fstest.MapFS{
+		"layout.tmpl":      {Data: []byte(`{{ query }} {{ block "content" . }}{{ end }}`)},
+		"themes/page.tmpl": {Data: []byte("{{ extends \"layout.tmpl\" }}\n{{ define \"content\" }}{{ upper . | printf \"%s!\" }}{{ end }}")},
+	}, []string{".tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := x.ExecuteTemplate(&buf, "themes/page.tmpl", "hi"); err != nil {
+		t.Fatal(err)
+	}
+	if e, a := "rows HI!", buf.String(); a != e {
+		t.Errorf("Expected %q, got %q", e, a)
+	}
+
+	for _, c := range []string{`{{ query }}`, `{{ if true }}{{ with query }}{{ . }}{{ end }}{{ end }}`, `{{ template "x" query }}`} {
+		if err := x.SetTemplate("themes/evil.tmpl", c); err == nil {
+			t.Errorf("Expected error for restricted func in %q, got none", c)
+		}
+	}
+}
+
+func TestParseUntrusted(t *testing.T) {
+	limits := ParseLimits{MaxSize: 200, MaxDepth: 2, MaxNodes: 20, Timeout: time.Second}
+	x := New()
+	if err := x.SetTemplate("base.tmpl", `base {{ block "content" . }}{{ end }}`); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := x.ParseUntrusted("page.tmpl", "{{ extends \"base.tmpl\" }}\n{{ define \"content\" }}{{ if . }}yes{{ end }}{{ end }}", limits); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := x.ExecuteTemplate(&buf, "page.tmpl", true); err != nil {
+		t.Fatal(err)
+	}
+	if e, a := "base yes", buf.String(); a != e {
+		t.Errorf("Expected %q, got %q", e, a)
+	}
+
+	tests := []struct {
+		contents string
+		line     int
+		reason   string
+	}{
+		{strings.Repeat("x", 201), 0, "template is larger than 200 bytes"},
+		{"\n{{ if . }}{{ range . }}\n{{ with . }}{{ end }}{{ end }}{{ end }}", 3, "actions are nested more than 2 deep"},
+		{strings.Repeat("{{ . }}", 21), 0, "template has more than 20 nodes"},
+		{"{{ extends \"base.tmpl\" }}\nline 1\n{{ if }}", 3, "missing value for if"},
+		{"{{ nofunc }}", 1, `function "nofunc" not defined`},
+	}
+	for _, test := range tests {
+		err := x.ParseUntrusted("bad.tmpl", test.contents, limits)
+		uerr, ok := err.(*UntrustedError)
+		if !ok {
+			t.Errorf("Expected *UntrustedError for %q, got %v", test.contents, err)
+			continue
+		}
+		if uerr.Line != test.line || uerr.Reason != test.reason {
+			t.Errorf("Expected error at line %d: %q, got line %d: %q", test.line, test.reason, uerr.Line, uerr.Reason)
+		}
+	}
+	if x.exists("bad.tmpl") {
+		t.Error("Expected rejected template not to be added")
+	}
+}
+
+func TestInclude(t *testing.T) {
+	x := New(WithTextExtensions(".txt"))
+	if err := x.ParseFS(fstest.MapFS{
+		"base.tmpl":           {Data: []byte(`<main>{{ block "content" . }}{{ end }}</main>`)},
+		"page.tmpl":           {Data: []byte("{{ extends \"base.tmpl\" }}\n{{ define \"content\" }}{{ include \"partials/card.tmpl\" (dict \"Title\" .) }}{{ include \"note.txt\" . }}{{ end }}")},
+		"partials/card.tmpl":  {Data: []byte(`<h2>{{ .Title }}</h2>`)},
+		"note.txt":            {Data: []byte(`<{{ . }}>`)},
+		"self.tmpl":           {Data: []byte(`{{ include "self.tmpl" }}`)},
+		"partials/empty.tmpl": {Data: []byte(`{{ include "partials/card.tmpl" }}`)},
+	}, []string{".tmpl", ".txt"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := x.ExecuteTemplate(&buf, "page.tmpl", "a & b"); err != nil {
+		t.Fatal(err)
+	}
+	if e, a := "<main><h2>a &amp; b</h2>&lt;a &amp; b&gt;</main>", buf.String(); a != e {
+		t.Errorf("Expected %q, got %q", e, a)
+	}
+
+	if err := x.ExecuteTemplate(io.Discard, "partials/empty.tmpl", nil); err != nil {
+		t.Errorf("Expected include without data to succeed, got %v", err)
+	}
+	if err := x.ExecuteTemplate(io.Discard, "self.tmpl", nil); err == nil {
+		t.Error("Expected error for recursive include, got none")
+	}
+	if _, err := dict("a"); err == nil {
+		t.Error("Expected error for odd number of dict arguments, got none")
+	}
+}
+
+// endWriter writes a marker when closed
+type endWriter struct {
+	io.Writer
+}
+
+func (e endWriter) Close() error {
+	_, err := io.WriteString(e.Writer, "[END]")
+	return err
+}
+
+func TestNestedOutputFilters(t *testing.T) {
+	x := New().AddOutputFilter(func(name string, w io.Writer) io.Writer {
+		return endWriter{w}
+	})
+	if err := x.ParseFS(fstest.MapFS{
+		"page.tmpl": {Data: []byte(`PAGE {{ include "card.tmpl" }} {{ cache "card" "1m" "card.tmpl" . }}`)},
+		"card.tmpl": {Data: []byte(`CARD`)},
+	}, []string{".tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := x.ExecuteTemplate(&buf, "page.tmpl", nil); err != nil {
+		t.Fatal(err)
+	}
+	if e, a := "PAGE CARD CARD[END]", buf.String(); a != e {
+		t.Errorf("Expected %q, got %q", e, a)
+	}
+}
+
+func TestComponentsClone(t *testing.T) {
+	x := New(WithComponents("components/"))
+	if err := x.ParseFS(fstest.MapFS{
+		"pages/page.tmpl":     {Data: []byte(`{{ component "btn" }}{{ end }}`)},
+		"components/btn.tmpl": {Data: []byte(`original`)},
+	}, []string{".tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := x.Clone()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SetTemplate("components/btn.tmpl", `overridden`); err != nil {
+		t.Fatal(err)
+	}
+	tests := map[*Extemplate]string{x: "original", c: "overridden"}
+	for set, e := range tests {
+		var buf bytes.Buffer
+		if err := set.ExecuteTemplate(&buf, "pages/page.tmpl", nil); err != nil {
+			t.Fatal(err)
+		}
+		if a := buf.String(); a != e {
+			t.Errorf("Expected %q, got %q", e, a)
+		}
+	}
+
+	// components outside of a subset can not be rendered
+	s, err := x.Subset("pages/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ExecuteTemplate(io.Discard, "pages/page.tmpl", nil); err == nil {
+		t.Error("Expected error for component outside of subset, got none")
+	} else if !strings.Contains(err.Error(), "no component") {
+		t.Errorf("Expected error for missing component, got %v", err)
+	}
+}
+
+func TestComponents(t *testing.T) {
+	x := New(WithComponents("components/"))
+	if err := x.ParseFS(fstest.MapFS{
+		"components/card.tmpl":   {Data: []byte("{{ props \"title\" \"variant=primary\" }}\n<div class=\"{{ .variant }}\"><h2>{{ .title }}</h2>{{ slot }}empty{{ end }}<footer>{{ slot \"footer\" }}none{{ end }}</footer></div>")},
+		"components/button.tmpl": {Data: []byte(`<button>{{ slot }}{{ end }}</button>`)},
+		"page.tmpl":              {Data: []byte(`{{ $name := . }}{{ component "card" "title" "Hi" }}<p>{{ $name }}</p>{{ fill "footer" }}{{ component "button" }}Save{{ end }}{{ end }}{{ end }}`)},
+		"defaults.tmpl":          {Data: []byte(`{{ component "card" "title" "Hi" "variant" "secondary" }} {{ end }}`)},
+		"missing.tmpl":           {Data: []byte(`{{ component "card" }}{{ end }}`)},
+		"unknown.tmpl":           {Data: []byte(`{{ component "card" "title" "Hi" "size" "lg" }}{{ end }}`)},
+		"nonexistent.tmpl":       {Data: []byte(`{{ component "foo" }}{{ end }}`)},
+	}, []string{".tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := x.ExecuteTemplate(&buf, "page.tmpl", "a & b"); err != nil {
+		t.Fatal(err)
+	}
+	if e, a := `<div class="primary"><h2>Hi</h2><p>a &amp; b</p><footer><button>Save</button></footer></div>`, buf.String(); a != e {
+		t.Errorf("Expected %q, got %q", e, a)
+	}
+
+	buf.Reset()
+	if err := x.ExecuteTemplate(&buf, "defaults.tmpl", nil); err != nil {
+		t.Fatal(err)
+	}
+	if e, a := `<div class="secondary"><h2>Hi</h2>empty<footer>none</footer></div>`, buf.String(); a != e {
+		t.Errorf("Expected %q, got %q", e, a)
+	}
+
+	for _, name := range []string{"missing.tmpl", "unknown.tmpl", "nonexistent.tmpl"} {
+		if err := x.ExecuteTemplate(io.Discard, name, nil); err == nil {
+			t.Errorf("Expected error executing %s, got none", name)
+		}
+	}
+}
+
+func TestSections(t *testing.T) {
+	x := New(WithSections(), WithComponents("components/"))
+	if err := x.ParseFS(fstest.MapFS{
+		"base.tmpl":             {Data: []byte(`<head>{{ yield "scripts" }}</head><main>{{ block "content" . }}{{ end }}</main>{{ yield "empty" }}`)},
+		"page.tmpl":             {Data: []byte("{{ extends \"base.tmpl\" }}\n{{ define \"content\" }}{{ section \"scripts\" }}<script src=\"a.js\"></script>{{ end }}{{ if . }}<p>{{ . }}</p>{{ end }}{{ component \"chart\" }}{{ end }}{{ end }}")},
+		"components/chart.tmpl": {Data: []byte(`<canvas></canvas>{{ section "scripts" }}<script src="chart.js"></script>{{ end }}`)},
+	}, []string{".tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := x.ExecuteTemplate(&buf, "page.tmpl", "a & b"); err != nil {
+		t.Fatal(err)
+	}
+	if e, a := `<head><script src="a.js"></script><script src="chart.js"></script></head><main><p>a &amp; b</p><canvas></canvas></main>`, buf.String(); a != e {
+		t.Errorf("Expected %q, got %q", e, a)
+	}
+}
+
+func TestTurbo(t *testing.T) {
+	x := New()
+	if err := x.ParseFS(fstest.MapFS{
+		"base.tmpl":    {Data: []byte(`<main>{{ block "content" . }}{{ end }}</main>`)},
+		"page.tmpl":    {Data: []byte("{{ extends \"base.tmpl\" }}\n{{ define \"content\" }}<turbo-frame id=\"comments\">{{ block \"comments\" . }}<p>{{ . }}</p>{{ end }}</turbo-frame>{{ end }}")},
+		"comment.tmpl": {Data: []byte(`<li>{{ . }}</li>`)},
+	}, []string{".tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	rec := httptest.NewRecorder()
+	if err := x.RenderTurboFrame(rec, r, "page.tmpl", "a & b"); err != nil {
+		t.Fatal(err)
+	}
+	if e, a := `<main><turbo-frame id="comments"><p>a &amp; b</p></turbo-frame></main>`, rec.Body.String(); a != e {
+		t.Errorf("Expected %q, got %q", e, a)
+	}
+
+	r.Header.Set("Turbo-Frame", "comments")
+	rec = httptest.NewRecorder()
+	if err := x.RenderTurboFrame(rec, r, "page.tmpl", "a & b"); err != nil {
+		t.Fatal(err)
+	}
+	if e, a := `<turbo-frame id="comments"><p>a &amp; b</p></turbo-frame>`, rec.Body.String(); a != e {
+		t.Errorf("Expected %q, got %q", e, a)
+	}
+
+	r = httptest.NewRequest("POST", "/", nil)
+	r.Header.Set("Accept", TurboStreamContentType+", text/html")
+	if !AcceptsTurboStream(r) {
+		t.Error("Expected request to accept turbo streams")
+	}
+	rec = httptest.NewRecorder()
+	if err := x.RenderTurboStreams(rec, r,
+		TurboStream{Action: "append", Target: "comments", Template: "comment.tmpl", Data: "c"},
+		TurboStream{Action: "update", Target: "comments", Template: "page.tmpl", Block: "comments", Data: "d"},
+		TurboStream{Action: "remove", Target: "spinner"},
+	); err != nil {
+		t.Fatal(err)
+	}
+	if e, a := "<turbo-stream action=\"append\" target=\"comments\"><template><li>c</li></template></turbo-stream>\n"+
+		"<turbo-stream action=\"update\" target=\"comments\"><template><p>d</p></template></turbo-stream>\n"+
+		"<turbo-stream action=\"remove\" target=\"spinner\"></turbo-stream>\n", rec.Body.String(); a != e {
+		t.Errorf("Expected %q, got %q", e, a)
+	}
+	if e, a := TurboStreamContentType+"; charset=utf-8", rec.Header().Get("Content-Type"); a != e {
+		t.Errorf("Expected Content-Type %q, got %q", e, a)
+	}
+	if err := x.ExecuteBlock(context.Background(), io.Discard, "comment.tmpl", "foo", nil); err == nil {
+		t.Error("Expected error for unexisting block, got none")
+	}
+}
+
+func TestRenderHTMX(t *testing.T) {
+	x := New()
+	if err := x.ParseFS(fstest.MapFS{
+		"base.tmpl": {Data: []byte(`<main>{{ block "content" . }}{{ end }}</main>`)},
+		"page.tmpl": {Data: []byte("{{ extends \"base.tmpl\" }}\n{{ define \"content\" }}<ul id=\"results\">{{ block \"results\" . }}<li>{{ . }}</li>{{ end }}</ul>{{ end }}")},
+	}, []string{".tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		headers map[string]string
+		body    string
+	}{
+		{nil, `<main><ul id="results"><li>a</li></ul></main>`},
+		{map[string]string{"HX-Request": "true", "HX-Target": "results"}, `<li>a</li>`},
+		{map[string]string{"HX-Request": "true", "HX-Target": "results", "HX-Boosted": "true"}, `<main><ul id="results"><li>a</li></ul></main>`},
+		{map[string]string{"HX-Request": "true", "HX-Target": "unknown"}, `<main><ul id="results"><li>a</li></ul></main>`},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		for k, v := range test.headers {
+			r.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		if err := x.RenderHTMX(rec, r, "page.tmpl", "a"); err != nil {
+			t.Fatal(err)
+		}
+		if a := rec.Body.String(); a != test.body {
+			t.Errorf("Expected %q for headers %v, got %q", test.body, test.headers, a)
+		}
+	}
+}
+
+func TestURLResolver(t *testing.T) {
+	x := New()
+	if err := x.ParseFS(fstest.MapFS{
+		"page.tmpl": {Data: []byte(`<a href="{{ url "post" "slug" . }}">post</a>`)},
+	}, []string{".tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := x.ExecuteTemplate(io.Discard, "page.tmpl", "hello"); err == nil {
+		t.Error("Expected error without URL resolver, got none")
+	}
+
+	x.SetURLResolver(func(name string, params ...interface{}) (string, error) {
+		if name != "post" || len(params) != 2 {
+			return "", errors.New("unknown route")
+		}
+		return fmt.Sprintf("/posts/%v?a=b&c=d", params[1]), nil
+	})
+	var buf bytes.Buffer
+	if err := x.ExecuteTemplate(&buf, "page.tmpl", "hello world"); err != nil {
+		t.Fatal(err)
+	}
+	if e, a := `<a href="/posts/hello%20world?a=b&amp;c=d">post</a>`, buf.String(); a != e {
+		t.Errorf("Expected %q, got %q", e, a)
+	}
+}
+
+func TestPaginate(t *testing.T) {
+	pages := func(p *Pagination) string {
+		var s []string
+		for _, page := range p.Pages {
+			switch {
+			case page.Gap:
+				s = append(s, "…")
+			case page.Current:
+				s = append(s, fmt.Sprintf("[%d]", page.Number))
+			default:
+				s = append(s, fmt.Sprint(page.Number))
+			}
+		}
+		return strings.Join(s, " ")
+	}
+
+	tests := []struct {
+		total, perPage, current int
+		e                       string
+	}{
+		{0, 10, 1, "[1]"},
+		{95, 10, 1, "[1] 2 3 … 10"},
+		{95, 10, 6, "1 … 4 5 [6] 7 8 … 10"},
+		{95, 10, 4, "1 2 3 [4] 5 6 … 10"},
+		{95, 10, 99, "1 … 8 9 [10]"},
+	}
+	for _, test := range tests {
+		p, err := paginate(test.total, test.perPage, test.current)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if a := pages(p); a != test.e {
+			t.Errorf("paginate(%d, %d, %d): expected %q, got %q", test.total, test.perPage, test.current, test.e, a)
+		}
+	}
+
+	p, _ := paginate(95, 10, 6, 1)
+	if e, a := "1 … 5 [6] 7 … 10", pages(p); a != e {
+		t.Errorf("Expected %q, got %q", e, a)
+	}
+	if p.Prev != 5 || p.Next != 7 || p.Offset != 50 {
+		t.Errorf("Expected prev 5, next 7 and offset 50, got %d, %d and %d", p.Prev, p.Next, p.Offset)
+	}
+	if _, err := paginate(10, 0, 1); err == nil {
+		t.Error("Expected error for zero page size, got none")
+	}
+
+	x := New()
+	if err := x.ParseFS(fstest.MapFS{
+		"list.tmpl": {Data: []byte(`{{ define "pagination" }}{{ range .Pages }}{{ if .Gap }}…{{ else }}{{ .Number }}{{ end }},{{ end }}{{ end }}{{ template "pagination" paginate 30 10 2 }}`)},
+	}, []string{".tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := x.ExecuteTemplate(&buf, "list.tmpl", nil); err != nil {
+		t.Fatal(err)
+	}
+	if e, a := "1,2,3,", buf.String(); a != e {
+		t.Errorf("Expected %q, got %q", e, a)
+	}
+}
+
+func TestFlashes(t *testing.T) {
+	x := New()
+	if err := x.ParseFS(fstest.MapFS{
+		"base.tmpl": {Data: []byte(`{{ range flashes }}<p class="{{ .Kind }}">{{ .Message }}</p>{{ end }}{{ len flashes }}`)},
+	}, []string{".tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := x.ExecuteTemplate(&buf, "base.tmpl", nil); err != nil {
+		t.Fatal(err)
+	}
+	if e, a := "0", buf.String(); a != e {
+		t.Errorf("Expected %q without provider, got %q", e, a)
+	}
+
+	type sessionKey struct{}
+	calls := 0
+	x.SetFlashProvider(FlashProviderFunc(func(ctx context.Context) []Flash {
+		calls++
+		return []Flash{{Kind: "success", Message: ctx.Value(sessionKey{}).(string)}}
+	}))
+	buf.Reset()
+	if err := x.ExecuteTemplateContext(context.WithValue(context.Background(), sessionKey{}, "Saved & done"), &buf, "base.tmpl", nil); err != nil {
+		t.Fatal(err)
+	}
+	if e, a := `<p class="success">Saved &amp; done</p>1`, buf.String(); a != e {
+		t.Errorf("Expected %q, got %q", e, a)
+	}
+	if calls != 1 {
+		t.Errorf("Expected provider to be called once, got %d calls", calls)
+	}
+}
+
+func TestInline(t *testing.T) {
+	x := New(WithMaxInlineSize(64))
+	fsys := fstest.MapFS{
+		"page.tmpl":       {Data: []byte(`<a>{{ inline "icons/check.svg" }}</a>`)},
+		"large.tmpl":      {Data: []byte(`{{ inline "icons/large.svg" }}`)},
+		"escape.tmpl":     {Data: []byte(`{{ inline "../secret" }}`)},
+		"icons/check.svg": {Data: []byte(`<svg><path d="M0 0"/></svg>`)},
+		"icons/large.svg": {Data: bytes.Repeat([]byte("x"), 65)},
+	}
+	if err := x.ParseFS(fsys, []string{".tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := x.ExecuteTemplate(&buf, "page.tmpl", nil); err != nil {
+		t.Fatal(err)
+	}
+	if e, a := `<a><svg><path d="M0 0"/></svg></a>`, buf.String(); a != e {
+		t.Errorf("Expected %q, got %q", e, a)
+	}
+
+	// served from cache
+	fsys["icons/check.svg"] = &fstest.MapFile{Data: []byte(`<svg/>`)}
+	buf.Reset()
+	if err := x.ExecuteTemplate(&buf, "page.tmpl", nil); err != nil {
+		t.Fatal(err)
+	}
+	if e, a := `<a><svg><path d="M0 0"/></svg></a>`, buf.String(); a != e {
+		t.Errorf("Expected cached %q, got %q", e, a)
+	}
+
+	for _, name := range []string{"large.tmpl", "escape.tmpl"} {
+		if err := x.ExecuteTemplate(io.Discard, name, nil); err == nil {
+			t.Errorf("Expected error executing %s, got none", name)
+		}
+	}
+}
+
+func TestIntegrity(t *testing.T) {
+	x := New()
+	if err := x.ParseFS(fstest.MapFS{
+		"page.tmpl":     {Data: []byte(`{{ integrity "static/app.js" }} {{ integrity "static/vendor.js" }}`)},
+		"missing.tmpl":  {Data: []byte(`{{ integrity "static/missing.js" }}`)},
+		"static/app.js": {Data: []byte(`alert(1)`)},
+	}, []string{".tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+	x.SetIntegrityManifest(map[string]string{"static/vendor.js": "sha384-vendor"})
+
+	sum := sha512.Sum384([]byte(`alert(1)`))
+	var buf bytes.Buffer
+	if err := x.ExecuteTemplate(&buf, "page.tmpl", nil); err != nil {
+		t.Fatal(err)
+	}
+	if e, a := "sha384-"+base64.StdEncoding.EncodeToString(sum[:])+" sha384-vendor", buf.String(); a != strings.Replace(e, "+", "&#43;", -1) {
+		t.Errorf("Expected %q, got %q", e, a)
+	}
+	if err := x.ExecuteTemplate(io.Discard, "missing.tmpl", nil); err == nil {
+		t.Error("Expected error for missing file, got none")
+	}
+}
+
+func TestRenderCompressed(t *testing.T) {
+	x := New()
+	if err := x.ParseFS(fstest.MapFS{
+		"page.tmpl": {Data: []byte(`<p>{{ . }}</p>`)},
+	}, []string{".tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		acceptEncoding string
+		encoding       string
+	}{
+		{"", ""},
+		{"gzip, deflate, br", "gzip"},
+		{"deflate", "deflate"},
+		{"gzip;q=0.5, deflate", "deflate"},
+		{"gzip;q=0, *", "deflate"},
+		{"br, identity", ""},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Accept-Encoding", test.acceptEncoding)
+		rec := httptest.NewRecorder()
+		if err := x.RenderCompressed(rec, r, http.StatusCreated, "page.tmpl", "hello"); err != nil {
+			t.Fatal(err)
+		}
+		if a := rec.Header().Get("Content-Encoding"); a != test.encoding {
+			t.Errorf("Accept-Encoding %q: expected encoding %q, got %q", test.acceptEncoding, test.encoding, a)
+			continue
+		}
+
+		var body io.Reader = rec.Body
+		switch test.encoding {
+		case "gzip":
+			gr, err := gzip.NewReader(rec.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body = gr
+		case "deflate":
+			body = flate.NewReader(rec.Body)
+		}
+		b, err := io.ReadAll(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e, a := "<p>hello</p>", string(b); a != e {
+			t.Errorf("Accept-Encoding %q: expected %q, got %q", test.acceptEncoding, e, a)
+		}
+		if rec.Code != http.StatusCreated {
+			t.Errorf("Expected status %d, got %d", http.StatusCreated, rec.Code)
+		}
+	}
+}
+
+func TestExportAll(t *testing.T) {
+	x := New(WithTextExtensions(".txt"))
+	if err := x.ParseFS(fstest.MapFS{
+		"base.tmpl":       {Data: []byte(`<main>{{ block "content" . }}{{ end }}</main>`)},
+		"index.tmpl":      {Data: []byte("{{ extends \"base.tmpl\" }}\n{{ define \"content\" }}{{ . }}{{ end }}")},
+		"blog/index.tmpl": {Data: []byte("{{ extends \"base.tmpl\" }}\n{{ define \"content\" }}blog {{ . }}{{ end }}")},
+		"robots.txt":      {Data: []byte(`User-agent: {{ . }}`)},
+	}, []string{".tmpl", ".txt"}); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	if err := x.ExportAll(dir, func(name string) (interface{}, error) {
+		if name == "base.tmpl" {
+			return nil, SkipFile
+		}
+		return "*", nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, e := range map[string]string{
+		"index.html":      "<main>*</main>",
+		"blog/index.html": "<main>blog *</main>",
+		"robots.txt":      "User-agent: *",
+	} {
+		b, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if a := string(b); a != e {
+			t.Errorf("Expected %s to contain %q, got %q", name, e, a)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "base.html")); !os.IsNotExist(err) {
+		t.Errorf("Expected skipped template not to be exported, got %v", err)
+	}
+
+	// only the templates affected by a change are exported again
+	affected := x.Affected("base.tmpl", "unexisting.tmpl")
+	if e, a := "[base.tmpl blog/index.tmpl index.tmpl]", fmt.Sprint(affected); a != e {
+		t.Errorf("Expected affected templates %s, got %s", e, a)
+	}
+	if err := x.Export(dir, []string{"index.tmpl"}, func(name string) (interface{}, error) {
+		return "!", nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(x.ExportPath(dir, "index.tmpl")); string(b) != "<main>!</main>" {
+		t.Errorf("Expected exported index.html to contain %q, got %q", "<main>!</main>", b)
+	}
+	if b, _ := os.ReadFile(x.ExportPath(dir, "blog/index.tmpl")); string(b) != "<main>blog *</main>" {
+		t.Errorf("Expected blog/index.html to be untouched, got %q", b)
+	}
+
+	// a failing render leaves the existing file untouched
+	if err := x.RenderToFile(filepath.Join(dir, "index.html"), "unexisting.tmpl", nil); err == nil {
+		t.Error("Expected error rendering unexisting template, got none")
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Errorf("Expected 3 entries in export directory, got %d", len(entries))
+	}
+}
+
+func TestEnvironment(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "page.tmpl")
+	if err := os.WriteFile(file, []byte(`<p>{{ .Title }}</p>`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	dev := New(WithEnvironment(Dev))
+	if err := dev.ParseDir(dir, []string{".tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := dev.ExecuteTemplate(io.Discard, "page.tmpl", map[string]string{}); err == nil {
+		t.Error("Expected error for missing key in development, got none")
+	}
+	var buf bytes.Buffer
+	if err := dev.ExecuteTemplate(&buf, "page.tmpl", map[string]string{"Title": ""}); err != nil {
+		t.Fatal(err)
+	}
+	if e, a := "<!-- begin page.tmpl --><p></p><!-- end page.tmpl -->", buf.String(); a != e {
+		t.Errorf("Expected %q, got %q", e, a)
+	}
+
+	// changed files are reloaded
+	if err := os.WriteFile(file, []byte(`<h1>{{ .Title }}</h1>`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes(file, future, future); err != nil {
+		t.Fatal(err)
+	}
+	dev.lastReload = time.Time{}
+	buf.Reset()
+	if err := dev.ExecuteTemplate(&buf, "page.tmpl", map[string]string{"Title": "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if e, a := "<!-- begin page.tmpl --><h1>a</h1><!-- end page.tmpl -->", buf.String(); a != e {
+		t.Errorf("Expected reloaded %q, got %q", e, a)
+	}
+
+	// new files are parsed
+	if err := os.WriteFile(filepath.Join(dir, "new.tmpl"), []byte(`new`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	dev.lastReload = time.Time{}
+	buf.Reset()
+	if err := dev.ExecuteTemplate(&buf, "new.tmpl", nil); err != nil {
+		t.Fatal(err)
+	}
+	if e, a := "<!-- begin new.tmpl -->new<!-- end new.tmpl -->", buf.String(); a != e {
+		t.Errorf("Expected new %q, got %q", e, a)
+	}
+
+	prod := New(WithEnvironment(Prod))
+	if err := prod.ParseDir(dir, []string{".tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := prod.ExecuteTemplate(io.Discard, "page.tmpl", map[string]string{}); err == nil {
+		t.Error("Expected error for missing key in production, got none")
+	}
+
+	// caches stay disabled in Dev, whatever the order of options
+	fsys := fstest.MapFS{
+		"cached.tmpl":  {Data: []byte(`{{ cache "k" "1h" "counter.tmpl" . }}`)},
+		"counter.tmpl": {Data: []byte(`{{ .Count }}`)},
+	}
+	for name, x := range map[string]*Extemplate{
+		"after":  New(WithResponseCache(10, time.Hour), WithEnvironment(Dev)),
+		"before": New(WithEnvironment(Dev), WithResponseCache(10, time.Hour)).SetFragmentCache(NewLRUCache(10)),
+	} {
+		if err := x.ParseFS(fsys, []string{".tmpl"}); err != nil {
+			t.Fatal(err)
+		}
+		for i := 1; i <= 2; i++ {
+			for _, tmpl := range []string{"cached.tmpl", "counter.tmpl"} {
+				buf.Reset()
+				if err := x.ExecuteTemplateCached(&buf, tmpl, "k", map[string]int{"Count": i}); err != nil {
+					t.Fatal(err)
+				}
+				if e, a := fmt.Sprintf("<!-- begin %s -->%d<!-- end %s -->", tmpl, i, tmpl), buf.String(); a != e {
+					t.Errorf("%s: Expected uncached %q for %s, got %q", name, e, tmpl, a)
+				}
+			}
+		}
+	}
+}
+
+func TestWarnings(t *testing.T) {
+	x := New()
+	fsys := fstest.MapFS{
+		"empty.tmpl":    {Data: []byte{}},
+		"child.tmpl":    {Data: []byte(`{{ extends "base.html.j2" }}`)},
+		"base.html.j2":  {Data: []byte(`{{ block "content" . }}{{ end }}`)},
+		"a.tmpl":        {Data: []byte(`{{ define "nav" }}a{{ end }}`)},
+		"b.tmpl":        {Data: []byte(`{{ define "nav" }}b{{ end }}`)},
+		"shadow.tmpl":   {Data: []byte(`{{ define "empty.tmpl" }}shadow{{ end }}`)},
+		"extended.tmpl": {Data: []byte(`{{ block "content" . }}{{ end }}`)},
+		"page.tmpl":     {Data: []byte("{{ extends \"extended.tmpl\" }}\n{{ define \"content\" }}page{{ end }}")},
+	}
+	if err := x.ParseFS(fsys, []string{".tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var warnings []string
+	for _, w := range x.Warnings() {
+		warnings = append(warnings, w.String())
+	}
+	e := []string{
+		`b.tmpl: defines "nav", which is also defined in a.tmpl`,
+		`child.tmpl: extends "base.html.j2", which is not a parsed template`,
+		`empty.tmpl: file is empty`,
+		`shadow.tmpl: defines "empty.tmpl", which shadows the template of that name`,
+	}
+	if !reflect.DeepEqual(warnings, e) {
+		t.Errorf("Expected warnings %q, got %q", e, warnings)
+	}
+
+	for name := range fsys {
+		if name != "page.tmpl" {
+			delete(fsys, name)
+		}
+	}
+	if err := x.ParseFS(fsys, []string{".tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+	if w := x.Warnings(); len(w) != 0 {
+		t.Errorf("Expected warnings to be reset, got %v", w)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	x := New()
+	if err := x.ParseFS(fstest.MapFS{
+		"base.tmpl":    {Data: []byte(`base {{ block "content" . }}{{ end }}`)},
+		"page.tmpl":    {Data: []byte("{{ extends \"base.tmpl\" }}\n{{ define \"content\" }}{{ .Foo }}{{ end }}")},
+		"orphan.tmpl":  {Data: []byte("{{ extends \"missing.tmpl\" }}\n{{ define \"content\" }}orphan{{ end }}")},
+		"include.tmpl": {Data: []byte(`{{ template "page.tmpl" . }}`)},
+	}, []string{".tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+
+	err := x.ExecuteTemplate(io.Discard, "nope.tmpl", nil)
+	if !errors.Is(err, ErrTemplateNotFound) {
+		t.Errorf("Expected ErrTemplateNotFound, got %v", err)
+	}
+
+	var pe *ParseError
+	err = x.ExecuteTemplate(io.Discard, "orphan.tmpl", nil)
+	if !errors.Is(err, ErrLayoutNotFound) || !errors.As(err, &pe) || pe.Name != "orphan.tmpl" {
+		t.Errorf("Expected *ParseError for orphan.tmpl wrapping ErrLayoutNotFound, got %v", err)
+	}
+
+	var ee *ExecError
+	for _, name := range []string{"page.tmpl", "include.tmpl"} {
+		err = x.ExecuteTemplate(io.Discard, name, "data")
+		if !errors.As(err, &ee) || ee.Name != name {
+			t.Errorf("Expected *ExecError for %s, got %#v", name, err)
+		}
+	}
+
+	err = New().ParseFS(fstest.MapFS{
+		"a.tmpl": {Data: []byte("{{ extends \"b.tmpl\" }}\n")},
+		"b.tmpl": {Data: []byte("{{ extends \"a.tmpl\" }}\n")},
+	}, []string{".tmpl"})
+	if !errors.Is(err, ErrCycle) || !errors.As(err, &pe) || pe.Name != "a.tmpl" {
+		t.Errorf("Expected *ParseError for a.tmpl wrapping ErrCycle, got %v", err)
+	}
+
+	err = New().ParseFS(fstest.MapFS{"a.tmpl": {Data: []byte("{{ if }}")}}, []string{".tmpl"})
+	if !errors.As(err, &pe) || pe.Name != "a.tmpl" {
+		t.Errorf("Expected *ParseError for a.tmpl, got %v", err)
+	}
+}
+
+func TestConflictPolicy(t *testing.T) {
+	fsys := fstest.MapFS{
+		"Page.tmpl": {Data: []byte(`upper`)},
+		"page.tmpl": {Data: []byte(`lower`)},
+	}
+	tests := []struct {
+		policy  ConflictPolicy
+		output  string
+		warning string
+	}{
+		{ConflictWarn, "lower", "page.tmpl: file Page.tmpl is ignored, as page.tmpl has the same name"},
+		{ConflictKeepFirst, "upper", "page.tmpl: file page.tmpl is ignored, as Page.tmpl has the same name"},
+		{ConflictError, "", ""},
+	}
+	for _, test := range tests {
+		x := New(WithCaseInsensitiveLookup(), WithConflictPolicy(test.policy))
+		err := x.ParseFS(fsys, []string{".tmpl"})
+		if test.policy == ConflictError {
+			if !errors.Is(err, ErrDuplicateName) {
+				t.Errorf("Expected ErrDuplicateName, got %v", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var buf bytes.Buffer
+		if err := x.ExecuteTemplate(&buf, "page.tmpl", nil); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != test.output {
+			t.Errorf("Expected %q, got %q", test.output, buf.String())
+		}
+		if w := x.Warnings(); len(w) != 1 || w[0].String() != test.warning {
+			t.Errorf("Expected warning %q, got %v", test.warning, w)
+		}
+	}
+
+	// files at another path parsed by an earlier call
+	x := New(WithNameFunc(path.Base), WithConflictPolicy(ConflictError))
+	fsys = fstest.MapFS{"a/page.tmpl": {Data: []byte(`a`)}}
+	if err := x.ParseFS(fsys, []string{".tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+	fsys["a/page.tmpl"] = &fstest.MapFile{Data: []byte(`a2`)}
+	if err := x.ParseFS(fsys, []string{".tmpl"}); err != nil {
+		t.Errorf("Expected no error parsing the same path again, got %v", err)
+	}
+	if err := x.ParseFS(fstest.MapFS{"b/page.tmpl": {Data: []byte(`b`)}}, []string{".tmpl"}); !errors.Is(err, ErrDuplicateName) {
+		t.Errorf("Expected ErrDuplicateName, got %v", err)
+	}
+
+	// files at the same path in another file system
+	dirs := []string{t.TempDir(), t.TempDir()}
+	for i, dir := range dirs {
+		if err := os.WriteFile(filepath.Join(dir, "index.tmpl"), []byte(fmt.Sprintf("module %d", i)), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	x = New(WithConflictPolicy(ConflictError))
+	if err := x.ParseDir(dirs[0], []string{".tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := x.ParseDir(dirs[0], []string{".tmpl"}); err != nil {
+		t.Errorf("Expected no error parsing the same directory again, got %v", err)
+	}
+	if err := x.ParseDir(dirs[1], []string{".tmpl"}); !errors.Is(err, ErrDuplicateName) {
+		t.Errorf("Expected ErrDuplicateName, got %v", err)
+	}
+
+	x = New(WithConflictPolicy(ConflictKeepFirst))
+	for _, dir := range dirs {
+		if err := x.ParseDir(dir, []string{".tmpl"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var buf bytes.Buffer
+	if err := x.ExecuteTemplate(&buf, "index.tmpl", nil); err != nil {
+		t.Fatal(err)
+	}
+	if e, a := "module 0", buf.String(); a != e {
+		t.Errorf("Expected %q, got %q", e, a)
+	}
+	if len(x.Warnings()) != 1 {
+		t.Errorf("Expected warning for ignored file, got %v", x.Warnings())
+	}
+
+	// ignored files are not picked up as new files when reloading
+	dir := t.TempDir()
+	for name, c := range map[string]string{"Page.tmpl": "upper", "page.tmpl": "lower"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(c), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	x = New(WithEnvironment(Dev), WithCaseInsensitiveLookup(), WithConflictPolicy(ConflictKeepFirst))
+	if err := x.ParseDir(dir, []string{".tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+	x.lastReload = time.Time{}
+	buf.Reset()
+	if err := x.ExecuteTemplate(&buf, "page.tmpl", nil); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "upper") {
+		t.Errorf("Expected ignored file to stay ignored, got %q", buf.String())
+	}
+}
+
+func TestSuggestions(t *testing.T) {
+	x := New()
+	if err := x.ParseFS(fstest.MapFS{
+		"layouts/base.tmpl": {Data: []byte(`base {{ block "content" . }}{{ end }}`)},
+		"partials/nav.tmpl": {Data: []byte(`nav`)},
+		"page.tmpl":         {Data: []byte("{{ extends \"layouts/bsae.tmpl\" }}\n{{ define \"content\" }}page{{ end }}")},
+		"about.tmpl":        {Data: []byte("{{ extends \"base.tmpl\" }}\n{{ define \"content\" }}about{{ end }}")},
+		"nav.tmpl":          {Data: []byte(`{{ template "partials/nva.tmpl" }}`)},
+		"unrelated.tmpl":    {Data: []byte("{{ extends \"something/else.tmpl\" }}\n")},
+	}, []string{".tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]string{
+		"page.tmpl":      `did you mean "layouts/base.tmpl"?`,
+		"about.tmpl":     `did you mean "layouts/base.tmpl"?`,
+		"nav.tmpl":       `did you mean "partials/nav.tmpl"?`,
+		"unrelated.tmpl": "",
+	}
+	for name, suggestion := range tests {
+		err := x.ExecuteTemplate(io.Discard, name, nil)
+		if err == nil {
+			t.Fatalf("Expected error for %s, got none", name)
+		}
+		if suggestion == "" && strings.Contains(err.Error(), "did you mean") || !strings.HasSuffix(err.Error(), suggestion) {
+			t.Errorf("Expected error for %s ending in %q, got %q", name, suggestion, err)
+		}
+	}
+
+	var warnings []string
+	for _, w := range x.Warnings() {
+		warnings = append(warnings, w.String())
+	}
+	e := []string{
+		`about.tmpl: extends "base.tmpl", which is not a parsed template, did you mean "layouts/base.tmpl"?`,
+		`nav.tmpl: invokes "partials/nva.tmpl", which is not defined, did you mean "partials/nav.tmpl"?`,
+		`page.tmpl: extends "layouts/bsae.tmpl", which is not a parsed template, did you mean "layouts/base.tmpl"?`,
+		`unrelated.tmpl: extends "something/else.tmpl", which is not a parsed template`,
+	}
+	if !reflect.DeepEqual(warnings, e) {
+		t.Errorf("Expected warnings %q, got %q", e, warnings)
+	}
+}
+
+func TestAlias(t *testing.T) {
+	x := New().Alias("base", "layouts/base.tmpl").Alias("nav", "partials/nav.tmpl").Alias("home", "pages/index.tmpl")
+	if err := x.ParseFS(fstest.MapFS{
+		"layouts/base.tmpl": {Data: []byte(`base {{ template "nav" }} {{ block "content" . }}{{ end }}`)},
+		"partials/nav.tmpl": {Data: []byte(`nav`)},
+		"pages/index.tmpl":  {Data: []byte("{{ extends \"base\" }}\n{{ define \"content\" }}index{{ end }}")},
+	}, []string{".tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := x.ExecuteTemplate(&buf, "home", nil); err != nil {
+		t.Fatal(err)
+	}
+	if e := "base nav index"; buf.String() != e {
+		t.Errorf("Expected %q, got %q", e, buf.String())
+	}
+	if x.Lookup("home") == nil {
+		t.Error("Expected Lookup to resolve alias")
+	}
+	if w := x.Warnings(); len(w) != 0 {
+		t.Errorf("Expected no warnings, got %v", w)
+	}
+
+	// templates invoking an alias are recompiled when its template changes
+	if err := x.SetTemplate("partials/nav.tmpl", "new nav"); err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	if err := x.ExecuteTemplate(&buf, "home", nil); err != nil {
+		t.Fatal(err)
+	}
+	if e := "base new nav index"; buf.String() != e {
+		t.Errorf("Expected %q, got %q", e, buf.String())
+	}
+}
+
+func TestDirectoryIndex(t *testing.T) {
+	x := New(WithDirectoryIndex("index.tmpl", "index.html"))
+	if err := x.ParseFS(fstest.MapFS{
+		"index.html":       {Data: []byte(`home`)},
+		"users/index.tmpl": {Data: []byte(`users`)},
+		"users.tmpl":       {Data: []byte(`users file`)},
+		"blog/index.html":  {Data: []byte(`blog`)},
+	}, []string{".tmpl", ".html"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]string{
+		"":       "home",
+		"/":      "home",
+		"users/": "users",
+		"users":  "users",
+		"blog":   "blog",
+		"blog/":  "blog",
+	}
+	for name, e := range tests {
+		var buf bytes.Buffer
+		if err := x.ExecuteTemplate(&buf, name, nil); err != nil {
+			t.Fatalf("%q: %s", name, err)
+		}
+		if buf.String() != e {
+			t.Errorf("%q: expected %q, got %q", name, e, buf.String())
+		}
+	}
+	if x.Lookup("users.tmpl") == nil || x.Lookup("missing") != nil {
+		t.Error("Expected only existing templates and directories to resolve")
+	}
+
+	rec := httptest.NewRecorder()
+	Handler(x, WithHandlerExtension(".html")).ServeHTTP(rec, httptest.NewRequest("GET", "/users", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "users" {
+		t.Errorf("Expected users index, got %d %q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestExecuteNegotiated(t *testing.T) {
+	x := New(WithTextExtensions(".txt.tmpl"))
+	if err := x.ParseFS(fstest.MapFS{
+		"page.html.tmpl": {Data: []byte(`<p>{{ . }}</p>`)},
+		"page.txt.tmpl":  {Data: []byte(`{{ . }}`)},
+		"feed.xml.tmpl":  {Data: []byte(`<feed>{{ . }}</feed>`)},
+		"plain.tmpl":     {Data: []byte(`plain {{ . }}`)},
+	}, []string{".tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		accept      string
+		contentType string
+		body        string
+	}{
+		{"page.tmpl", "", "text/html; charset=utf-8", "<p>a &lt; b</p>"},
+		{"page.tmpl", "text/html,application/xhtml+xml;q=0.9,*/*;q=0.8", "text/html; charset=utf-8", "<p>a &lt; b</p>"},
+		{"page.tmpl", "text/plain", "text/plain; charset=utf-8", "a < b"},
+		{"page.tmpl", "text/html;q=0.5, text/*", "text/plain; charset=utf-8", "a < b"},
+		{"page.tmpl", "application/json", "text/html; charset=utf-8", "<p>a &lt; b</p>"},
+		{"feed.tmpl", "text/html", "application/xml; charset=utf-8", "<feed>a &lt; b</feed>"},
+		{"plain.tmpl", "text/plain", "text/html; charset=utf-8", "plain a &lt; b"},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		if test.accept != "" {
+			r.Header.Set("Accept", test.accept)
+		}
+		rec := httptest.NewRecorder()
+		if err := x.ExecuteNegotiated(rec, r, test.name, "a < b"); err != nil {
+			t.Fatal(err)
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != test.contentType {
+			t.Errorf("%s with Accept %q: expected Content-Type %q, got %q", test.name, test.accept, test.contentType, ct)
+		}
+		if rec.Body.String() != test.body {
+			t.Errorf("%s with Accept %q: expected body %q, got %q", test.name, test.accept, test.body, rec.Body.String())
+		}
+		if v := rec.Header().Get("Vary"); v != "Accept" {
+			t.Errorf("Expected Vary: Accept, got %q", v)
+		}
+	}
+}
+
+func TestJSONFallback(t *testing.T) {
+	x := New(WithJSONFallback())
+	if err := x.ParseFS(fstest.MapFS{
+		"page.html.tmpl": {Data: []byte(`<p>{{ .Title }}</p>`)},
+	}, []string{".tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+
+	data := map[string]string{"Title": "Hello"}
+	tests := []struct {
+		name   string
+		accept string
+		body   string
+	}{
+		{"page.tmpl", "", "<p>Hello</p>"},
+		{"page.tmpl", "*/*", "<p>Hello</p>"},
+		{"page.tmpl", "text/html, application/json;q=0.5", "<p>Hello</p>"},
+		{"page.tmpl", "application/json", "{\"Title\":\"Hello\"}\n"},
+		{"api.tmpl", "text/html", "{\"Title\":\"Hello\"}\n"},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		if test.accept != "" {
+			r.Header.Set("Accept", test.accept)
+		}
+		rec := httptest.NewRecorder()
+		if err := x.ExecuteNegotiated(rec, r, test.name, data); err != nil {
+			t.Fatal(err)
+		}
+		if rec.Body.String() != test.body {
+			t.Errorf("%s with Accept %q: expected body %q, got %q", test.name, test.accept, test.body, rec.Body.String())
+		}
+	}
+
+	// without the option, missing templates are an error
+	r := httptest.NewRequest("GET", "/", nil)
+	if err := New().ExecuteNegotiated(httptest.NewRecorder(), r, "api.tmpl", data); !errors.Is(err, ErrTemplateNotFound) {
+		t.Errorf("Expected ErrTemplateNotFound, got %v", err)
+	}
+}
+
+func TestLoadData(t *testing.T) {
+	x := New(WithDataFormat("csv", func(b []byte, v interface{}) error {
+		*v.(*interface{}) = strings.Split(strings.TrimSpace(string(b)), ",")
+		return nil
+	}))
+	data, err := x.LoadData(fstest.MapFS{
+		"products.json":       {Data: []byte(`[{"name": "a"}]`)},
+		"shop/categories.csv": {Data: []byte("x,y\n")},
+		"README.md":           {Data: []byte(`ignored`)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := map[string]interface{}{
+		"products": []interface{}{map[string]interface{}{"name": "a"}},
+		"shop":     map[string]interface{}{"categories": []string{"x", "y"}},
+	}
+	if !reflect.DeepEqual(data, e) {
+		t.Errorf("Expected %v, got %v", e, data)
+	}
+
+	_, err = x.LoadData(fstest.MapFS{
+		"products.json": {Data: []byte(`[]`)},
+		"products.csv":  {Data: []byte(`a`)},
+	})
+	if err == nil {
+		t.Error("Expected error for data files with the same name, got none")
+	}
+}
+
+func TestRender(t *testing.T) {
+	x := New(WithTextExtensions(".txt"))
+	if err := x.ParseFS(fstest.MapFS{
+		"page.tmpl":   {Data: []byte(`<p>{{ . }}</p>`)},
+		"plain.txt":   {Data: []byte(`{{ . }}`)},
+		"broken.tmpl": {Data: []byte(`partial output {{ .Foo }}`)},
+	}, []string{".tmpl", ".txt"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+	}{
+		{"page.tmpl", "text/html; charset=utf-8", "<p>a &lt; b</p>"},
+		{"plain.txt", "text/plain; charset=utf-8", "a < b"},
+	}
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		if err := x.Render(rec, http.StatusCreated, test.name, "a < b"); err != nil {
+			t.Fatal(err)
+		}
+		if rec.Code != http.StatusCreated {
+			t.Errorf("%s: expected status %d, got %d", test.name, http.StatusCreated, rec.Code)
+		}
+		if a := rec.Header().Get("Content-Type"); a != test.contentType {
+			t.Errorf("%s: expected Content-Type %q, got %q", test.name, test.contentType, a)
+		}
+		if e, a := fmt.Sprint(len(test.body)), rec.Header().Get("Content-Length"); a != e {
+			t.Errorf("%s: expected Content-Length %s, got %s", test.name, e, a)
+		}
+		if a := rec.Body.String(); a != test.body {
+			t.Errorf("%s: expected body %q, got %q", test.name, test.body, a)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	if err := x.Render(rec, http.StatusOK, "broken.tmpl", "data"); err == nil {
+		t.Error("Expected error, got none")
+	}
+	if rec.Body.Len() > 0 || len(rec.Header()) > 0 {
+		t.Errorf("Expected nothing to be written, got %q", rec.Body.String())
+	}
+}
+
+func TestServeTemplate(t *testing.T) {
+	modTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	x := New()
+	if err := x.ParseFS(fstest.MapFS{
+		"page.tmpl": {Data: []byte(`Hello {{ . }}`), ModTime: modTime},
+	}, []string{".tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	if err := x.ServeTemplate(rec, httptest.NewRequest("GET", "/", nil), "page.tmpl", "world"); err != nil {
+		t.Fatal(err)
+	}
+	etag := rec.Header().Get("ETag")
+	if rec.Code != http.StatusOK || rec.Body.String() != "Hello world" || etag == "" {
+		t.Fatalf("Expected 200 with body and ETag, got %d %q %q", rec.Code, rec.Body.String(), etag)
+	}
+	if e, a := modTime.Format(http.TimeFormat), rec.Header().Get("Last-Modified"); a != e {
+		t.Errorf("Expected Last-Modified %q, got %q", e, a)
+	}
+
+	tests := []struct {
+		header string
+		value  string
+		data   string
+		status int
+	}{
+		{"If-None-Match", etag, "world", http.StatusNotModified},
+		{"If-None-Match", etag, "there", http.StatusOK},
+		{"If-Modified-Since", modTime.Format(http.TimeFormat), "world", http.StatusNotModified},
+		{"If-Modified-Since", modTime.Add(-time.Hour).Format(http.TimeFormat), "world", http.StatusOK},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set(test.header, test.value)
+		rec := httptest.NewRecorder()
+		if err := x.ServeTemplate(rec, req, "page.tmpl", test.data); err != nil {
+			t.Fatal(err)
+		}
+		if rec.Code != test.status {
+			t.Errorf("%s %s: expected status %d, got %d", test.header, test.value, test.status, rec.Code)
+		}
+	}
+}
+
+func TestCacheFunc(t *testing.T) {
+	calls := 0
+	x := New().Funcs(template.FuncMap{
+		"expensive": func() int {
+			calls++
+			return calls
+		},
+	})
+	if err := x.ParseFS(fstest.MapFS{
+		"nav.tmpl":  {Data: []byte(`nav {{ expensive }} {{ . }}`)},
+		"page.tmpl": {Data: []byte(`{{ cache (printf "nav-%s" .) "5m" "nav.tmpl" . }}`)},
+	}, []string{".tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		data string
+		out  string
+	}{
+		{"a", "nav 1 a"},
+		{"a", "nav 1 a"},
+		{"b", "nav 2 b"},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		if err := x.ExecuteTemplate(&buf, "page.tmpl", test.data); err != nil {
+			t.Fatal(err)
+		}
+		if a := buf.String(); a != test.out {
+			t.Errorf("Expected %q, got %q", test.out, a)
+		}
+	}
+}
+
+func TestLRUCache(t *testing.T) {
+	c := NewLRUCache(2)
+	c.Set("a", []byte("a"), 0)
+	c.Set("b", []byte("b"), 0)
+	c.Get("a")
+	c.Set("c", []byte("c"), 0)
+	c.Set("d", []byte("d"), time.Nanosecond)
+	time.Sleep(time.Millisecond)
+
+	for key, e := range map[string]bool{"a": false, "b": false, "c": true, "d": false} {
+		if _, ok := c.Get(key); ok != e {
+			t.Errorf("Expected %q to be cached: %v, got %v", key, e, ok)
+		}
+	}
+}
+
+func TestExecuteTemplateCached(t *testing.T) {
+	calls := 0
+	x := New(WithResponseCache(10, time.Minute)).Funcs(template.FuncMap{
+		"expensive": func() int {
+			calls++
+			return calls
+		},
+	})
+	fsys := fstest.MapFS{
+		"page.tmpl": {Data: []byte(`page {{ expensive }} {{ . }}`)},
+	}
+	if err := x.ParseFS(fsys, []string{".tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		key string
+		out string
+	}{
+		{"a", "page 1 a"},
+		{"a", "page 1 a"},
+		{"b", "page 2 b"},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		if err := x.ExecuteTemplateCached(&buf, "page.tmpl", test.key, test.key); err != nil {
+			t.Fatal(err)
+		}
+		if a := buf.String(); a != test.out {
+			t.Errorf("Expected %q, got %q", test.out, a)
+		}
+	}
+
+	// reloading purges the cache
+	fsys["page.tmpl"] = &fstest.MapFile{Data: []byte(`reloaded {{ expensive }} {{ . }}`)}
+	if err := x.ParseFS(fsys, []string{".tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := x.ExecuteTemplateCached(&buf, "page.tmpl", "a", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if e, a := "reloaded 3 a", buf.String(); a != e {
+		t.Errorf("Expected %q, got %q", e, a)
+	}
+}
+
+// flushRecorder records the output written before each flush
+type flushRecorder struct {
+	bytes.Buffer
+	flushed []string
+}
+
+func (r *flushRecorder) Flush() {
+	r.flushed = append(r.flushed, r.String())
+}
+
+func TestFlush(t *testing.T) {
+	x := New()
+	if err := x.ParseFS(fstest.MapFS{
+		"page.tmpl": {Data: []byte(`<head></head>{{ flush }}<body></body>`)},
+	}, []string{".tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+
+	r := &flushRecorder{}
+	if err := x.ExecuteTemplate(r, "page.tmpl", nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.flushed) != 1 || r.flushed[0] != "<head></head>" {
+		t.Errorf("Expected output to be flushed after head, got %q", r.flushed)
+	}
+	if e, a := "<head></head><body></body>", r.String(); a != e {
+		t.Errorf("Expected %q, got %q", e, a)
+	}
+}
+
+func TestExecuteTemplateParallel(t *testing.T) {
+	x := New()
+	if err := x.ParseFS(fstest.MapFS{
+		"base.tmpl": {Data: []byte(`<main>{{ async "a" . }}</main>{{ block "aside" . }}{{ end }}`)},
+		"page.tmpl": {Data: []byte("{{ extends \"base.tmpl\" }}\n" +
+			`{{ define "a" }}a {{ . }}{{ end }}{{ define "b" }}b {{ . }}{{ end }}{{ define "aside" }}<aside>{{ async "b" . }}</aside>{{ end }}`)},
+	}, []string{".tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+
+	slow := func(v string) BlockLoader {
+		return func(ctx context.Context, data interface{}) (interface{}, error) {
+			time.Sleep(50 * time.Millisecond)
+			return v, nil
+		}
+	}
+
+	start := time.Now()
+	var buf bytes.Buffer
+	if err := x.ExecuteTemplateParallel(context.Background(), &buf, "page.tmpl", "page", map[string]BlockLoader{
+		"a": slow("loaded a"),
+		"b": slow("loaded b"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if e, a := "<main>a loaded a</main><aside>b loaded b</aside>", buf.String(); a != e {
+		t.Errorf("Expected %q, got %q", e, a)
+	}
+	if d := time.Since(start); d > 90*time.Millisecond {
+		t.Errorf("Expected blocks to render concurrently, took %s", d)
+	}
+
+	// outside of ExecuteTemplateParallel, blocks are rendered synchronously with the given data
+	buf.Reset()
+	if err := x.ExecuteTemplate(&buf, "page.tmpl", "page"); err != nil {
+		t.Fatal(err)
+	}
+	if e, a := "<main>a page</main><aside>b page</aside>", buf.String(); a != e {
+		t.Errorf("Expected %q, got %q", e, a)
+	}
+}
+
+func TestExecuteTemplateParallelSections(t *testing.T) {
+	x := New(WithSections(), WithSandbox(Sandbox{MaxOutput: 1000, MaxRangeIterations: 20, MaxDepth: 3}))
+	if err := x.ParseFS(fstest.MapFS{
+		"base.tmpl": {Data: []byte(`<head>{{ yield "scripts" }}</head>{{ block "content" . }}{{ end }}`)},
+		"page.tmpl": {Data: []byte("{{ extends \"base.tmpl\" }}\n" +
+			`{{ define "content" }}{{ section "scripts" }}<script src="page.js"></script>{{ end }}{{ range . }}{{ async "item" . }}{{ end }}<p>page</p>{{ end }}` +
+			`{{ define "item" }}{{ range $i := . }}.{{ end }}{{ section "scripts" }}<script src="{{ len . }}.js"></script>{{ end }}{{ end }}`)},
+	}, []string{".tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+
+	data := [][]int{{1}, {1, 2}, {1, 2, 3}}
+	e := `<head><script src="page.js"></script><script src="1.js"></script><script src="2.js"></script><script src="3.js"></script></head>......<p>page</p>`
+	for i := 0; i < 10; i++ {
+		var buf bytes.Buffer
+		if err := x.ExecuteTemplateParallel(context.Background(), &buf, "page.tmpl", data, nil); err != nil {
+			t.Fatal(err)
+		}
+		if a := buf.String(); a != e {
+			t.Errorf("Expected %q, got %q", e, a)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := x.ExecuteTemplate(&buf, "page.tmpl", data); err != nil {
+		t.Fatal(err)
+	}
+	if a := buf.String(); a != e {
+		t.Errorf("Expected %q, got %q", e, a)
+	}
+
+	// the blocks share the limits of the execution
+	data = append(data, []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12})
+	if err := x.ExecuteTemplateParallel(context.Background(), io.Discard, "page.tmpl", data, nil); !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("Expected ErrLimitExceeded, got %v", err)
+	}
+}
+
+func TestExecuteTemplateParallelComponents(t *testing.T) {
+	x := New(WithComponents("components/"))
+	if err := x.ParseFS(fstest.MapFS{
+		"page.tmpl":            {Data: []byte(`A{{ async "block" . }}B{{ define "block" }}[{{ component "bold" }}{{ . }}{{ end }}]{{ end }}`)},
+		"components/bold.tmpl": {Data: []byte(`<b>{{ slot }}{{ end }}</b>`)},
+	}, []string{".tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+
+	e := "A[<b>label</b>]B"
+	var buf bytes.Buffer
+	if err := x.ExecuteTemplate(&buf, "page.tmpl", "label"); err != nil {
+		t.Fatal(err)
+	}
+	if a := buf.String(); a != e {
+		t.Errorf("Expected %q, got %q", e, a)
+	}
+
+	buf.Reset()
+	if err := x.ExecuteTemplateParallel(context.Background(), &buf, "page.tmpl", "label", nil); err != nil {
+		t.Fatal(err)
+	}
+	if a := buf.String(); a != e {
+		t.Errorf("Expected %q, got %q", e, a)
+	}
+}
+
+func TestBlockData(t *testing.T) {
+	x := New().BlockData("sidebar", func(ctx context.Context, data interface{}) (interface{}, error) {
+		return fmt.Sprintf("sidebar for %s (%s)", data, templateName(ctx)), nil
+	})
+	if err := x.ParseFS(fstest.MapFS{
+		"page.tmpl": {Data: []byte(`{{ define "sidebar" }}{{ . }}{{ end }}{{ template "sidebar" blockData "sidebar" . }}|{{ async "sidebar" . }}`)},
+	}, []string{".tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+
+	e := "sidebar for page (page.tmpl)|sidebar for page (page.tmpl)"
+	var buf bytes.Buffer
+	if err := x.ExecuteTemplate(&buf, "page.tmpl", "page"); err != nil {
+		t.Fatal(err)
+	}
+	if a := buf.String(); a != e {
+		t.Errorf("Expected %q, got %q", e, a)
+	}
+
+	buf.Reset()
+	if err := x.ExecuteTemplateParallel(context.Background(), &buf, "page.tmpl", "page", nil); err != nil {
+		t.Fatal(err)
+	}
+	if a := buf.String(); a != e {
+		t.Errorf("Expected %q, got %q", e, a)
+	}
+}
+
+func TestParseLoader(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"base.tmpl":  `base {{ block "content" . }}{{ end }}`,
+		"child.tmpl": "{{ extends \"base.tmpl\" }}\n{{ define \"content\" }}child{{ end }}",
+		"page.tmpl":  `page`,
+		"other.txt":  `other`,
+	}
+	for name, c := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(c), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	l := &FSLoader{FS: os.DirFS(dir), Extensions: []string{".tmpl"}, Interval: time.Millisecond}
+
+	x := New()
+	if err := x.ParseLoader(context.Background(), l); err != nil {
+		t.Fatal(err)
+	}
+	if x.Lookup("other.txt") != nil {
+		t.Error("Expected other.txt not to be parsed")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- x.WatchLoader(ctx, l, func(err error) {
+			t.Error(err)
+		})
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	if err := os.WriteFile(filepath.Join(dir, "base.tmpl"), []byte(`changed {{ block "content" . }}{{ end }}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	for i := 0; i < 100; i++ {
+		buf.Reset()
+		if err := x.ExecuteTemplate(&buf, "child.tmpl", nil); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() == "changed child" {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if e, a := "changed child", buf.String(); a != e {
+		t.Errorf("Expected %q, got %q", e, a)
+	}
+
+	// removed files are dropped, templates from other sources are kept
+	if err := x.SetTemplate("own.tmpl", `own`); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(dir, "page.tmpl")); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100 && x.Lookup("page.tmpl") != nil; i++ {
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	if x.Lookup("page.tmpl") != nil {
+		t.Error("Expected removed page.tmpl to be dropped")
+	}
+	for _, name := range []string{"child.tmpl", "own.tmpl"} {
+		if err := x.ExecuteTemplate(io.Discard, name, nil); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
+
+func TestSetTemplate(t *testing.T) {
+	x := New()
+	if err := x.ParseFS(fstest.MapFS{
+		"base.tmpl":  {Data: []byte(`base {{ block "content" . }}{{ end }}`)},
+		"child.tmpl": {Data: []byte("{{ extends \"base.tmpl\" }}\n{{ define \"content\" }}child{{ end }}")},
+	}, []string{".tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		contents string
+		err      bool
+		out      string
+	}{
+		{"base.tmpl", `edited {{ block "content" . }}{{ end }}`, false, "edited child"},
+		{"base.tmpl", `broken {{ if }}`, true, "edited child"},
+		{"child.tmpl", "{{ extends \"base.tmpl\" }}\n{{ define \"content\" }}{{ undefinedFunc }}{{ end }}", true, "edited child"},
+		{"child.tmpl", "{{ extends \"base.tmpl\" }}\n{{ define \"content\" }}new child{{ end }}", false, "edited new child"},
+		{"new.tmpl", `{{ if }}`, true, "edited new child"},
+	}
+	for _, test := range tests {
+		if err := x.SetTemplate(test.name, test.contents); (err != nil) != test.err {
+			t.Errorf("%s: expected error %v, got %v", test.contents, test.err, err)
+		}
+
+		var buf bytes.Buffer
+		if err := x.ExecuteTemplate(&buf, "child.tmpl", nil); err != nil {
+			t.Fatal(err)
+		}
+		if a := buf.String(); a != test.out {
+			t.Errorf("%s: expected %q, got %q", test.contents, test.out, a)
+		}
+	}
+
+	if x.Lookup("new.tmpl") != nil {
+		t.Error("Expected failing new template not to be added")
+	}
+}
+
+func TestSnapshotRollback(t *testing.T) {
+	x := New(WithSnapshotHistory(2))
+	if err := x.SetTemplate("page.tmpl", "v1"); err != nil {
+		t.Fatal(err)
+	}
+	v1 := x.Snapshot()
+
+	if err := x.SetTemplate("page.tmpl", "v2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := x.SetTemplate("new.tmpl", "new"); err != nil {
+		t.Fatal(err)
+	}
+	v2 := x.Snapshot()
+
+	if err := x.Rollback(v1); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := x.ExecuteTemplate(&buf, "page.tmpl", nil); err != nil {
+		t.Fatal(err)
+	}
+	if e, a := "v1", buf.String(); a != e {
+		t.Errorf("Expected %q, got %q", e, a)
+	}
+	if x.Lookup("new.tmpl") != nil {
+		t.Error("Expected new.tmpl to be removed by rollback")
+	}
+
+	if err := x.Rollback(v2); err != nil {
+		t.Fatal(err)
+	}
+	if x.Lookup("new.tmpl") == nil {
+		t.Error("Expected new.tmpl to be restored by rollback")
+	}
+
+	// only the 2 most recent snapshots are kept
+	x.Snapshot()
+	if err := x.Rollback(v1); err == nil {
+		t.Error("Expected error rolling back to discarded snapshot, got none")
+	}
+}
+
+func TestExecuteVariant(t *testing.T) {
+	x := New()
+	if err := x.ParseFS(fstest.MapFS{
+		"pricing.tmpl":   {Data: []byte(`pricing`)},
+		"pricing.b.tmpl": {Data: []byte(`pricing b`)},
+	}, []string{".tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]string{
+		"b": "pricing b",
+		"c": "pricing",
+		"":  "pricing",
+	}
+	for variant, e := range tests {
+		var buf bytes.Buffer
+		if err := x.ExecuteVariant(&buf, "pricing.tmpl", variant, nil); err != nil {
+			t.Fatal(err)
+		}
+		if a := buf.String(); a != e {
+			t.Errorf("variant %q: expected %q, got %q", variant, e, a)
+		}
+	}
+}
+
+func TestUnusedSince(t *testing.T) {
+	x := New().RecordUsage()
+	if err := x.ParseFS(fstest.MapFS{
+		"base.tmpl":     {Data: []byte(`{{ template "header" }}{{ block "content" . }}{{ end }}`)},
+		"partials.tmpl": {Data: []byte(`{{ define "header" }}header{{ end }}`)},
+		"page.tmpl":     {Data: []byte("{{ extends \"base.tmpl\" }}\n{{ define \"content\" }}page{{ end }}")},
+		"old.tmpl":      {Data: []byte("{{ extends \"base.tmpl\" }}\n{{ define \"content\" }}old{{ end }}")},
+		"dead.tmpl":     {Data: []byte(`dead`)},
+	}, []string{".tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	if err := x.ExecuteTemplate(io.Discard, "page.tmpl", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	e := []string{"dead.tmpl", "old.tmpl"}
+	if a := x.UnusedSince(start); !reflect.DeepEqual(a, e) {
+		t.Errorf("Expected %v, got %v", e, a)
+	}
+}
+
+func TestCoverage(t *testing.T) {
+	x := New(WithCoverage())
+	if err := x.ParseFS(fstest.MapFS{
+		"base.tmpl": {Data: []byte("<p>{{ block \"content\" . }}{{ end }}</p>")},
+		"page.tmpl": {Data: []byte("{{ extends \"base.tmpl\" }}\n{{ define \"content\" }}{{ if . }}yes{{ else }}no{{ end }}{{ end }}")},
+	}, []string{".tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := x.ExecuteTemplate(&buf, "page.tmpl", true); err != nil {
+		t.Fatal(err)
+	}
+	if e, a := "<p>yes</p>", buf.String(); a != e {
+		t.Errorf("Expected %q, got %q", e, a)
+	}
+
+	e := []CoverageEntry{
+		{"base.tmpl", 1, 1, "template", 1},
+		{"base.tmpl", 1, 27, "template", 0},
+		{"page.tmpl", 2, 23, "template", 1},
+		{"page.tmpl", 2, 33, "if", 1},
+		{"page.tmpl", 2, 46, "else", 0},
+	}
+	if a := x.Coverage(); !reflect.DeepEqual(a, e) {
+		t.Errorf("Expected %v, got %v", e, a)
+	}
+
+	buf.Reset()
+	if err := x.WriteCoverageReport(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if e, a := "coverage: 60.0% of 5 blocks executed\n", buf.String(); !strings.HasSuffix(a, e) {
+		t.Errorf("Expected report ending in %q, got %q", e, a)
+	}
+}
+
+func TestLint(t *testing.T) {
+	x := New()
+	if err := x.ParseFS(fstest.MapFS{
+		"base.tmpl":    {Data: []byte(`{{ block "content" . }}{{ end }}{{ template "header" }}{{ template "footer" }}`)},
+		"header.tmpl":  {Data: []byte(`{{ define "header" }}a{{ end }}`)},
+		"header2.tmpl": {Data: []byte(`{{ define "header" }}b{{ end }}`)},
+		"page.tmpl": {Data: []byte("{{ extends \"base.tmpl\" }}\n" +
+			`{{ define "content" }}{{ template "helper" }}{{ end }}{{ define "helper" }}{{ end }}{{ define "footer" }}{{ end }}{{ define "contnet" }}{{ end }}`)},
+	}, []string{".tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+
+	e := []LintIssue{
+		{"header.tmpl", `template "header" is also defined in header2.tmpl`},
+		{"header2.tmpl", `template "header" is also defined in header.tmpl`},
+		{"page.tmpl", `template "contnet" is not a block in layout "base.tmpl" and never invoked`},
+	}
+	if a := x.Lint(); !reflect.DeepEqual(a, e) {
+		t.Errorf("Expected %v, got %v", e, a)
+	}
+}
+
+func TestParseTree(t *testing.T) {
+	x := parseExamples(t, New())
+
+	tree, err := x.ParseTree("child.tmpl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tree.Name != "child.tmpl" || tree.Root == nil {
+		t.Errorf("Expected tree for child.tmpl, got %v", tree)
+	}
+
+	trees, err := x.ParseTrees("child.tmpl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"child.tmpl", "content", "partials/question.tmpl"} {
+		if trees[name] == nil {
+			t.Errorf("Expected tree for %q", name)
+		}
+	}
+
+	if _, err := x.ParseTree("foo.tmpl"); err == nil {
+		t.Error("Expected error for unexisting template, got none")
+	}
+}
+
+func TestFormat(t *testing.T) {
+	src := "{{extends 'base.tmpl'}}\n" +
+		"{{define \"content\"}}\n" +
+		"<p>{{.Title}}</p>\n" +
+		"    {{if .Items}}\n" +
+		"  <ul>{{range .Items}}<li>{{ . }}</li>{{end}}</ul>\n" +
+		"{{else}}\n" +
+		"        <pre>\n" +
+		"  keep {{- \"}}\" -}}\n" +
+		"</pre>\n" +
+		"   \n" +
+		"  {{/* comment */}}\n" +
+		"{{end}}\n" +
+		"{{end}}\n" +
+		"  <p>{{   .Footer}}</p>\n"
+	e := "{{ extends \"base.tmpl\" }}\n" +
+		"{{ define \"content\" }}\n" +
+		"\t<p>{{ .Title }}</p>\n" +
+		"\t{{ if .Items }}\n" +
+		"\t\t<ul>{{ range .Items }}<li>{{ . }}</li>{{ end }}</ul>\n" +
+		"\t{{ else }}\n" +
+		"\t\t<pre>\n" +
+		"  keep {{- \"}}\" -}}\n" +
+		"</pre>\n" +
+		"\n" +
+		"\t\t{{/* comment */}}\n" +
+		"\t{{ end }}\n" +
+		"{{ end }}\n" +
+		"  <p>{{ .Footer }}</p>\n"
+
+	a, err := Format([]byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(a) != e {
+		t.Errorf("Expected\n%s\ngot\n%s", e, a)
+	}
+
+	again, err := Format(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(again) != string(a) {
+		t.Errorf("Expected formatting to be idempotent, got\n%s", again)
+	}
+
+	if _, err := Format([]byte("{{ if ")); err == nil {
+		t.Error("Expected error for unterminated action, got none")
+	}
+}
+
+func TestDefault(t *testing.T) {
+	defer func(x *Extemplate) { Default = x }(Default)
+	Default = New()
+
+	Funcs(template.FuncMap{"upper": strings.ToUpper})
+	if err := ParseFS(fstest.MapFS{
+		"hello.tmpl": {Data: []byte(`Hello {{ upper . }}`)},
+	}, []string{".tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+	if Lookup("hello.tmpl") == nil {
+		t.Error("Expected template to exist in default instance")
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if err := ExecuteTemplate(buf, "hello.tmpl", "world"); err != nil {
+		t.Fatal(err)
+	}
+	if e, a := "Hello WORLD", buf.String(); a != e {
+		t.Errorf("Expected %q, got %q", e, a)
+	}
+}
+
+func TestMust(t *testing.T) {
+	broken := t.TempDir()
+	if err := os.WriteFile(filepath.Join(broken, "broken.tmpl"), []byte("{{ if }}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	x := Must(New(), nil).Funcs(template.FuncMap{"tolower": strings.ToLower}).MustParseDir("examples", []string{".tmpl"})
+	if x.MustLookup("child.tmpl") == nil {
+		t.Error("Expected template, got nil")
+	}
+
+	for name, fn := range map[string]func(){
+		"Must":         func() { Must(nil, errors.New("broken")) },
+		"MustParseDir": func() { New().MustParseDir(broken, []string{".tmpl"}) },
+		"MustLookup":   func() { x.MustLookup("unexisting.tmpl") },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: expected panic, got none", name)
+				}
+			}()
+			fn()
+		}()
+	}
+}
+
+func TestShared(t *testing.T) {
+	x := New()
+	if err := x.ParseFS(fstest.MapFS{
+		"base.tmpl":          {Data: []byte(`<main>{{ block "content" . }}{{ end }}</main>`)},
+		"index.tmpl":         {Data: []byte("{{ extends \"base.tmpl\" }}\n{{ define \"content\" }}index{{ end }}")},
+		"partials/card.tmpl": {Data: []byte(`{{ define "card" }}<div>{{ . }}</div>{{ end }}`)},
+	}, []string{".tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+
+	s := x.Shared()
+	if s == nil {
+		t.Fatal("Expected shared set, got nil")
+	}
+	for _, name := range []string{"base.tmpl", "partials/card.tmpl", "card"} {
+		if s.Lookup(name) == nil {
+			t.Errorf("Expected shared set to contain %q", name)
+		}
+	}
+	if s.Lookup("index.tmpl") != nil {
+		t.Error("Expected shared set not to contain child template index.tmpl")
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if err := s.ExecuteTemplate(buf, "card", "hi"); err != nil {
+		t.Fatal(err)
+	}
+	if e, a := "<div>hi</div>", buf.String(); a != e {
+		t.Errorf("Expected %q, got %q", e, a)
+	}
+
+	// changes to the copy do not affect x
+	template.Must(x.Shared().New("extra").Parse("extra"))
+	if x.Shared().Lookup("extra") != nil {
+		t.Error("Expected template added to copy not to be in shared set")
+	}
+	buf.Reset()
+	if err := x.ExecuteTemplate(buf, "index.tmpl", nil); err != nil {
+		t.Fatal(err)
+	}
+	if e, a := "<main>index</main>", buf.String(); a != e {
+		t.Errorf("Expected %q, got %q", e, a)
+	}
+}
+
+func TestAddFuncs(t *testing.T) {
+	x := New().Funcs(template.FuncMap{"greet": func() string { return "hello" }})
+	if err := x.ParseFS(fstest.MapFS{
+		"base.tmpl":  {Data: []byte(`<p>{{ block "content" . }}{{ end }}</p>`)},
+		"index.tmpl": {Data: []byte("{{ extends \"base.tmpl\" }}\n{{ define \"content\" }}{{ greet }}{{ end }}")},
+		"plain.tmpl": {Data: []byte(`{{ greet }}`)},
+	}, []string{".tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+	render := func(name string) string {
+		t.Helper()
+		buf := bytes.NewBuffer(nil)
+		if err := x.ExecuteTemplate(buf, name, nil); err != nil {
+			t.Fatal(err)
+		}
+		return buf.String()
+	}
+	render("index.tmpl")
+
+	// overwriting a func after parsing recompiles templates calling it
+	if err := x.AddFuncs(template.FuncMap{"greet": func() string { return "hi" }}); err != nil {
+		t.Fatal(err)
+	}
+	for name, e := range map[string]string{"index.tmpl": "<p>hi</p>", "plain.tmpl": "hi"} {
+		if a := render(name); a != e {
+			t.Errorf("%s: expected %q, got %q", name, e, a)
+		}
+	}
+
+	// new funcs can be used by templates parsed afterwards
+	x.Funcs(template.FuncMap{"shout": strings.ToUpper})
+	if err := x.SetTemplate("shout.tmpl", `{{ shout "hi" }}`); err != nil {
+		t.Fatal(err)
+	}
+	if e, a := "HI", render("shout.tmpl"); a != e {
+		t.Errorf("Expected %q, got %q", e, a)
+	}
+
+	if err := x.AddFuncs(template.FuncMap{"invalid": 1}); err == nil {
+		t.Error("Expected error adding invalid func, got none")
+	}
+	if e, a := "<p>hi</p>", render("index.tmpl"); a != e {
+		t.Errorf("Expected %q, got %q", e, a)
+	}
+}
+
+func TestExecuteTemplateStats(t *testing.T) {
+	fsys := fstest.MapFS{
+		"base.tmpl":          {Data: []byte(`<main>{{ block "content" . }}{{ end }}</main>{{ include "footer.tmpl" }}`)},
+		"index.tmpl":         {Data: []byte("{{ extends \"base.tmpl\" }}\n{{ define \"content\" }}{{ range . }}{{ template \"card\" . }}{{ end }}{{ end }}")},
+		"footer.tmpl":        {Data: []byte(`<footer></footer>`)},
+		"partials/card.tmpl": {Data: []byte(`{{ define "card" }}<div>{{ . }}</div>{{ end }}`)},
+		"unused.tmpl":        {Data: []byte(`{{ define "unused" }}{{ end }}`)},
+	}
+	e := "<main><div>a</div><div>b</div></main><footer></footer>"
+
+	x := New()
+	if err := x.ParseFS(fsys, []string{".tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+	buf := bytes.NewBuffer(nil)
+	stats, err := x.ExecuteTemplateStats(buf, "index.tmpl", []string{"a", "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != e {
+		t.Errorf("Expected %q, got %q", e, buf.String())
+	}
+	if stats.Bytes != int64(len(e)) || stats.Duration <= 0 || stats.Templates != nil {
+		t.Errorf("Expected %d bytes, a duration and no templates, got %+v", len(e), stats)
+	}
+
+	// tracked templates work with the sandbox probes, also after parsing again
+	x = New(WithTemplateTracking(), WithSandbox(Sandbox{MaxDepth: 10}))
+	for i := 0; i < 2; i++ {
+		if err := x.ParseFS(fsys, []string{".tmpl"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	buf.Reset()
+	stats, err = x.ExecuteTemplateStats(buf, "index.tmpl", []string{"a", "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != e {
+		t.Errorf("Expected %q, got %q", e, buf.String())
+	}
+	if e, a := "[card content footer.tmpl index.tmpl]", fmt.Sprint(stats.Templates); a != e {
+		t.Errorf("Expected templates %s, got %s", e, a)
+	}
+
+	if _, err := x.ExecuteTemplateStats(io.Discard, "unexisting.tmpl", nil); !errors.Is(err, ErrTemplateNotFound) {
+		t.Errorf("Expected ErrTemplateNotFound, got %v", err)
+	}
+}
+
+func TestProfiling(t *testing.T) {
+	var mu sync.Mutex
+	var timings []string
+	x := New(WithProfiling(func(ctx context.Context, name string, d time.Duration) {
+		mu.Lock()
+		timings = append(timings, name)
+		mu.Unlock()
+		if d < 0 {
+			t.Errorf("%s: expected non-negative duration, got %s", name, d)
+		}
+	})).Funcs(template.FuncMap{
+		"fail": func(fail bool) (string, error) {
+			if fail {
+				return "", errors.New("failed")
+			}
+			return "", nil
+		},
+	})
+	if err := x.ParseFS(fstest.MapFS{
+		"base.tmpl":          {Data: []byte(`<main>{{ block "content" . }}{{ end }}</main>{{ include "footer.tmpl" }}`)},
+		"index.tmpl":         {Data: []byte("{{ extends \"base.tmpl\" }}\n{{ define \"content\" }}{{ range . }}{{ template \"card\" . }}{{ end }}{{ end }}")},
+		"footer.tmpl":        {Data: []byte(`<footer></footer>`)},
+		"partials/card.tmpl": {Data: []byte(`{{ define "card" }}<div>{{ fail (eq . "fail") }}{{ . }}</div>{{ end }}`)},
+	}, []string{".tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := x.ExecuteTemplate(io.Discard, "index.tmpl", []string{"a", "b"}); err != nil {
+		t.Fatal(err)
+	}
+	if e, a := "[card card content footer.tmpl index.tmpl]", fmt.Sprint(timings); a != e {
+		t.Errorf("Expected timings of %s, got %s", e, a)
+	}
+
+	// templates that fail are not reported, and do not affect later executions
+	timings = nil
+	if err := x.ExecuteTemplate(io.Discard, "index.tmpl", []string{"fail"}); err == nil {
+		t.Fatal("Expected error, got none")
+	}
+	if err := x.ExecuteTemplate(io.Discard, "footer.tmpl", nil); err != nil {
+		t.Fatal(err)
+	}
+	if e, a := "[footer.tmpl]", fmt.Sprint(timings); a != e {
+		t.Errorf("Expected timings of %s, got %s", e, a)
+	}
+}
+
+func TestParseStats(t *testing.T) {
+	fsys := fstest.MapFS{
+		"base.tmpl":          {Data: []byte(`<main>{{ block "content" . }}{{ end }}</main>`)},
+		"blog.tmpl":          {Data: []byte("{{ extends \"base.tmpl\" }}\n{{ define \"content\" }}{{ block \"post\" . }}{{ end }}{{ end }}")},
+		"blog/post.tmpl":     {Data: []byte("{{ extends \"blog.tmpl\" }}\n{{ define \"post\" }}post{{ end }}")},
+		"index.tmpl":         {Data: []byte("{{ extends \"base.tmpl\" }}\n{{ define \"content\" }}index{{ end }}")},
+		"partials/card.tmpl": {Data: []byte(`{{ define "card" }}<div>{{ . }}</div>{{ end }}`)},
+	}
+	x := New()
+	if err := x.ParseFS(fsys, []string{".tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+
+	stats := x.ParseStats()
+	if stats.Files != len(fsys) {
+		t.Errorf("Expected %d files, got %d", len(fsys), stats.Files)
+	}
+	if stats.Bytes <= 0 {
+		t.Errorf("Expected total size, got %d", stats.Bytes)
+	}
+	if len(stats.Durations) != len(fsys) || len(stats.Slowest) != len(fsys) {
+		t.Errorf("Expected durations of all templates, got %v and %v", stats.Durations, stats.Slowest)
+	}
+	for i := 1; i < len(stats.Slowest); i++ {
+		if stats.Durations[stats.Slowest[i]] > stats.Durations[stats.Slowest[i-1]] {
+			t.Errorf("Expected slowest templates first, got %v", stats.Slowest)
+		}
+	}
+	if e, a := "map[0:2 1:2 2:1]", fmt.Sprint(stats.Depths); a != e {
+		t.Errorf("Expected depths %s, got %s", e, a)
+	}
+}
+
+func TestReloadKeepsPreviousVersion(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, contents string, modTime time.Time) {
+		t.Helper()
+		file := filepath.Join(dir, name)
+		if err := os.WriteFile(file, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(file, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	now := time.Now()
+	write("base.tmpl", `<main>{{ block "content" . }}{{ end }}</main>`, now)
+	write("child.tmpl", "{{ extends \"base.tmpl\" }}\n{{ define \"content\" }}child{{ end }}", now)
+
+	var reloaded []string
+	var errs []error
+	x := New(WithEnvironment(Dev)).OnReload(func(paths []string) {
+		reloaded = append(reloaded, paths...)
+	}).OnReloadError(func(err error) {
+		errs = append(errs, err)
+	})
+	if err := x.ParseDir(dir, []string{".tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+	render := func(e string) {
+		t.Helper()
+		x.lastReload = time.Time{}
+		buf := bytes.NewBuffer(nil)
+		if err := x.ExecuteTemplate(buf, "child.tmpl", nil); err != nil {
+			t.Fatal(err)
+		}
+		if a := buf.String(); a != "<!-- begin child.tmpl -->"+e+"<!-- end child.tmpl -->" {
+			t.Errorf("Expected %q, got %q", e, a)
+		}
+	}
+
+	// a broken layout is reported once, while the previous version keeps being used
+	write("base.tmpl", `<main>{{ block "content" . }}</main>`, now.Add(time.Hour))
+	render("<main>child</main>")
+	render("<main>child</main>")
+	if len(errs) != 1 || len(reloaded) != 0 {
+		t.Errorf("Expected 1 reload error and no reloads, got %v and %v", errs, reloaded)
+	}
+
+	write("base.tmpl", `<div>{{ block "content" . }}{{ end }}</div>`, now.Add(2*time.Hour))
+	render("<div>child</div>")
+	if e, a := "[base.tmpl]", fmt.Sprint(reloaded); a != e || len(errs) != 1 {
+		t.Errorf("Expected reloads of %s and no new errors, got %s and %v", e, a, errs)
+	}
+
+	// new files that fail to parse are not added
+	write("other.tmpl", `{{ if }}`, now)
+	if err := x.ReloadFile("other.tmpl"); err == nil {
+		t.Error("Expected error reloading broken file, got none")
+	}
+	if x.Lookup("other.tmpl") != nil {
+		t.Error("Expected broken file not to be added")
+	}
+	render("<div>child</div>")
+}
+
+func TestParseRetryAfterError(t *testing.T) {
+	fsys := fstest.MapFS{
+		"a.tmpl": {Data: []byte(`{{ if }}`)},
+		"z.tmpl": {Data: []byte(`z`)},
+	}
+	x := New()
+	if err := x.ParseFS(fsys, []string{".tmpl"}); err == nil {
+		t.Fatal("Expected error parsing broken template, got none")
+	}
+
+	fsys["a.tmpl"] = &fstest.MapFile{Data: []byte(`a`)}
+	if err := x.ParseFS(fsys, []string{".tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.tmpl", "z.tmpl"} {
+		buf := bytes.NewBuffer(nil)
+		if err := x.ExecuteTemplate(buf, name, nil); err != nil {
+			t.Fatal(err)
+		}
+		if e := strings.TrimSuffix(name, ".tmpl"); buf.String() != e {
+			t.Errorf("Expected %q, got %q", e, buf.String())
+		}
+	}
+}
@@ -0,0 +1,50 @@
+// Copyright 2017 Danny van Kooten. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package extemplate
+
+import (
+	"text/template/parse"
+)
+
+// ParseTree returns a copy of the parse tree of the named template, with its layout chain applied.
+// Templates it defines or invokes are available through ParseTrees.
+func (x *Extemplate) ParseTree(name string) (*parse.Tree, error) {
+	trees, err := x.ParseTrees(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return trees[x.resolve(name)], nil
+}
+
+// ParseTrees returns copies of the parse trees of all templates in the compiled set of the named template, by name.
+// This includes the template itself, the blocks and templates it defines after applying its layout chain,
+// and all shared templates. Copies are returned, so tooling can modify them without affecting the set.
+func (x *Extemplate) ParseTrees(name string) (map[string]*parse.Tree, error) {
+	name = x.resolve(name)
+	if _, err := x.pool(name); err != nil {
+		return nil, err
+	}
+
+	x.mu.RLock()
+	var all []*parse.Tree
+	if t, ok := x.templates[name]; ok {
+		all = htmlTrees(t)
+	} else if t, ok := x.texts[name]; ok {
+		all = textTrees(t)
+	}
+	x.mu.RUnlock()
+	if all == nil {
+		return nil, notFound(name)
+	}
+
+	trees := make(map[string]*parse.Tree, len(all))
+	for _, tree := range all {
+		if tree != nil && tree.Name != "" {
+			trees[tree.Name] = tree.Copy()
+		}
+	}
+	return trees, nil
+}
@@ -0,0 +1,76 @@
+// Copyright 2017 Danny van Kooten. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package extemplate
+
+import (
+	"bytes"
+	"strings"
+)
+
+// WithTrimBlocks trims whitespace around define and block boundaries at parse time,
+// as if all define, block and their corresponding end actions were written as {{- ... -}}.
+// This prevents layouts from emitting blank lines between inherited blocks.
+// Whitespace around other actions closed by end, like if, section and component, is left as is.
+func WithTrimBlocks() Option {
+	return func(x *Extemplate) {
+		x.trimBlocks = true
+	}
+}
+
+// trimBlocks adds trim markers to all define and block actions in c, and to the end actions closing them.
+// blocks are the additional keywords closed by an end action, like section and component, which are left as is.
+func trimBlocks(c []byte, left, right string, blocks ...string) []byte {
+	var out bytes.Buffer
+	var stack []bool
+
+	for {
+		start := bytes.Index(c, []byte(left))
+		if start < 0 {
+			break
+		}
+		end := bytes.Index(c[start+len(left):], []byte(right))
+		if end < 0 {
+			break
+		}
+		end += start + len(left)
+
+		action := string(c[start+len(left) : end])
+		inner := strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(action), "-"), "-"))
+		fields := strings.Fields(inner)
+
+		trim := false
+		if len(fields) > 0 {
+			switch fields[0] {
+			case "define", "block":
+				stack = append(stack, true)
+				trim = true
+			case "if", "range", "with":
+				stack = append(stack, false)
+			case "end":
+				if len(stack) > 0 {
+					trim = stack[len(stack)-1]
+					stack = stack[:len(stack)-1]
+				}
+			default:
+				for _, b := range blocks {
+					if fields[0] == b {
+						stack = append(stack, false)
+					}
+				}
+			}
+		}
+
+		out.Write(c[:start])
+		if trim {
+			out.WriteString(left + "- " + inner + " -" + right)
+		} else {
+			out.Write(c[start : end+len(right)])
+		}
+		c = c[end+len(right):]
+	}
+
+	out.Write(c)
+	return out.Bytes()
+}
@@ -0,0 +1,79 @@
+// Copyright 2017 Danny van Kooten. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package extemplate
+
+import (
+	"bytes"
+	"html"
+	"net/http"
+	"strings"
+)
+
+// TurboStreamContentType is the content type of Turbo Stream responses
+const TurboStreamContentType = "text/vnd.turbo-stream.html"
+
+// TurboStream is a Turbo Stream action, rendered by RenderTurboStreams
+type TurboStream struct {
+	// Action is the stream action, like "append", "prepend", "replace", "update" or "remove"
+	Action string
+	// Target is the id of the element the action applies to
+	Target string
+	// Template is the name of the template rendered as content of the action, if any
+	Template string
+	// Block is the name of the block of the template to render, or "" to render the whole template
+	Block string
+	// Data is the data the template is executed with
+	Data interface{}
+}
+
+// AcceptsTurboStream reports whether r accepts a Turbo Stream response, as Turbo does for form submissions
+func AcceptsTurboStream(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), TurboStreamContentType)
+}
+
+// RenderTurboFrame renders the named template as response to r. When r is sent by a Turbo Frame and the template
+// has a block named after the id of the frame, only that block is rendered, wrapped in a matching turbo-frame element.
+// Otherwise the whole template is rendered, from which Turbo extracts the frame itself.
+func (x *Extemplate) RenderTurboFrame(w http.ResponseWriter, r *http.Request, name string, data interface{}) error {
+	w.Header().Add("Vary", "Turbo-Frame")
+	frame := r.Header.Get("Turbo-Frame")
+	if frame == "" || !x.hasBlock(name, frame) {
+		return x.render(r.Context(), w, http.StatusOK, name, data)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(`<turbo-frame id="` + html.EscapeString(frame) + `">`)
+	if err := x.ExecuteBlock(r.Context(), &buf, name, frame, data); err != nil {
+		return err
+	}
+	buf.WriteString(`</turbo-frame>`)
+	return writeBuffered(w, http.StatusOK, "text/html; charset=utf-8", &buf)
+}
+
+// RenderTurboStreams renders the given actions as a Turbo Stream response.
+// The output is buffered, so that nothing is written to w if rendering any of the actions fails.
+func (x *Extemplate) RenderTurboStreams(w http.ResponseWriter, r *http.Request, streams ...TurboStream) error {
+	var buf bytes.Buffer
+	for _, s := range streams {
+		buf.WriteString(`<turbo-stream action="` + html.EscapeString(s.Action) + `" target="` + html.EscapeString(s.Target) + `">`)
+		if s.Template != "" {
+			buf.WriteString("<template>")
+			var err error
+			if s.Block != "" {
+				err = x.ExecuteBlock(r.Context(), &buf, s.Template, s.Block, s.Data)
+			} else {
+				err = x.ExecuteTemplateContext(r.Context(), &buf, s.Template, s.Data)
+			}
+			if err != nil {
+				return err
+			}
+			buf.WriteString("</template>")
+		}
+		buf.WriteString("</turbo-stream>\n")
+	}
+
+	w.Header().Set("Content-Type", TurboStreamContentType+"; charset=utf-8")
+	return writeBuffered(w, http.StatusOK, TurboStreamContentType, &buf)
+}
@@ -0,0 +1,206 @@
+// Copyright 2017 Danny van Kooten. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package extemplate
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template/parse"
+	"time"
+)
+
+// ParseLimits limits the resources parsing an untrusted template may use, see ParseUntrusted.
+// A zero value for any of the limits means that it is not enforced.
+type ParseLimits struct {
+	// MaxSize is the maximum size of the template in bytes
+	MaxSize int
+	// MaxDepth is the maximum nesting depth of actions like if, range, with, define and block
+	MaxDepth int
+	// MaxNodes is the maximum number of nodes in the parse trees of the template
+	MaxNodes int
+	// Timeout is the maximum duration of parsing the template
+	Timeout time.Duration
+}
+
+// UntrustedError describes why ParseUntrusted rejected a template, in terms suitable for showing to its author
+type UntrustedError struct {
+	// Name is the name of the rejected template
+	Name string
+	// Line is the line in the template the error occurred at, or 0 if unknown
+	Line int
+	// Reason describes the error without the template name and line
+	Reason string
+	// Err is the underlying error, if any
+	Err error
+}
+
+func (e *UntrustedError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("%s:%d: %s", e.Name, e.Line, e.Reason)
+	}
+	return fmt.Sprintf("%s: %s", e.Name, e.Reason)
+}
+
+func (e *UntrustedError) Unwrap() error {
+	return e.Err
+}
+
+// templateErrorRegex matches the errors returned by text/template, like "template: name:12: unexpected EOF"
+var templateErrorRegex = regexp.MustCompile(`^template: [^:]*:(\d+):(?:\d+:)? (?:executing "[^"]*" at <[^>]*>: )?(.*)$`)
+
+// ParseUntrusted is like SetTemplate, but validates contents against limits first, for templates uploaded by users.
+// All errors are returned as an *UntrustedError.
+// When the timeout expires, the template is rejected, but parsing can not be interrupted and finishes in the background.
+func (x *Extemplate) ParseUntrusted(name string, contents string, limits ParseLimits) error {
+	if limits.MaxSize > 0 && len(contents) > limits.MaxSize {
+		return &UntrustedError{Name: name, Reason: fmt.Sprintf("template is larger than %d bytes", limits.MaxSize)}
+	}
+
+	tf, err := x.prepareFile(name, []byte(contents))
+	if err != nil {
+		return untrustedError(name, 0, err)
+	}
+
+	// check the nesting depth before parsing, as the parser recurses for every level
+	if line, depth := maxNesting(tf); limits.MaxDepth > 0 && depth > limits.MaxDepth {
+		return &UntrustedError{Name: name, Line: line + tf.offset, Reason: fmt.Sprintf("actions are nested more than %d deep", limits.MaxDepth)}
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- validateUntrusted(name, tf, limits.MaxNodes)
+	}()
+	var timeout <-chan time.Time
+	if limits.Timeout > 0 {
+		timer := time.NewTimer(limits.Timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case err = <-done:
+	case <-timeout:
+		return &UntrustedError{Name: name, Reason: fmt.Sprintf("parsing took longer than %s", limits.Timeout)}
+	}
+	if err == nil {
+		err = x.setFile(x.normalize(name), tf)
+	}
+	if err != nil {
+		return untrustedError(name, tf.offset, err)
+	}
+	return nil
+}
+
+// validateUntrusted parses tf on its own and checks the number of nodes in the resulting trees
+func validateUntrusted(name string, tf *templatefile, maxNodes int) error {
+	t := parse.New(name)
+	t.Mode = parse.SkipFuncCheck
+	trees := make(map[string]*parse.Tree)
+	if _, err := t.Parse(string(tf.contents), tf.leftDelim, tf.rightDelim, trees); err != nil {
+		return err
+	}
+
+	n := 0
+	for _, tree := range trees {
+		n += countNodes(tree.Root)
+	}
+	if maxNodes > 0 && n > maxNodes {
+		return fmt.Errorf("template has more than %d nodes", maxNodes)
+	}
+	return nil
+}
+
+// untrustedError converts err into an *UntrustedError, taking the line number from errors returned by text/template.
+// offset is the number of lines stripped from the start of the template.
+func untrustedError(name string, offset int, err error) *UntrustedError {
+	var pe *ParseError
+	if errors.As(err, &pe) {
+		err = pe.Err
+	}
+	if m := templateErrorRegex.FindStringSubmatch(err.Error()); m != nil {
+		line, _ := strconv.Atoi(m[1])
+		return &UntrustedError{Name: name, Line: line + offset, Reason: m[2], Err: err}
+	}
+	return &UntrustedError{Name: name, Reason: strings.TrimPrefix(err.Error(), "extemplate: "), Err: err}
+}
+
+// maxNesting returns the maximum nesting depth of the actions in tf and the line it first occurs at
+func maxNesting(tf *templatefile) (line int, deepest int) {
+	c := tf.contents
+	left, right := []byte(tf.leftDelim), []byte(tf.rightDelim)
+	depth, pos := 0, 0
+	for {
+		start := bytes.Index(c[pos:], left)
+		if start < 0 {
+			return line, deepest
+		}
+		start += pos
+		end := bytes.Index(c[start+len(left):], right)
+		if end < 0 {
+			return line, deepest
+		}
+		end += start + len(left)
+		pos = end + len(right)
+
+		action := strings.Trim(strings.TrimSpace(string(c[start+len(left):end])), "- \t\r\n")
+		fields := strings.Fields(action)
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "if", "range", "with", "define", "block":
+			depth++
+			if depth > deepest {
+				deepest = depth
+				line = 1 + bytes.Count(c[:start], []byte("\n"))
+			}
+		case "end":
+			depth--
+		}
+	}
+}
+
+// countNodes returns the number of nodes in the given node and its children
+func countNodes(node parse.Node) int {
+	n := 1
+	switch node := node.(type) {
+	case *parse.ListNode:
+		if node == nil {
+			return 0
+		}
+		for _, c := range node.Nodes {
+			n += countNodes(c)
+		}
+	case *parse.ActionNode:
+		n += countNodes(node.Pipe)
+	case *parse.TemplateNode:
+		if node.Pipe != nil {
+			n += countNodes(node.Pipe)
+		}
+	case *parse.PipeNode:
+		if node == nil {
+			return 0
+		}
+		for _, c := range node.Cmds {
+			n += countNodes(c)
+		}
+	case *parse.CommandNode:
+		for _, a := range node.Args {
+			n += countNodes(a)
+		}
+	case *parse.ChainNode:
+		n += countNodes(node.Node)
+	case *parse.IfNode:
+		n += countNodes(node.Pipe) + countNodes(node.List) + countNodes(node.ElseList)
+	case *parse.RangeNode:
+		n += countNodes(node.Pipe) + countNodes(node.List) + countNodes(node.ElseList)
+	case *parse.WithNode:
+		n += countNodes(node.Pipe) + countNodes(node.List) + countNodes(node.ElseList)
+	}
+	return n
+}
@@ -0,0 +1,31 @@
+// Copyright 2017 Danny van Kooten. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package extemplate
+
+import (
+	"fmt"
+)
+
+// URLResolver returns the URL of the named route, given its parameters
+type URLResolver func(name string, params ...interface{}) (string, error)
+
+// SetURLResolver sets the function used by the url template func to generate the URL of a route by name, e.g.
+//
+//	<a href="{{ url "post" "slug" .Slug }}">{{ .Title }}</a>
+//
+// This allows using the reverse routing of any router, like chi, gorilla/mux or echo.
+// The return value is the Extemplate instance, so calls can be chained.
+func (x *Extemplate) SetURLResolver(fn URLResolver) *Extemplate {
+	x.urlResolver = fn
+	return x
+}
+
+// urlFunc is the url template func, see SetURLResolver
+func (x *Extemplate) urlFunc(name string, params ...interface{}) (string, error) {
+	if x.urlResolver == nil {
+		return "", fmt.Errorf("extemplate: no URL resolver set to resolve route %q", name)
+	}
+	return x.urlResolver(name, params...)
+}
@@ -0,0 +1,100 @@
+// Copyright 2017 Danny van Kooten. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package extemplate
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// usage records when templates were last executed
+type usage struct {
+	mu   sync.Mutex
+	last map[string]time.Time
+}
+
+// RecordUsage enables recording when templates are executed, see UnusedSince.
+// The return value is the Extemplate instance, so calls can be chained.
+func (x *Extemplate) RecordUsage() *Extemplate {
+	x.usage = &usage{last: make(map[string]time.Time)}
+	return x
+}
+
+func (u *usage) record(name string) {
+	u.mu.Lock()
+	u.last[name] = time.Now()
+	u.mu.Unlock()
+}
+
+// UnusedSince returns the names of all templates that were not executed since t,
+// and are not extended or invoked by any template that was. Usage must be recorded using RecordUsage.
+func (x *Extemplate) UnusedSince(t time.Time) []string {
+	if x.usage == nil {
+		return nil
+	}
+
+	var executed []string
+	x.usage.mu.Lock()
+	for name, last := range x.usage.last {
+		if !last.Before(t) {
+			executed = append(executed, name)
+		}
+	}
+	x.usage.mu.Unlock()
+
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	used := x.reachable(executed)
+
+	var unused []string
+	for name := range x.files {
+		if !used[name] {
+			unused = append(unused, name)
+		}
+	}
+	sort.Strings(unused)
+	return unused
+}
+
+// reachable returns the names of the given templates and of all templates reachable from them
+// through extends and template invocations. The caller must hold x.mu.
+func (x *Extemplate) reachable(names []string) map[string]bool {
+	// map template names defined in shared files to the files defining them
+	definedIn := make(map[string][]string)
+	for name, tf := range x.files {
+		if tf.layout != "" {
+			continue
+		}
+		for _, d := range tf.defines {
+			definedIn[d] = append(definedIn[d], name)
+		}
+	}
+
+	seen := make(map[string]bool)
+	queue := append([]string(nil), names...)
+	for _, name := range names {
+		seen[name] = true
+	}
+	for len(queue) > 0 {
+		tf, ok := x.files[queue[0]]
+		queue = queue[1:]
+		if !ok {
+			continue
+		}
+
+		next := []string{tf.layout}
+		for _, u := range tf.uses {
+			next = append(next, definedIn[u]...)
+		}
+		for _, n := range next {
+			if n != "" && !seen[n] {
+				seen[n] = true
+				queue = append(queue, n)
+			}
+		}
+	}
+	return seen
+}
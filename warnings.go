@@ -0,0 +1,116 @@
+// Copyright 2017 Danny van Kooten. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package extemplate
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sort"
+)
+
+// Warning describes a suspicious condition found while parsing templates, which did not abort parsing
+type Warning struct {
+	// Name is the name of the template the warning is about
+	Name string
+	// Message describes the condition
+	Message string
+}
+
+func (w Warning) String() string {
+	return w.Name + ": " + w.Message
+}
+
+// emptyHash is the hash of an empty file
+var emptyHash = sha256.Sum256(nil)
+
+// Warnings returns the warnings found since templates were last parsed using ParseDir or ParseFS, in the order they were found.
+// Warnings are reported for empty files, for files extending a template that was not parsed, for example because
+// its extension is not in the list of extensions, for templates invoking a template that is not defined,
+// for templates defining a template that is already defined elsewhere,
+// and for files ignored because another file has the same name, see WithConflictPolicy.
+func (x *Extemplate) Warnings() []Warning {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return append([]Warning(nil), x.warnings...)
+}
+
+// warn records a warning about the named template. The caller must hold x.mu for writing.
+func (x *Extemplate) warn(name string, format string, args ...interface{}) {
+	w := Warning{Name: name, Message: fmt.Sprintf(format, args...)}
+	x.warnings = append(x.warnings, w)
+	x.log(levelWarn, "extemplate: "+w.Message, "template", name)
+}
+
+// checkFiles records warnings about the given changed files, after they were added to the set.
+// The caller must hold x.mu for writing.
+func (x *Extemplate) checkFiles(changed map[string]bool) {
+	var defined map[string]bool
+	for _, name := range sortedNames(changed) {
+		tf := x.files[name]
+		if tf.hash == emptyHash {
+			x.warn(name, "file is empty")
+		}
+		if _, ok := x.files[tf.layout]; tf.layout != "" && !ok {
+			x.warn(name, "extends %q, which is not a parsed template%s", tf.layout, didYouMean(tf.layout, sortedFileNames(x.files)))
+		}
+		for i, u := range tf.uses {
+			if defined == nil {
+				defined = x.definedNames()
+			}
+			if !defined[u] && !contains(tf.uses[:i], u) {
+				x.warn(name, "invokes %q, which is not defined%s", u, didYouMean(u, sortedNames(defined)))
+			}
+		}
+		if tf.layout != "" {
+			continue
+		}
+
+		// child templates override the templates of their layouts, but other files share a namespace
+		for _, d := range tf.defines {
+			if d == name {
+				continue
+			}
+			if _, ok := x.files[d]; ok {
+				x.warn(name, "defines %q, which shadows the template of that name", d)
+				continue
+			}
+			// report files that both changed only once
+			if other := x.definedBy(d, name); other != "" && !(changed[other] && other > name) {
+				x.warn(name, "defines %q, which is also defined in %s", d, other)
+			}
+		}
+	}
+}
+
+// definedBy returns the first other file that is not a child template and defines the named template, or "" if there is none.
+// The caller must hold x.mu.
+func (x *Extemplate) definedBy(define string, except string) string {
+	var names []string
+	for name, tf := range x.files {
+		if name == except || tf.layout != "" {
+			continue
+		}
+		for _, d := range tf.defines {
+			if d == define {
+				names = append(names, name)
+			}
+		}
+	}
+	if len(names) == 0 {
+		return ""
+	}
+	sort.Strings(names)
+	return names[0]
+}
+
+// contains reports whether names contains name
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}